@@ -0,0 +1,26 @@
+// Команда migrate обновляет файлы выгрузки кошелька в каталоге до текущей версии формата.
+//
+//	go run ./cmd/migrate -dir data
+package main
+
+import (
+	"flag"
+	"github.com/akhrorov/wallet/pkg/wallet"
+	"log"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "data directory with dump files (.dump, .csv, .jsonl, optionally .gz or .zst)")
+	flag.Parse()
+
+	migrated, err := wallet.MigrateDir(*dir)
+	for _, name := range migrated {
+		log.Printf("%s: upgraded", name)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(migrated) == 0 {
+		log.Print("all files are up to date")
+	}
+}
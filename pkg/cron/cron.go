@@ -0,0 +1,175 @@
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSpec = errors.New("invalid schedule spec")
+
+// Синонимы для часто используемых расписаний. Все они срабатывают в полночь по UTC.
+var macros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"daily":    "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"weekly":   "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"monthly":  "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// field описывает одно поле расписания и допустимый диапазон значений.
+type field struct {
+	name string
+	min  int
+	max  int
+}
+
+var fields = []field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+// Schedule - разобранное расписание в формате cron: "минута час день-месяца месяц день-недели".
+// Каждое поле - "*", число, диапазон "a-b", шаг "*/n" или "a-b/n" либо список через запятую.
+// День недели 0 и 7 - воскресенье. Как и в cron, если заданы и день месяца, и день недели,
+// достаточно совпадения любого из них. Поле, которое начинается с "*" (в том числе "*/n"),
+// не считается заданным: тогда должны совпасть оба. Время считается в UTC.
+type Schedule struct {
+	spec   string
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	anyDom bool
+	anyDow bool
+}
+
+// Parse разбирает расписание spec. Кроме пяти полей cron понимает
+// @hourly, @daily (daily), @weekly (weekly), @monthly (monthly) и @yearly.
+func Parse(spec string) (*Schedule, error) {
+	expanded := strings.TrimSpace(spec)
+	if macro, ok := macros[expanded]; ok {
+		expanded = macro
+	}
+
+	parts := strings.Fields(expanded)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("%w: %q: want %d fields, got %d", ErrInvalidSpec, spec, len(fields), len(parts))
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %s: %v", ErrInvalidSpec, spec, fields[i].name, err)
+		}
+		sets[i] = set
+	}
+	// воскресенье можно указать и как 0, и как 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &Schedule{
+		spec:   spec,
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		anyDom: strings.HasPrefix(parts[2], "*"),
+		anyDow: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+func parseField(value string, f field) (uint64, error) {
+	set := uint64(0)
+	for _, item := range strings.Split(value, ",") {
+		step := 1
+		if slash := strings.IndexByte(item, '/'); slash >= 0 {
+			parsed, err := strconv.Atoi(item[slash+1:])
+			if err != nil || parsed <= 0 {
+				return 0, fmt.Errorf("invalid step %q", item)
+			}
+			step = parsed
+			item = item[:slash]
+		}
+
+		from, to := f.min, f.max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+			parsed, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", item)
+			}
+			from, to = parsed, parsed
+			if len(bounds) == 2 {
+				to, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, fmt.Errorf("invalid value %q", item)
+				}
+			} else if step > 1 {
+				// "5/15" означает "с 5 до конца диапазона с шагом 15"
+				to = f.max
+			}
+		}
+		if from < f.min || to > f.max || from > to {
+			return 0, fmt.Errorf("value %q out of range %d-%d", item, f.min, f.max)
+		}
+
+		for i := from; i <= to; i += step {
+			set |= 1 << uint(i)
+		}
+	}
+	return set, nil
+}
+
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// Next возвращает ближайшее время срабатывания строго после after.
+// Если за ближайшие пять лет расписание не срабатывает (например, "0 0 30 2 *"), возвращает нулевое время.
+func (s *Schedule) Next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + 5
+
+	for t.Year() <= limit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDom || s.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,63 @@
+package cron
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSchedule_Next(t *testing.T) {
+	// 2021-03-03 - среда
+	from := time.Date(2021, 3, 3, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"daily", time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{"weekly", time.Date(2021, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{"monthly", time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2021, 3, 3, 11, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2021, 3, 3, 10, 45, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2021, 3, 4, 10, 30, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2021, 3, 4, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2021, 3, 7, 9, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2021, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// день месяца или день недели: 10-е число или ближайшая пятница
+		{"0 0 10 * 5", time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC)},
+		// шаг "*/2" не задаёт день месяца: нечётное число, которое приходится на понедельник
+		{"0 0 */2 * 1", time.Date(2021, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"5,35 10 * * *", time.Date(2021, 3, 3, 10, 35, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		schedule, err := Parse(test.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): can't parse, %v", test.spec, err)
+			continue
+		}
+		got := schedule.Next(from)
+		if !got.Equal(test.want) {
+			t.Errorf("Next(%q): want %v, result %v", test.spec, test.want, got)
+		}
+	}
+}
+
+func TestSchedule_Next_never(t *testing.T) {
+	schedule, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("Parse(): can't parse, %v", err)
+	}
+	got := schedule.Next(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	if !got.IsZero() {
+		t.Errorf("Next(): want zero time, result %v", got)
+	}
+}
+
+func TestParse_invalid(t *testing.T) {
+	for _, spec := range []string{"", "yearly", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err := Parse(spec)
+		if !errors.Is(err, ErrInvalidSpec) {
+			t.Errorf("Parse(%q): must return ErrInvalidSpec, returned %v", spec, err)
+		}
+	}
+}
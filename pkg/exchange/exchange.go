@@ -0,0 +1,134 @@
+package exchange
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/types"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+)
+
+var ErrRateNotFound = errors.New("exchange rate not found")
+var ErrInvalidRate = errors.New("invalid exchange rate")
+var ErrOverflow = errors.New("converted amount overflows")
+
+// Converter переводит суммы из одной валюты в другую.
+type Converter interface {
+	Convert(amount types.Amount, to types.Currency) (types.Amount, error)
+}
+
+type pair struct {
+	from types.Currency
+	to   types.Currency
+}
+
+// Table - таблица курсов валют, которая хранится локально (например, загружается из файла через LoadTable).
+// Все валюты считаются в сотых долях (дирамы, копейки, центы), поэтому курс применяется прямо к минимальным единицам.
+// Если прямого курса нет, используется обратный. Table безопасна для одновременного использования.
+type Table struct {
+	mu    sync.RWMutex
+	rates map[pair]*big.Rat
+}
+
+func NewTable() *Table {
+	return &Table{rates: make(map[pair]*big.Rat)}
+}
+
+// Set задаёт курс: одна единица from стоит rate единиц to. rate - десятичная запись, например "10.95".
+func (t *Table) Set(from types.Currency, to types.Currency, rate string) error {
+	if !from.Valid() || !to.Valid() || from == to {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidRate, from, to)
+	}
+	value, ok := new(big.Rat).SetString(rate)
+	if !ok || value.Sign() <= 0 {
+		return fmt.Errorf("%w: %q", ErrInvalidRate, rate)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rates[pair{from: from, to: to}] = value
+	return nil
+}
+
+func (t *Table) rate(from types.Currency, to types.Currency) (*big.Rat, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if rate, ok := t.rates[pair{from: from, to: to}]; ok {
+		return rate, true
+	}
+	if rate, ok := t.rates[pair{from: to, to: from}]; ok {
+		return new(big.Rat).Inv(rate), true
+	}
+	return nil, false
+}
+
+// Convert переводит amount в валюту to по курсу из таблицы, округляя до минимальной единицы
+// (половина округляется от нуля).
+func (t *Table) Convert(amount types.Amount, to types.Currency) (types.Amount, error) {
+	if amount.Currency == to {
+		return amount, nil
+	}
+	rate, ok := t.rate(amount.Currency, to)
+	if !ok {
+		return types.Amount{}, fmt.Errorf("%w: %s -> %s", ErrRateNotFound, amount.Currency, to)
+	}
+
+	value := new(big.Rat).Mul(new(big.Rat).SetInt64(int64(amount.Value)), rate)
+	rounded := round(value)
+	if !rounded.IsInt64() {
+		return types.Amount{}, fmt.Errorf("%w: %s -> %s", ErrOverflow, amount, to)
+	}
+	return types.Amount{Value: types.Money(rounded.Int64()), Currency: to}, nil
+}
+
+func round(value *big.Rat) *big.Int {
+	quo, rem := new(big.Int).QuoRem(value.Num(), value.Denom(), new(big.Int))
+	rem.Abs(rem).Lsh(rem, 1)
+	if rem.Cmp(value.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(int64(value.Sign())))
+	}
+	return quo
+}
+
+// LoadTable читает таблицу курсов из файла path. Каждая строка имеет вид "FROM;TO;RATE",
+// например "USD;TJS;10.95". Пустые строки и строки, начинающиеся с "#", пропускаются.
+func LoadTable(path string) (*Table, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+
+	table := NewTable()
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		item := strings.Split(line, ";")
+		if len(item) != 3 {
+			return nil, fmt.Errorf("%s:%d: %w: %q", path, lineNum, ErrInvalidRate, line)
+		}
+		err = table.Set(types.Currency(item[0]), types.Currency(item[1]), item[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
@@ -0,0 +1,83 @@
+package exchange
+
+import (
+	"errors"
+	"github.com/akhrorov/wallet/pkg/types"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestTable_Convert(t *testing.T) {
+	table := NewTable()
+	err := table.Set(types.CurrencyUSD, types.CurrencyTJS, "10.95")
+	if err != nil {
+		t.Fatalf("Set(): can't set rate, %v", err)
+	}
+
+	tests := []struct {
+		amount types.Amount
+		to     types.Currency
+		want   types.Amount
+	}{
+		{types.Amount{Value: 100_00, Currency: types.CurrencyUSD}, types.CurrencyTJS, types.Amount{Value: 1095_00, Currency: types.CurrencyTJS}},
+		{types.Amount{Value: 1095_00, Currency: types.CurrencyTJS}, types.CurrencyUSD, types.Amount{Value: 100_00, Currency: types.CurrencyUSD}},
+		// 1 / 10.95 = 0.0913... -> 0; 6 / 10.95 = 0.547... -> 1
+		{types.Amount{Value: 1, Currency: types.CurrencyTJS}, types.CurrencyUSD, types.Amount{Value: 0, Currency: types.CurrencyUSD}},
+		{types.Amount{Value: 6, Currency: types.CurrencyTJS}, types.CurrencyUSD, types.Amount{Value: 1, Currency: types.CurrencyUSD}},
+		{types.Amount{Value: -6, Currency: types.CurrencyTJS}, types.CurrencyUSD, types.Amount{Value: -1, Currency: types.CurrencyUSD}},
+		{types.Amount{Value: 5, Currency: types.CurrencyRUB}, types.CurrencyRUB, types.Amount{Value: 5, Currency: types.CurrencyRUB}},
+	}
+	for _, test := range tests {
+		got, err := table.Convert(test.amount, test.to)
+		if err != nil || got != test.want {
+			t.Errorf("Convert(%v, %v): want %v, result %v, %v", test.amount, test.to, test.want, got, err)
+		}
+	}
+
+	_, err = table.Convert(types.Amount{Value: 1, Currency: types.CurrencyRUB}, types.CurrencyUSD)
+	if !errors.Is(err, ErrRateNotFound) {
+		t.Errorf("Convert(): must return ErrRateNotFound, returned %v", err)
+	}
+}
+
+func TestTable_Set_invalid(t *testing.T) {
+	table := NewTable()
+	for _, rate := range []string{"", "abc", "0", "-1"} {
+		err := table.Set(types.CurrencyUSD, types.CurrencyTJS, rate)
+		if !errors.Is(err, ErrInvalidRate) {
+			t.Errorf("Set(%q): must return ErrInvalidRate, returned %v", rate, err)
+		}
+	}
+	err := table.Set("usd", types.CurrencyTJS, "1")
+	if !errors.Is(err, ErrInvalidRate) {
+		t.Errorf("Set(): must reject invalid currency, returned %v", err)
+	}
+}
+
+func TestLoadTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.txt")
+	err := ioutil.WriteFile(path, []byte("# курсы на 01.03.2021\nUSD;TJS;11.30\n\nRUB;TJS;0.1525\n"), 0666)
+	if err != nil {
+		t.Fatalf("WriteFile(): can't write rates, %v", err)
+	}
+
+	table, err := LoadTable(path)
+	if err != nil {
+		t.Fatalf("LoadTable(): can't load rates, %v", err)
+	}
+	got, err := table.Convert(types.Amount{Value: 1000_00, Currency: types.CurrencyRUB}, types.CurrencyTJS)
+	want := types.Amount{Value: 152_50, Currency: types.CurrencyTJS}
+	if err != nil || got != want {
+		t.Errorf("Convert(): want %v, result %v, %v", want, got, err)
+	}
+
+	err = ioutil.WriteFile(path, []byte("USD;TJS;11.30\nUSD;TJS\n"), 0666)
+	if err != nil {
+		t.Fatalf("WriteFile(): can't write rates, %v", err)
+	}
+	_, err = LoadTable(path)
+	if !errors.Is(err, ErrInvalidRate) {
+		t.Errorf("LoadTable(): must return ErrInvalidRate, returned %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package phone
+
+import (
+	"errors"
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/types"
+	"strings"
+)
+
+var ErrInvalidPhone = errors.New("invalid phone number")
+var ErrUnsupportedCountry = errors.New("unsupported phone country code")
+
+// Country описывает страну, номера которой обслуживает кошелёк.
+type Country struct {
+	// Code - телефонный код страны без "+", например "992".
+	Code string
+	Name string
+	// NationalLength - число цифр номера после кода страны.
+	NationalLength int
+}
+
+// Countries - страны, номера которых принимаются: Таджикистан и соседи.
+var Countries = []Country{
+	{Code: "992", Name: "Tajikistan", NationalLength: 9},
+	{Code: "998", Name: "Uzbekistan", NationalLength: 9},
+	{Code: "996", Name: "Kyrgyzstan", NationalLength: 9},
+	{Code: "993", Name: "Turkmenistan", NationalLength: 8},
+	{Code: "93", Name: "Afghanistan", NationalLength: 9},
+	{Code: "7", Name: "Russia and Kazakhstan", NationalLength: 10},
+}
+
+// maxDigits - наибольшее число цифр в номере E.164.
+const maxDigits = 15
+
+// Number - разобранный телефонный номер.
+type Number struct {
+	Country  Country
+	National string
+}
+
+// String возвращает номер в формате E.164, например "+992900000001".
+func (n Number) String() string {
+	return "+" + n.Country.Code + n.National
+}
+
+// Phone возвращает номер в каноническом виде для types.Phone.
+func (n Number) Phone() types.Phone {
+	return types.Phone(n.String())
+}
+
+// Parse разбирает номер в международном формате: "+" или "00", код страны и номер.
+// Пробелы, дефисы, точки и скобки между цифрами игнорируются, например "+992 (90) 000-00-01".
+func Parse(raw string) (Number, error) {
+	value := strings.TrimSpace(raw)
+	switch {
+	case strings.HasPrefix(value, "+"):
+		value = value[1:]
+	case strings.HasPrefix(value, "00"):
+		value = value[2:]
+	default:
+		return Number{}, fmt.Errorf("%w: %q must start with + and country code", ErrInvalidPhone, raw)
+	}
+
+	digits := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c >= '0' && c <= '9':
+			digits = append(digits, c)
+		case c == ' ' || c == '-' || c == '.' || c == '(' || c == ')':
+		default:
+			return Number{}, fmt.Errorf("%w: %q contains %q", ErrInvalidPhone, raw, c)
+		}
+	}
+	if len(digits) == 0 || len(digits) > maxDigits {
+		return Number{}, fmt.Errorf("%w: %q", ErrInvalidPhone, raw)
+	}
+
+	// коды стран не являются префиксами друг друга, поэтому подходит не больше одной страны
+	number := string(digits)
+	for _, country := range Countries {
+		if !strings.HasPrefix(number, country.Code) {
+			continue
+		}
+		national := number[len(country.Code):]
+		if len(national) != country.NationalLength {
+			return Number{}, fmt.Errorf("%w: %q must have %d digits after +%s", ErrInvalidPhone, raw, country.NationalLength, country.Code)
+		}
+		return Number{Country: country, National: national}, nil
+	}
+	return Number{}, fmt.Errorf("%w: %q", ErrUnsupportedCountry, raw)
+}
+
+// Normalize возвращает номер raw в формате E.164.
+func Normalize(raw string) (types.Phone, error) {
+	number, err := Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	return number.Phone(), nil
+}
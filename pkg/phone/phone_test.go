@@ -0,0 +1,59 @@
+package phone
+
+import (
+	"errors"
+	"github.com/akhrorov/wallet/pkg/types"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want types.Phone
+	}{
+		{"+992900000001", "+992900000001"},
+		{"+992 900-00-00-01", "+992900000001"},
+		{" +992 (90) 000.00.01 ", "+992900000001"},
+		{"00992900000001", "+992900000001"},
+		{"+998 90 123 45 67", "+998901234567"},
+		{"+996 555 123 456", "+996555123456"},
+		{"+993 65 123456", "+99365123456"},
+		{"+93 70 123 4567", "+93701234567"},
+		{"+7 (701) 123-45-67", "+77011234567"},
+	}
+	for _, test := range tests {
+		got, err := Normalize(test.raw)
+		if err != nil || got != test.want {
+			t.Errorf("Normalize(%q): want %q, result %q, %v", test.raw, test.want, got, err)
+		}
+	}
+}
+
+func TestParse_invalid(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want error
+	}{
+		{"", ErrInvalidPhone},
+		{"992900000001", ErrInvalidPhone},
+		{"+", ErrInvalidPhone},
+		{"+992 90000000", ErrInvalidPhone},
+		{"+992 9000000011", ErrInvalidPhone},
+		{"+992-900-abc-001", ErrInvalidPhone},
+		{"+1 202 555 0100", ErrUnsupportedCountry},
+		{"+9929000000011234567", ErrInvalidPhone},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.raw)
+		if !errors.Is(err, test.want) {
+			t.Errorf("Parse(%q): must return %v, returned %v", test.raw, test.want, err)
+		}
+	}
+}
+
+func TestParse_country(t *testing.T) {
+	number, err := Parse("+996555123456")
+	if err != nil || number.Country.Name != "Kyrgyzstan" || number.National != "555123456" {
+		t.Errorf("Parse(): want Kyrgyz number, result %v, %v", number, err)
+	}
+}
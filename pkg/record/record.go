@@ -0,0 +1,89 @@
+package record
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidEscape = errors.New("invalid escape sequence")
+
+// Separator разделяет поля записи.
+const Separator = ';'
+
+// Join записывает поля через Separator. Разделители полей и записей (";" и "|"), перевод строки
+// и обратная косая черта внутри поля экранируются "\", поэтому поле может содержать любой текст.
+func Join(fields ...string) string {
+	builder := strings.Builder{}
+	for i, field := range fields {
+		if i > 0 {
+			builder.WriteByte(Separator)
+		}
+		for j := 0; j < len(field); j++ {
+			switch c := field[j]; c {
+			case '\\', ';', '|':
+				builder.WriteByte('\\')
+				builder.WriteByte(c)
+			case '\n':
+				builder.WriteString(`\n`)
+			case '\r':
+				builder.WriteString(`\r`)
+			default:
+				builder.WriteByte(c)
+			}
+		}
+	}
+	return builder.String()
+}
+
+// Split разбирает запись, записанную Join, на поля.
+func Split(line string) ([]string, error) {
+	fields := []string{}
+	field := strings.Builder{}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch c {
+		case Separator:
+			fields = append(fields, field.String())
+			field.Reset()
+		case '\\':
+			i++
+			if i == len(line) {
+				return nil, ErrInvalidEscape
+			}
+			switch line[i] {
+			case '\\', ';', '|':
+				field.WriteByte(line[i])
+			case 'n':
+				field.WriteByte('\n')
+			case 'r':
+				field.WriteByte('\r')
+			default:
+				return nil, ErrInvalidEscape
+			}
+		default:
+			field.WriteByte(c)
+		}
+	}
+	return append(fields, field.String()), nil
+}
+
+// ScanRecords возвращает функцию разбора для bufio.Scanner, которая делит поток на записи
+// по неэкранированному байту sep, не раскрывая экранирование. Подходит для потоков,
+// в которых записи Join разделены, например, "|".
+func ScanRecords(sep byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		for i := 0; i < len(data); i++ {
+			switch data[i] {
+			case '\\':
+				i++
+			case sep:
+				return i + 1, data[:i], nil
+			}
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
@@ -0,0 +1,59 @@
+package record
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJoin_Split(t *testing.T) {
+	tests := [][]string{
+		{"1", "+992900000001", "100"},
+		{"f1", "my;car", `C:\cars`, "line\nbreak\r", "a|b"},
+		{"", "", ""},
+		{"single"},
+	}
+	for _, fields := range tests {
+		line := Join(fields...)
+		got, err := Split(line)
+		if err != nil || !reflect.DeepEqual(got, fields) {
+			t.Errorf("Split(Join(%q)): result %q, %v", fields, got, err)
+		}
+	}
+}
+
+func TestJoin_escapes(t *testing.T) {
+	got := Join("my;car", "a\nb")
+	want := `my\;car;a\nb`
+	if got != want {
+		t.Errorf("Join(): want %q, result %q", want, got)
+	}
+}
+
+func TestSplit_invalidEscape(t *testing.T) {
+	for _, line := range []string{`abc\`, `a\tb`} {
+		_, err := Split(line)
+		if err != ErrInvalidEscape {
+			t.Errorf("Split(%q): must return ErrInvalidEscape, returned %v", line, err)
+		}
+	}
+}
+
+func TestScanRecords(t *testing.T) {
+	scanner := bufio.NewScanner(strings.NewReader(Join("1", "a|b") + "|" + Join("2", `c\`) + "|"))
+	// маленький буфер, чтобы экранирование попадало на границу порций
+	scanner.Buffer(make([]byte, 4), 64)
+	scanner.Split(ScanRecords('|'))
+	got := []string{}
+	for scanner.Scan() {
+		got = append(got, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("ScanRecords(): can't scan, %v", err)
+	}
+	want := []string{`1;a\|b`, `2;c\\`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ScanRecords(): want %q, result %q", want, got)
+	}
+}
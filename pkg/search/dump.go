@@ -0,0 +1,127 @@
+package search
+
+import (
+	"context"
+	"github.com/akhrorov/wallet/pkg/types"
+	"github.com/akhrorov/wallet/pkg/wallet"
+	"log"
+	"os"
+	"sync"
+)
+
+// Payments параллельно (по одной горутине на файл) просматривает payments и все шарды paymentsN
+// в каталоге dir в любом формате выгрузки, в том числе сжатые (см. wallet.DumpFiles), и отправляет в канал платежи,
+// для которых predicate возвращает true. Если predicate равен nil, отправляются все платежи.
+// Канал закрывается, когда все файлы просмотрены или ctx отменён.
+func Payments(ctx context.Context, dir string, predicate func(payment types.Payment) bool) (<-chan types.Payment, error) {
+	files, err := dumpFiles(dir, "payments")
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan types.Payment)
+	go func() {
+		defer close(ch)
+		scanFiles(files, func(path string) (*wallet.ImportReport, error) {
+			return wallet.ReadPayments(path, func(payment *types.Payment) bool {
+				if predicate != nil && !predicate(*payment) {
+					return ctx.Err() == nil
+				}
+				select {
+				case <-ctx.Done():
+					return false
+				case ch <- *payment:
+					return true
+				}
+			})
+		})
+	}()
+
+	return ch, nil
+}
+
+// Accounts просматривает accounts в каталоге dir и отправляет в канал
+// счета, для которых predicate возвращает true.
+func Accounts(ctx context.Context, dir string, predicate func(account types.Account) bool) (<-chan types.Account, error) {
+	files, err := dumpFiles(dir, "accounts")
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan types.Account)
+	go func() {
+		defer close(ch)
+		scanFiles(files, func(path string) (*wallet.ImportReport, error) {
+			return wallet.ReadAccounts(path, func(account *types.Account) bool {
+				if predicate != nil && !predicate(*account) {
+					return ctx.Err() == nil
+				}
+				select {
+				case <-ctx.Done():
+					return false
+				case ch <- *account:
+					return true
+				}
+			})
+		})
+	}()
+
+	return ch, nil
+}
+
+// Favorites просматривает favorites в каталоге dir и отправляет в канал
+// избранные платежи, для которых predicate возвращает true.
+func Favorites(ctx context.Context, dir string, predicate func(favorite types.Favorite) bool) (<-chan types.Favorite, error) {
+	files, err := dumpFiles(dir, "favorites")
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan types.Favorite)
+	go func() {
+		defer close(ch)
+		scanFiles(files, func(path string) (*wallet.ImportReport, error) {
+			return wallet.ReadFavorites(path, func(favorite *types.Favorite) bool {
+				if predicate != nil && !predicate(*favorite) {
+					return ctx.Err() == nil
+				}
+				select {
+				case <-ctx.Done():
+					return false
+				case ch <- *favorite:
+					return true
+				}
+			})
+		})
+	}()
+
+	return ch, nil
+}
+
+// dumpFiles возвращает файлы выгрузки с записями вида entity из каталога dir.
+func dumpFiles(dir string, entity string) ([]string, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	return wallet.DumpFiles(dir, entity)
+}
+
+// scanFiles читает каждый файл через read в отдельной горутине.
+// Ошибки разбора записей и чтения файлов логируются и не прерывают просмотр остальных файлов.
+func scanFiles(files []string, read func(path string) (*wallet.ImportReport, error)) {
+	wg := sync.WaitGroup{}
+	for _, path := range files {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			report, err := read(path)
+			for _, skipped := range report.Skipped {
+				log.Print(skipped)
+			}
+			if err != nil {
+				log.Print(err)
+			}
+		}(path)
+	}
+	wg.Wait()
+}
@@ -1 +1,151 @@
 package search
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Result описывает одно найденное вхождение фразы.
+type Result struct {
+	// Phrase - фраза, которую искали.
+	Phrase string
+	// Line - строка целиком, в которой найдена фраза (без символа перевода строки).
+	Line string
+	// LineNum - номер строки, начиная с 1.
+	LineNum int64
+	// ColNum - номер позиции в строке, начиная с 1.
+	ColNum int64
+	// File - индекс файла в переданном срезе.
+	File int
+}
+
+// All ищет все вхождения phrase в files (по одной горутине на файл)
+// и отправляет в канал один срез со всеми найденными результатами.
+// Если ctx отменён до завершения поиска, канал закрывается без результата.
+func All(ctx context.Context, phrase string, files []string) <-chan []Result {
+	ch := make(chan []Result, 1)
+
+	go func() {
+		defer close(ch)
+
+		wg := sync.WaitGroup{}
+		mu := sync.Mutex{}
+		results := []Result{}
+
+		for i, file := range files {
+			wg.Add(1)
+			go func(index int, file string) {
+				defer wg.Done()
+				found, err := find(ctx, phrase, index, strings.NewReader(file), false)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				results = append(results, found...)
+			}(i, file)
+		}
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		sort.Slice(results, func(i, j int) bool {
+			if results[i].File != results[j].File {
+				return results[i].File < results[j].File
+			}
+			if results[i].LineNum != results[j].LineNum {
+				return results[i].LineNum < results[j].LineNum
+			}
+			return results[i].ColNum < results[j].ColNum
+		})
+		ch <- results
+	}()
+
+	return ch
+}
+
+// Any ищет первое вхождение phrase в files (по одной горутине на файл).
+// Как только одна из горутин находит совпадение, остальные отменяются через контекст.
+// Если совпадений нет или ctx отменён, канал закрывается без результата.
+func Any(ctx context.Context, phrase string, files []string) <-chan Result {
+	ch := make(chan Result, 1)
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		defer close(ch)
+		defer cancel()
+
+		wg := sync.WaitGroup{}
+		once := sync.Once{}
+
+		for i, file := range files {
+			wg.Add(1)
+			go func(index int, file string) {
+				defer wg.Done()
+				found, err := find(ctx, phrase, index, strings.NewReader(file), true)
+				if err != nil || len(found) == 0 {
+					return
+				}
+				once.Do(func() {
+					ch <- found[0]
+					cancel()
+				})
+			}(i, file)
+		}
+		wg.Wait()
+	}()
+
+	return ch
+}
+
+// find построчно читает reader и возвращает вхождения phrase.
+// Если first == true, поиск прекращается на первом вхождении.
+// При отмене ctx возвращает ctx.Err().
+func find(ctx context.Context, phrase string, index int, reader io.Reader, first bool) ([]Result, error) {
+	results := []Result{}
+	if phrase == "" {
+		return results, nil
+	}
+
+	scanner := bufio.NewScanner(reader)
+	lineNum := int64(0)
+	for scanner.Scan() {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		lineNum++
+		line := scanner.Text()
+		offset := 0
+		for {
+			col := strings.Index(line[offset:], phrase)
+			if col < 0 {
+				break
+			}
+			results = append(results, Result{
+				Phrase:  phrase,
+				Line:    line,
+				LineNum: lineNum,
+				ColNum:  int64(offset + col + 1),
+				File:    index,
+			})
+			if first {
+				return results, nil
+			}
+			offset += col + len(phrase)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,176 @@
+package search
+
+import (
+	"context"
+	"github.com/akhrorov/wallet/pkg/types"
+	"github.com/akhrorov/wallet/pkg/wallet"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testFiles = []string{
+	"1. aaa, bbb, ccc\n2. ddd, eee, fff\n3. ggg, hhh, iii\n",
+	"4. jjj, kkk, lll\n5. xxx, nnn, ooo\n6. ppp, qqq, rrr\n",
+	"7. sss, ttt, uuu\n8. vvv, www, xxx\n9. yyy, bbb, zzz\n",
+}
+
+func TestAll_success(t *testing.T) {
+	result := <-All(context.Background(), "xxx", testFiles)
+
+	want := []Result{
+		{Phrase: "xxx", Line: "5. xxx, nnn, ooo", LineNum: 2, ColNum: 4, File: 1},
+		{Phrase: "xxx", Line: "8. vvv, www, xxx", LineNum: 2, ColNum: 14, File: 2},
+	}
+	if !reflect.DeepEqual(want, result) {
+		t.Errorf("All(): want %v, result %v", want, result)
+	}
+}
+
+func TestAll_notFound(t *testing.T) {
+	result, ok := <-All(context.Background(), "zzz-not-exist", testFiles)
+	if !ok {
+		t.Errorf("All(): channel closed without result")
+		return
+	}
+	if len(result) != 0 {
+		t.Errorf("All(): must return empty result, returned %v", result)
+	}
+}
+
+func TestAll_canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, ok := <-All(ctx, "xxx", testFiles)
+	if ok {
+		t.Errorf("All(): must close channel on canceled context, returned %v", result)
+	}
+}
+
+func TestAny_success(t *testing.T) {
+	result, ok := <-Any(context.Background(), "bbb", testFiles)
+	if !ok {
+		t.Errorf("Any(): channel closed without result")
+		return
+	}
+	if result.Phrase != "bbb" || result.ColNum != 9 {
+		t.Errorf("Any(): wrong result returned, %v", result)
+	}
+	if result.File != 0 && result.File != 2 {
+		t.Errorf("Any(): wrong file returned, %v", result)
+	}
+}
+
+func TestAny_notFound(t *testing.T) {
+	result, ok := <-Any(context.Background(), "zzz-not-exist", testFiles)
+	if ok {
+		t.Errorf("Any(): must close channel without result, returned %v", result)
+	}
+}
+
+func TestPayments_success(t *testing.T) {
+	dir := t.TempDir()
+	shards := map[string]string{
+		"payments1.dump": "#wallet;payments;2\np1;100;auto;1;INPROGRESS\np2;200;food;1;OK\n",
+		"payments2.dump": "p3;300;food;2;INPROGRESS\nbroken\n",
+		"accounts.dump":  "1;+992900000001;900000\n",
+	}
+	for name, content := range shards {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666)
+		if err != nil {
+			t.Fatalf("Payments(): can't write shard, %v", err)
+		}
+	}
+
+	ch, err := Payments(context.Background(), dir, func(payment types.Payment) bool {
+		return payment.Category == "food"
+	})
+	if err != nil {
+		t.Fatalf("Payments(): can't search, %v", err)
+	}
+
+	found := map[string]types.Payment{}
+	for payment := range ch {
+		found[payment.ID] = payment
+	}
+
+	want := map[string]types.Payment{
+		"p2": {ID: "p2", Amount: 200, Category: "food", AccountID: 1, Status: types.PaymentStatusOk, Currency: types.DefaultCurrency},
+		"p3": {ID: "p3", Amount: 300, Category: "food", AccountID: 2, Status: types.PaymentStatusInProgress, Currency: types.DefaultCurrency},
+	}
+	if !reflect.DeepEqual(want, found) {
+		t.Errorf("Payments(): want %v, result %v", want, found)
+	}
+}
+
+func TestPayments_fail(t *testing.T) {
+	_, err := Payments(context.Background(), filepath.Join(t.TempDir(), "not-exist"), nil)
+	if err == nil {
+		t.Errorf("Payments(): must return error, returned nil")
+	}
+}
+
+func TestFavorites_escaped(t *testing.T) {
+	dir := t.TempDir()
+	content := "#wallet;favorites;3\n" + `f1;100;auto;1;my\;car;0;0` + "\n"
+	err := ioutil.WriteFile(filepath.Join(dir, "favorites.dump"), []byte(content), 0666)
+	if err != nil {
+		t.Fatalf("Favorites(): can't write dump, %v", err)
+	}
+
+	ch, err := Favorites(context.Background(), dir, nil)
+	if err != nil {
+		t.Fatalf("Favorites(): can't search, %v", err)
+	}
+	found := []types.Favorite{}
+	for favorite := range ch {
+		found = append(found, favorite)
+	}
+
+	want := []types.Favorite{{ID: "f1", Amount: 100, Category: "auto", AccountID: 1, Name: "my;car"}}
+	if !reflect.DeepEqual(want, found) {
+		t.Errorf("Favorites(): want %v, result %v", want, found)
+	}
+}
+
+func TestPayments_formats(t *testing.T) {
+	service := &wallet.Service{}
+	account, err := service.RegisterAccount("+992900000001")
+	if err != nil {
+		t.Fatalf("RegisterAccount(): can't register account, %v", err)
+	}
+	err = service.Deposit(account.ID, 1_000_00)
+	if err != nil {
+		t.Fatalf("Deposit(): can't deposit, %v", err)
+	}
+	payment, err := service.Pay(account.ID, 100_00, "food")
+	if err != nil {
+		t.Fatalf("Pay(): can't pay, %v", err)
+	}
+	err = service.SetCompression(wallet.CompressionGzip)
+	if err != nil {
+		t.Fatalf("SetCompression(): can't set compression, %v", err)
+	}
+
+	for _, format := range []wallet.Format{wallet.FormatDump, wallet.FormatCSV, wallet.FormatJSON} {
+		dir := t.TempDir()
+		err = service.ExportAs(dir, format)
+		if err != nil {
+			t.Fatalf("ExportAs(%s): can't export, %v", format, err)
+		}
+
+		ch, err := Payments(context.Background(), dir, nil)
+		if err != nil {
+			t.Fatalf("Payments(%s): can't search, %v", format, err)
+		}
+		found := []types.Payment{}
+		for payment := range ch {
+			found = append(found, payment)
+		}
+		if len(found) != 1 || !reflect.DeepEqual(found[0], *payment) {
+			t.Errorf("Payments(%s): want %v, result %v", format, payment, found)
+		}
+	}
+}
@@ -1,11 +1,59 @@
 package types
 
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
 // Money представляет собой денежную сумму в минимальных единицах (центы, копейки, дирамы и т.д.).
 type Money int64
 
+// Currency представляет собой код валюты по ISO 4217.
+type Currency string
+
+// Валюты, с которыми работает кошелёк.
+const (
+	CurrencyTJS Currency = "TJS"
+	CurrencyRUB Currency = "RUB"
+	CurrencyUSD Currency = "USD"
+)
+
+// DefaultCurrency - валюта счетов и платежей, для которых валюта не указана (в том числе загруженных из старых файлов).
+const DefaultCurrency = CurrencyTJS
+
+// Valid проверяет, что код валюты состоит из трёх заглавных латинских букв.
+func (c Currency) Valid() bool {
+	if len(c) != 3 {
+		return false
+	}
+	for _, r := range c {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Amount представляет собой денежную сумму в минимальных единицах вместе с её валютой.
+type Amount struct {
+	Value    Money
+	Currency Currency
+}
+
+func (a Amount) String() string {
+	return fmt.Sprintf("%d %s", a.Value, a.Currency)
+}
+
 // PaymentCategory представляет собой категорию, в которой был совершён платёж (авто, аптеки, рестораны и т.д.).
 type PaymentCategory string
 
+// Категории платежей, которые создаёт перевод между счетами: списание со счёта отправителя и зачисление на счёт получателя.
+const (
+	PaymentCategoryTransferOut PaymentCategory = "transfer-out"
+	PaymentCategoryTransferIn  PaymentCategory = "transfer-in"
+)
+
 // PaymentStatus представляет собой статус платежа.
 type PaymentStatus string
 
@@ -14,8 +62,43 @@ const (
 	PaymentStatusOk         PaymentStatus = "OK"
 	PaymentStatusFail       PaymentStatus = "FAIL"
 	PaymentStatusInProgress PaymentStatus = "INPROGRESS"
+	PaymentStatusExpired    PaymentStatus = "EXPIRED"
 )
 
+// ErrInvalidStatusTransition возвращается (в обёртке StatusTransitionError) при недопустимой смене статуса платежа.
+var ErrInvalidStatusTransition = errors.New("invalid payment status transition")
+
+// StatusTransitionError описывает недопустимую смену статуса платежа.
+type StatusTransitionError struct {
+	From PaymentStatus
+	To   PaymentStatus
+}
+
+func (e *StatusTransitionError) Error() string {
+	return fmt.Sprintf("invalid payment status transition: %s -> %s", e.From, e.To)
+}
+
+func (e *StatusTransitionError) Unwrap() error {
+	return ErrInvalidStatusTransition
+}
+
+// paymentTransitions описывает допустимые переходы: платёж в обработке можно подтвердить,
+// отклонить или пометить просроченным. Остальные статусы конечные.
+var paymentTransitions = map[PaymentStatus][]PaymentStatus{
+	PaymentStatusInProgress: {PaymentStatusOk, PaymentStatusFail, PaymentStatusExpired},
+}
+
+// Transition проверяет, можно ли сменить статус s на to.
+// Если нельзя, возвращает *StatusTransitionError.
+func (s PaymentStatus) Transition(to PaymentStatus) error {
+	for _, allowed := range paymentTransitions[s] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return &StatusTransitionError{From: s, To: to}
+}
+
 // Payment представляет информацию о платеже.
 type Payment struct {
 	ID        string
@@ -23,27 +106,145 @@ type Payment struct {
 	Amount    Money
 	Category  PaymentCategory
 	Status    PaymentStatus
+	// Currency - валюта Amount, совпадает с валютой счёта.
+	Currency Currency
+	// LinkedID - ID второй стороны перевода между счетами, для обычных платежей пустой.
+	LinkedID string
+	// CreatedAt и UpdatedAt - время создания и последнего изменения статуса.
+	// Для записей, загруженных из старых файлов без времени, нулевые.
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
 type Phone string
 
+// AccountStatus представляет собой состояние счёта.
+type AccountStatus string
+
+// Предопределённые состояния счетов. Платежи и пополнения возможны только для активного счёта.
+const (
+	AccountStatusActive  AccountStatus = "ACTIVE"
+	AccountStatusBlocked AccountStatus = "BLOCKED"
+	AccountStatusClosed  AccountStatus = "CLOSED"
+)
+
+// ErrInvalidAccountStatusTransition возвращается (в обёртке AccountStatusTransitionError) при недопустимой смене состояния счёта.
+var ErrInvalidAccountStatusTransition = errors.New("invalid account status transition")
+
+// AccountStatusTransitionError описывает недопустимую смену состояния счёта.
+type AccountStatusTransitionError struct {
+	From AccountStatus
+	To   AccountStatus
+}
+
+func (e *AccountStatusTransitionError) Error() string {
+	return fmt.Sprintf("invalid account status transition: %s -> %s", e.From, e.To)
+}
+
+func (e *AccountStatusTransitionError) Unwrap() error {
+	return ErrInvalidAccountStatusTransition
+}
+
+// accountTransitions описывает допустимые переходы: активный счёт можно заблокировать,
+// заблокированный - разблокировать, закрыть можно любой незакрытый. Закрытый счёт не меняется.
+var accountTransitions = map[AccountStatus][]AccountStatus{
+	AccountStatusActive:  {AccountStatusBlocked, AccountStatusClosed},
+	AccountStatusBlocked: {AccountStatusActive, AccountStatusClosed},
+}
+
+// Transition проверяет, можно ли сменить состояние s на to.
+// Если нельзя, возвращает *AccountStatusTransitionError.
+func (s AccountStatus) Transition(to AccountStatus) error {
+	for _, allowed := range accountTransitions[s] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return &AccountStatusTransitionError{From: s, To: to}
+}
+
 // Account представляет информацию о счёте пользователя.
 type Account struct {
 	ID      int64
 	Phone   Phone
 	Balance Money
+	// Currency - валюта счёта, в ней хранится Balance и проводятся все платежи.
+	Currency Currency
+	// Status - состояние счёта, для счетов из старых файлов без состояния - AccountStatusActive.
+	Status AccountStatus
+	// CreatedAt и UpdatedAt - время регистрации и последнего изменения счёта.
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
 // Favorite представляет информацию об элементе "Избранное".
+// Amount указывается в валюте счёта AccountID.
 type Favorite struct {
 	ID        string
 	AccountID int64
 	Amount    Money
 	Name      string
 	Category  PaymentCategory
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Schedule представляет собой расписание регулярного платежа по элементу "Избранное".
+type Schedule struct {
+	ID         string
+	FavoriteID string
+	// Spec - расписание в формате cron либо daily, weekly, monthly (см. пакет cron).
+	Spec string
+	// NextRun - время следующей попытки платежа, нулевое - расписание больше не сработает.
+	NextRun time.Time
+	// Attempts - число неудачных попыток текущего платежа, LastError - ошибка последней неудачной попытки.
+	Attempts      int
+	LastError     string
+	LastPaymentID string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// IdempotencyKey представляет собой результат операции, выполненной с ключом идемпотентности.
+// Повторный вызов с тем же ключом возвращает этот результат вместо повторного выполнения операции.
+type IdempotencyKey struct {
+	Key       string
+	Operation string
+	// Request - параметры исходного вызова, с другими параметрами ключ использовать нельзя.
+	Request   string
+	PaymentID string
+	CreatedAt time.Time
 }
 
 type Progress struct {
 	Part   int
 	Result Money
-}
\ No newline at end of file
+}
+
+// PostingReason представляет собой причину движения средств.
+type PostingReason string
+
+// Предопределённые причины движения средств.
+const (
+	PostingReasonOpening  PostingReason = "opening"
+	PostingReasonDeposit  PostingReason = "deposit"
+	PostingReasonPayment  PostingReason = "payment"
+	PostingReasonRefund   PostingReason = "refund"
+	PostingReasonTransfer PostingReason = "transfer"
+	PostingReasonPayout   PostingReason = "payout"
+)
+
+// Posting представляет собой проводку: перемещение Amount со счёта учёта From на счёт учёта To.
+// Каждая проводка сбалансирована - сумма списания равна сумме зачисления.
+type Posting struct {
+	ID        string
+	Reason    PostingReason
+	PaymentID string
+	From      string
+	To        string
+	Amount    Money
+	// Currency - валюта Amount. Проводка всегда в одной валюте, обмен проводится через счета "exchange:<валюта>".
+	Currency Currency
+	// CreatedAt - время движения средств, например время пополнения счёта.
+	CreatedAt time.Time
+}
@@ -0,0 +1,59 @@
+package types
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPaymentStatus_Transition(t *testing.T) {
+	statuses := []PaymentStatus{PaymentStatusInProgress, PaymentStatusOk, PaymentStatusFail, PaymentStatusExpired}
+	allowed := map[PaymentStatus]map[PaymentStatus]bool{
+		PaymentStatusInProgress: {PaymentStatusOk: true, PaymentStatusFail: true, PaymentStatusExpired: true},
+	}
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			err := from.Transition(to)
+			if allowed[from][to] {
+				if err != nil {
+					t.Errorf("Transition(): %s -> %s must be allowed, returned %v", from, to, err)
+				}
+				continue
+			}
+
+			if !errors.Is(err, ErrInvalidStatusTransition) {
+				t.Errorf("Transition(): %s -> %s must return ErrInvalidStatusTransition, returned %v", from, to, err)
+				continue
+			}
+			var transitionErr *StatusTransitionError
+			if !errors.As(err, &transitionErr) || transitionErr.From != from || transitionErr.To != to {
+				t.Errorf("Transition(): %s -> %s must return StatusTransitionError, returned %v", from, to, err)
+			}
+		}
+	}
+}
+
+func TestAccountStatus_Transition(t *testing.T) {
+	statuses := []AccountStatus{AccountStatusActive, AccountStatusBlocked, AccountStatusClosed}
+	allowed := map[AccountStatus]map[AccountStatus]bool{
+		AccountStatusActive:  {AccountStatusBlocked: true, AccountStatusClosed: true},
+		AccountStatusBlocked: {AccountStatusActive: true, AccountStatusClosed: true},
+	}
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			err := from.Transition(to)
+			if allowed[from][to] {
+				if err != nil {
+					t.Errorf("Transition(): %s -> %s must be allowed, returned %v", from, to, err)
+				}
+				continue
+			}
+
+			var transitionErr *AccountStatusTransitionError
+			if !errors.Is(err, ErrInvalidAccountStatusTransition) || !errors.As(err, &transitionErr) || transitionErr.From != from || transitionErr.To != to {
+				t.Errorf("Transition(): %s -> %s must return AccountStatusTransitionError, returned %v", from, to, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package wallet
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/zstd"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// Compression - сжатие файлов выгрузки. Сжатые файлы получают расширение сжатия, например accounts.dump.gz.
+// При чтении сжатие определяется по первым байтам файла, поэтому сжатые и несжатые файлы можно смешивать.
+type Compression string
+
+const (
+	// CompressionNone - файлы не сжимаются.
+	CompressionNone Compression = "none"
+	// CompressionGzip - gzip (RFC 1952).
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd - Zstandard (RFC 8878), см. пакет zstd.
+	CompressionZstd Compression = "zstd"
+)
+
+// compressions - все поддерживаемые сжатия.
+var compressions = []Compression{CompressionNone, CompressionGzip, CompressionZstd}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+var ErrUnknownCompression = errors.New("unknown compression")
+
+// Extension возвращает расширение сжатых файлов, например ".gz", для CompressionNone - пустую строку.
+func (c Compression) Extension() string {
+	switch c {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+func (c Compression) valid() bool {
+	for _, compression := range compressions {
+		if c == compression {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCompression задаёт сжатие файлов, которые записывают Export, ExportAs, ExportToFile и HistoryToFiles.
+// По умолчанию файлы не сжимаются.
+func (s *Service) SetCompression(compression Compression) error {
+	if !compression.valid() {
+		return fmt.Errorf("%w: %q", ErrUnknownCompression, compression)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.compression = compression
+	return nil
+}
+
+// currentCompression возвращает сжатие, заданное SetCompression.
+func (s *Service) currentCompression() Compression {
+	if s.compression == "" {
+		return CompressionNone
+	}
+	return s.compression
+}
+
+// compressedName возвращает имя файла name, сжатого compression, например "accounts.dump.gz".
+func compressedName(name string, compression Compression) string {
+	return name + compression.Extension()
+}
+
+// uncompressedName возвращает имя файла name без расширения сжатия.
+func uncompressedName(name string) string {
+	return strings.TrimSuffix(name, compressionOf(name).Extension())
+}
+
+// compressionOf возвращает сжатие, с которым записывается файл path, по его расширению.
+// Временный файл name.tmp записывается со сжатием файла name.
+func compressionOf(path string) Compression {
+	path = strings.TrimSuffix(path, ".tmp")
+	for _, compression := range compressions {
+		if compression != CompressionNone && strings.HasSuffix(path, compression.Extension()) {
+			return compression
+		}
+	}
+	return CompressionNone
+}
+
+// appendCompression возвращает сжатие, с которым нужно дописывать файл path:
+// сжатие его начала или, если файла нет или он пуст, compression.
+func appendCompression(path string, compression Compression) (Compression, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return compression, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+
+	reader := bufio.NewReader(file)
+	if _, err := reader.Peek(1); err == io.EOF {
+		return compression, nil
+	}
+	return detectCompression(reader)
+}
+
+// detectCompression определяет сжатие по первым байтам r, не читая их из r.
+func detectCompression(r *bufio.Reader) (Compression, error) {
+	// короткий файл не может быть сжатым, поэтому ошибку Peek можно не проверять
+	magic, _ := r.Peek(len(zstd.Magic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return CompressionGzip, nil
+	case bytes.HasPrefix(magic, zstd.Magic):
+		return CompressionZstd, nil
+	}
+	return CompressionNone, nil
+}
+
+// decompress возвращает r, распакованный в соответствии с его первыми байтами.
+// Close завершает распаковку, но не закрывает r.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	reader := bufio.NewReader(r)
+	compression, err := detectCompression(reader)
+	if err != nil {
+		return nil, err
+	}
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewReader(reader)
+	case CompressionZstd:
+		return zstd.NewReader(reader)
+	}
+	return io.NopCloser(reader), nil
+}
+
+// dumpFile - открытый для чтения файл выгрузки, распакованный, если он сжат.
+type dumpFile struct {
+	io.ReadCloser
+	file *os.File
+}
+
+// openDump открывает файл path для чтения. Сжатие определяется по первым байтам файла, а не по расширению.
+func openDump(path string) (*dumpFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := decompress(file)
+	if err != nil {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &dumpFile{ReadCloser: reader, file: file}, nil
+}
+
+func (f *dumpFile) Close() error {
+	err := f.ReadCloser.Close()
+	if cerr := f.file.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// compressor - запись в w со сжатием compression. close завершает сжатый поток, но не закрывает w.
+type compressor struct {
+	io.Writer
+	close func() error
+}
+
+func newCompressor(w io.Writer, compression Compression) compressor {
+	switch compression {
+	case CompressionGzip:
+		gz := gzip.NewWriter(w)
+		return compressor{Writer: gz, close: gz.Close}
+	case CompressionZstd:
+		zw := zstd.NewWriter(w)
+		return compressor{Writer: zw, close: zw.Close}
+	}
+	return compressor{Writer: w, close: func() error { return nil }}
+}
@@ -0,0 +1,236 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"github.com/akhrorov/wallet/pkg/zstd"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// isGzip сообщает, начинается ли файл path с сигнатуры gzip.
+func isGzip(t *testing.T, path string) bool {
+	t.Helper()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(): can't read %s, %v", path, err)
+	}
+	return bytes.HasPrefix(content, gzipMagic)
+}
+
+func TestService_Export_gzip(t *testing.T) {
+	service, favorite, _ := formatTestService(t)
+	err := service.SetCompression(CompressionGzip)
+	if err != nil {
+		t.Fatalf("SetCompression(): can't set compression, %v", err)
+	}
+	dir := t.TempDir()
+	err = service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+	if !isGzip(t, filepath.Join(dir, accountsDump+".gz")) {
+		t.Errorf("Export(): %s.gz must be compressed", accountsDump)
+	}
+
+	imported := &Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	got, err := imported.FindFavoriteByID(favorite.ID)
+	if err != nil || !reflect.DeepEqual(got, favorite) {
+		t.Errorf("Import(): want favorite %v, result %v, %v", favorite, got, err)
+	}
+
+	err = service.SetCompression(CompressionNone)
+	if err != nil {
+		t.Fatalf("SetCompression(): can't set compression, %v", err)
+	}
+	err = service.ExportAs(dir, FormatCSV)
+	if err != nil {
+		t.Fatalf("ExportAs(): can't export, %v", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.gz"))
+	if len(matches) != 0 {
+		t.Errorf("ExportAs(): compressed files of the previous generation must be removed, found %v", matches)
+	}
+
+	err = service.SetCompression("brotli")
+	if !errors.Is(err, ErrUnknownCompression) {
+		t.Errorf("SetCompression(): must return ErrUnknownCompression, returned %v", err)
+	}
+}
+
+func TestService_Import_mixedCompression(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		accountsDump: formatDumpHeader(entityAccounts) + "1;+992000000001;100;0;0;TJS;ACTIVE\n",
+	})
+	err := writeRecords(filepath.Join(dir, paymentsDump+".gz"), entityPayments, 1, func(i int) string {
+		return "p1;100;auto;1;OK;;0;0;TJS\n"
+	})
+	if err != nil {
+		t.Fatalf("writeRecords(): can't write payments, %v", err)
+	}
+
+	service := &Service{}
+	err = service.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	if _, err := service.FindAccountByID(1); err != nil {
+		t.Errorf("Import(): can't find account, %v", err)
+	}
+	if _, err := service.FindPaymentByID("p1"); err != nil {
+		t.Errorf("Import(): can't find payment, %v", err)
+	}
+
+	err = writeRecords(filepath.Join(dir, favoritesDump+".zst"), entityFavorites, 1, func(i int) string {
+		return "f1;100;auto;1;car;0;0\n"
+	})
+	if err != nil {
+		t.Fatalf("writeRecords(): can't write favorites, %v", err)
+	}
+	service = &Service{}
+	err = service.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	if _, err := service.FindFavoriteByID("f1"); err != nil {
+		t.Errorf("Import(): can't find favorite, %v", err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, favoritesDump+".zst"), []byte{0x28, 0xb5, 0x2f, 0xfd, 0}, 0666)
+	if err != nil {
+		t.Fatalf("WriteFile(): can't write favorites, %v", err)
+	}
+	err = (&Service{}).Import(dir)
+	if !errors.Is(err, zstd.ErrCorrupted) {
+		t.Errorf("Import(): must return zstd.ErrCorrupted, returned %v", err)
+	}
+}
+
+func TestService_Export_zstd(t *testing.T) {
+	service, favorite, _ := formatTestService(t)
+	err := service.SetCompression(CompressionZstd)
+	if err != nil {
+		t.Fatalf("SetCompression(): can't set compression, %v", err)
+	}
+	dir := t.TempDir()
+	err = service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, accountsDump+".zst"))
+	if err != nil || !bytes.HasPrefix(content, zstd.Magic) {
+		t.Errorf("Export(): %s.zst must be compressed, %v", accountsDump, err)
+	}
+
+	imported := &Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	got, err := imported.FindFavoriteByID(favorite.ID)
+	if err != nil || !reflect.DeepEqual(got, favorite) {
+		t.Errorf("Import(): want favorite %v, result %v, %v", favorite, got, err)
+	}
+
+	// ExportToFile дописывает файл новым кадром
+	path := filepath.Join(dir, "accounts.txt.zst")
+	for i := 0; i < 2; i++ {
+		err = service.ExportToFile(path)
+		if err != nil {
+			t.Fatalf("ExportToFile(): can't export, %v", err)
+		}
+	}
+	err = (&Service{}).ImportFromFile(path)
+	if err != nil {
+		t.Errorf("ImportFromFile(): can't import, %v", err)
+	}
+}
+
+func TestService_ExportToFile_gzip(t *testing.T) {
+	service, _, _ := formatTestService(t)
+	accounts, _ := service.repository().Accounts()
+	err := service.SetCompression(CompressionGzip)
+	if err != nil {
+		t.Fatalf("SetCompression(): can't set compression, %v", err)
+	}
+	dir := t.TempDir()
+	compressed := filepath.Join(dir, "accounts.txt.gz")
+	for i := 0; i < 2; i++ {
+		err = service.ExportToFile(compressed)
+		if err != nil {
+			t.Fatalf("ExportToFile(): can't export, %v", err)
+		}
+	}
+	if !isGzip(t, compressed) {
+		t.Errorf("ExportToFile(): %s must be compressed", compressed)
+	}
+
+	// в несжатый файл записи дописываются без сжатия
+	plain := filepath.Join(dir, "accounts.txt")
+	err = ioutil.WriteFile(plain, []byte(strings.TrimSuffix(formatDumpHeader(entityAccounts), "\n")+"|"), 0666)
+	if err != nil {
+		t.Fatalf("WriteFile(): can't write %s, %v", plain, err)
+	}
+	err = service.ExportToFile(plain)
+	if err != nil {
+		t.Fatalf("ExportToFile(): can't export, %v", err)
+	}
+	if isGzip(t, plain) {
+		t.Errorf("ExportToFile(): %s must not be compressed", plain)
+	}
+
+	for _, path := range []string{compressed, plain} {
+		imported := &Service{}
+		err = imported.ImportFromFile(path)
+		if err != nil {
+			t.Fatalf("ImportFromFile(): can't import %s, %v", path, err)
+		}
+		got, err := imported.FindAccountByID(accounts[0].ID)
+		if err != nil || !reflect.DeepEqual(got, accounts[0]) {
+			t.Errorf("ImportFromFile(): want account %v, result %v, %v", accounts[0], got, err)
+		}
+	}
+}
+
+func TestService_HistoryToFiles_gzip(t *testing.T) {
+	service, history := historyTestPayments(t, 5)
+	err := service.SetCompression(CompressionGzip)
+	if err != nil {
+		t.Fatalf("SetCompression(): can't set compression, %v", err)
+	}
+	dir := t.TempDir()
+	err = service.HistoryToFiles(history, dir, 2)
+	if err != nil {
+		t.Fatalf("HistoryToFiles(): can't write history, %v", err)
+	}
+	for _, name := range []string{"payments1.dump.gz", "payments2.dump.gz", "payments3.dump.gz"} {
+		if !isGzip(t, filepath.Join(dir, name)) {
+			t.Errorf("HistoryToFiles(): %s must be compressed", name)
+		}
+	}
+	got, err := ReadHistory(dir)
+	if err != nil || !reflect.DeepEqual(got, history) {
+		t.Errorf("ReadHistory(): want %v, result %v, %v", history, got, err)
+	}
+
+	err = service.HistoryToShards(history, dir, HistoryOptions{Compression: CompressionNone})
+	if err != nil {
+		t.Fatalf("HistoryToShards(): can't write history, %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "payments1.dump")); err != nil {
+		t.Errorf("HistoryToShards(): payments1.dump must be written, %v", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.gz"))
+	if len(matches) != 0 {
+		t.Errorf("HistoryToShards(): compressed shards of the previous history must be removed, found %v", matches)
+	}
+}
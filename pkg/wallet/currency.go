@@ -0,0 +1,97 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/akhrorov/wallet/pkg/exchange"
+	"github.com/akhrorov/wallet/pkg/types"
+)
+
+var ErrInvalidCurrency = errors.New("invalid currency")
+var ErrCurrencyMismatch = errors.New("currency does not match account currency")
+
+// SetConverter задаёт конвертер валют, который используется для переводов между счетами в разных валютах
+// и для SumPaymentsIn. По умолчанию конвертера нет и суммы в разных валютах не пересчитываются
+// (возвращается exchange.ErrRateNotFound).
+func (s *Service) SetConverter(converter exchange.Converter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rates = converter
+}
+
+func (s *Service) converter() exchange.Converter {
+	if s.rates == nil {
+		return exchange.NewTable()
+	}
+	return s.rates
+}
+
+// DepositAmount работает как Deposit, но проверяет, что amount в валюте счёта.
+func (s *Service) DepositAmount(accountID int64, amount types.Amount) error {
+	if amount.Value <= 0 {
+		return ErrAmountMustBePositive
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkCurrency(accountID, amount.Currency)
+	if err != nil {
+		return err
+	}
+
+	return s.deposit(accountID, amount.Value, nil)
+}
+
+// PayAmount работает как Pay, но проверяет, что amount в валюте счёта.
+func (s *Service) PayAmount(accountID int64, amount types.Amount, category types.PaymentCategory) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkCurrency(accountID, amount.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.pay(accountID, amount.Value, category, nil)
+}
+
+func (s *Service) checkCurrency(accountID int64, currency types.Currency) error {
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+	if account.Currency != currency {
+		return ErrCurrencyMismatch
+	}
+	return nil
+}
+
+// SumPaymentsIn возвращает сумму всех платежей, пересчитанную в валюту currency. Перевод учитывается один раз.
+// Платежи сначала суммируются по валютам, затем каждая сумма пересчитывается один раз.
+func (s *Service) SumPaymentsIn(currency types.Currency) (types.Amount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	payments, err := s.repository().Payments()
+	if err != nil {
+		return types.Amount{}, err
+	}
+
+	byCurrency := make(map[types.Currency]types.Money)
+	for _, payment := range payments {
+		if inPaymentsSum(payment) {
+			byCurrency[payment.Currency] += payment.Amount
+		}
+	}
+
+	sum := types.Amount{Currency: currency}
+	for paymentCurrency, total := range byCurrency {
+		converted, err := s.converter().Convert(types.Amount{Value: total, Currency: paymentCurrency}, currency)
+		if err != nil {
+			return types.Amount{}, err
+		}
+		sum.Value += converted.Value
+	}
+	return sum, nil
+}
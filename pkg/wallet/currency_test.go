@@ -0,0 +1,223 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/akhrorov/wallet/pkg/exchange"
+	"github.com/akhrorov/wallet/pkg/types"
+	"testing"
+)
+
+func newTestRates(t *testing.T) *exchange.Table {
+	rates := exchange.NewTable()
+	err := rates.Set(types.CurrencyUSD, types.CurrencyTJS, "11.30")
+	if err != nil {
+		t.Fatalf("Set(): can't set rate, %v", err)
+	}
+	return rates
+}
+
+func TestService_RegisterAccountWithCurrency(t *testing.T) {
+	service := &Service{}
+	account, err := service.RegisterAccountWithCurrency("+992000000001", types.CurrencyUSD)
+	if err != nil || account.Currency != types.CurrencyUSD {
+		t.Errorf("RegisterAccountWithCurrency(): want USD account, result %v, %v", account, err)
+	}
+	account, err = service.RegisterAccount("+992000000002")
+	if err != nil || account.Currency != types.DefaultCurrency {
+		t.Errorf("RegisterAccount(): want account in default currency, result %v, %v", account, err)
+	}
+	_, err = service.RegisterAccountWithCurrency("+992000000003", "dollar")
+	if err != ErrInvalidCurrency {
+		t.Errorf("RegisterAccountWithCurrency(): must return ErrInvalidCurrency, returned %v", err)
+	}
+}
+
+func TestService_PayAmount_currencyMismatch(t *testing.T) {
+	service := &Service{}
+	account, err := service.RegisterAccountWithCurrency("+992000000001", types.CurrencyUSD)
+	if err != nil {
+		t.Fatalf("RegisterAccountWithCurrency(): can't register account, %v", err)
+	}
+
+	err = service.DepositAmount(account.ID, types.Amount{Value: 100_00, Currency: types.CurrencyTJS})
+	if err != ErrCurrencyMismatch {
+		t.Errorf("DepositAmount(): must return ErrCurrencyMismatch, returned %v", err)
+	}
+	err = service.DepositAmount(account.ID, types.Amount{Value: 100_00, Currency: types.CurrencyUSD})
+	if err != nil {
+		t.Fatalf("DepositAmount(): can't deposit, %v", err)
+	}
+
+	_, err = service.PayAmount(account.ID, types.Amount{Value: 10_00, Currency: types.CurrencyRUB}, "auto")
+	if err != ErrCurrencyMismatch {
+		t.Errorf("PayAmount(): must return ErrCurrencyMismatch, returned %v", err)
+	}
+	payment, err := service.PayAmount(account.ID, types.Amount{Value: 10_00, Currency: types.CurrencyUSD}, "auto")
+	if err != nil || payment.Currency != types.CurrencyUSD {
+		t.Errorf("PayAmount(): want USD payment, result %v, %v", payment, err)
+	}
+	if balance := currentAccount(t, service, account.ID).Balance; balance != 90_00 {
+		t.Errorf("PayAmount(): want balance 9000, result %v", balance)
+	}
+}
+
+func TestService_Pay_currencyMismatch(t *testing.T) {
+	service := &Service{}
+	account, err := service.RegisterAccountWithCurrency("+992000000001", types.CurrencyUSD)
+	if err != nil {
+		t.Fatalf("RegisterAccountWithCurrency(): can't register account, %v", err)
+	}
+	err = service.DepositAmount(account.ID, types.Amount{Value: 100_00, Currency: types.CurrencyUSD})
+	if err != nil {
+		t.Fatalf("DepositAmount(): can't deposit, %v", err)
+	}
+
+	// Deposit и Pay работают в валюте по умолчанию, а счёт в USD
+	err = service.Deposit(account.ID, 100_00)
+	if err != ErrCurrencyMismatch {
+		t.Errorf("Deposit(): must return ErrCurrencyMismatch, returned %v", err)
+	}
+	err = service.DepositWithKey("deposit", account.ID, 100_00)
+	if err != ErrCurrencyMismatch {
+		t.Errorf("DepositWithKey(): must return ErrCurrencyMismatch, returned %v", err)
+	}
+	_, err = service.Pay(account.ID, 10_00, "auto")
+	if err != ErrCurrencyMismatch {
+		t.Errorf("Pay(): must return ErrCurrencyMismatch, returned %v", err)
+	}
+	_, err = service.PayWithKey("pay", account.ID, 10_00, "auto")
+	if err != ErrCurrencyMismatch {
+		t.Errorf("PayWithKey(): must return ErrCurrencyMismatch, returned %v", err)
+	}
+	if balance := currentAccount(t, service, account.ID).Balance; balance != 100_00 {
+		t.Errorf("Pay(): rejected operations must not change balance, result %v", balance)
+	}
+}
+
+func TestService_Transfer_crossCurrency(t *testing.T) {
+	service := &Service{}
+	from, err := service.RegisterAccountWithCurrency("+992000000001", types.CurrencyUSD)
+	if err != nil {
+		t.Fatalf("RegisterAccountWithCurrency(): can't register account, %v", err)
+	}
+	to, err := service.RegisterAccount("+992000000002")
+	if err != nil {
+		t.Fatalf("RegisterAccount(): can't register account, %v", err)
+	}
+	err = service.DepositAmount(from.ID, types.Amount{Value: 100_00, Currency: types.CurrencyUSD})
+	if err != nil {
+		t.Fatalf("DepositAmount(): can't deposit, %v", err)
+	}
+
+	_, err = service.Transfer(from.ID, to.ID, 10_00)
+	if !errors.Is(err, exchange.ErrRateNotFound) {
+		t.Errorf("Transfer(): must return ErrRateNotFound without rates, returned %v", err)
+	}
+
+	service.SetConverter(newTestRates(t))
+	outgoing, err := service.Transfer(from.ID, to.ID, 10_00)
+	if err != nil {
+		t.Fatalf("Transfer(): can't transfer, %v", err)
+	}
+	incoming, err := service.FindPaymentByID(outgoing.LinkedID)
+	if err != nil {
+		t.Fatalf("Transfer(): can't find linked payment, %v", err)
+	}
+	from, to = currentAccount(t, service, from.ID), currentAccount(t, service, to.ID)
+	if from.Balance != 90_00 || to.Balance != 113_00 || incoming.Amount != 113_00 || incoming.Currency != types.CurrencyTJS {
+		t.Errorf("Transfer(): wrong result, from %v, to %v, incoming %v", from, to, incoming)
+	}
+	err = service.Audit()
+	if err != nil {
+		t.Errorf("Audit(): ledger must match balances, %v", err)
+	}
+
+	err = service.Reject(outgoing.ID)
+	if err != nil {
+		t.Fatalf("Reject(): can't reject transfer, %v", err)
+	}
+	from, to = currentAccount(t, service, from.ID), currentAccount(t, service, to.ID)
+	if from.Balance != 100_00 || to.Balance != 0 {
+		t.Errorf("Reject(): wrong balances, from %v, to %v", from, to)
+	}
+	err = service.Audit()
+	if err != nil {
+		t.Errorf("Audit(): ledger must match balances, %v", err)
+	}
+}
+
+func TestService_SumPaymentsIn(t *testing.T) {
+	service := &Service{}
+	service.SetConverter(newTestRates(t))
+	usd, err := service.RegisterAccountWithCurrency("+992000000001", types.CurrencyUSD)
+	if err != nil {
+		t.Fatalf("RegisterAccountWithCurrency(): can't register account, %v", err)
+	}
+	tjs, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	err = service.DepositAmount(usd.ID, types.Amount{Value: 100_00, Currency: types.CurrencyUSD})
+	if err != nil {
+		t.Fatalf("DepositAmount(): can't deposit, %v", err)
+	}
+	_, err = service.PayAmount(usd.ID, types.Amount{Value: 10_00, Currency: types.CurrencyUSD}, "auto")
+	if err != nil {
+		t.Fatalf("PayAmount(): can't pay, %v", err)
+	}
+	_, err = service.Pay(tjs.ID, 13_00, "auto")
+	if err != nil {
+		t.Fatalf("Pay(): can't pay, %v", err)
+	}
+
+	// 1000_00 + 13_00 TJS и 10_00 USD, то есть 113_00 TJS
+	got, err := service.SumPaymentsIn(types.CurrencyTJS)
+	want := types.Amount{Value: 1126_00, Currency: types.CurrencyTJS}
+	if err != nil || got != want {
+		t.Errorf("SumPaymentsIn(): want %v, result %v, %v", want, got, err)
+	}
+
+	_, err = service.SumPaymentsIn(types.CurrencyRUB)
+	if !errors.Is(err, exchange.ErrRateNotFound) {
+		t.Errorf("SumPaymentsIn(): must return ErrRateNotFound, returned %v", err)
+	}
+}
+
+func TestService_Import_currency(t *testing.T) {
+	dir := t.TempDir()
+	service := &Service{}
+	account, err := service.RegisterAccountWithCurrency("+992000000001", types.CurrencyRUB)
+	if err != nil {
+		t.Fatalf("RegisterAccountWithCurrency(): can't register account, %v", err)
+	}
+	err = service.DepositAmount(account.ID, types.Amount{Value: 100_00, Currency: types.CurrencyRUB})
+	if err != nil {
+		t.Fatalf("DepositAmount(): can't deposit, %v", err)
+	}
+	payment, err := service.PayAmount(account.ID, types.Amount{Value: 10_00, Currency: types.CurrencyRUB}, "auto")
+	if err != nil {
+		t.Fatalf("PayAmount(): can't pay, %v", err)
+	}
+	err = service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+
+	imported := &Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	gotAccount, err := imported.FindAccountByID(account.ID)
+	if err != nil || gotAccount.Currency != types.CurrencyRUB {
+		t.Errorf("Import(): want RUB account, result %v, %v", gotAccount, err)
+	}
+	gotPayment, err := imported.FindPaymentByID(payment.ID)
+	if err != nil || gotPayment.Currency != types.CurrencyRUB {
+		t.Errorf("Import(): want RUB payment, result %v, %v", gotPayment, err)
+	}
+	err = imported.Audit()
+	if err != nil {
+		t.Errorf("Audit(): ledger must match balances, %v", err)
+	}
+}
@@ -0,0 +1,701 @@
+package wallet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/record"
+	"github.com/akhrorov/wallet/pkg/types"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Имена файлов, в которые Export сохраняет данные.
+const (
+	accountsDump    = "accounts.dump"
+	paymentsDump    = "payments.dump"
+	favoritesDump   = "favorites.dump"
+	postingsDump    = "postings.dump"
+	idempotencyDump = "idempotency.dump"
+	schedulesDump   = "schedules.dump"
+)
+
+var ErrInvalidDumpHeader = errors.New("invalid dump header")
+var ErrUnsupportedDumpVersion = errors.New("unsupported dump version")
+var ErrInvalidFieldCount = errors.New("wrong number of fields")
+var ErrInvalidInteger = errors.New("invalid integer")
+var ErrInvalidAccountStatus = errors.New("invalid account status")
+var ErrInvalidPaymentStatus = errors.New("invalid payment status")
+
+// dumpVersion - версия формата, в которой записываются файлы выгрузки и журнал.
+// Первая строка файла - заголовок "#wallet;<вид записей>;<версия>", файлы без заголовка считаются версией 1.
+// С версии 3 поля экранируются (см. пакет record) и у каждой записи фиксированное число полей.
+const dumpVersion = 3
+
+// Виды записей, которые указываются в заголовке файла.
+const (
+	entityAccounts    = "accounts"
+	entityPayments    = "payments"
+	entityFavorites   = "favorites"
+	entityPostings    = "postings"
+	entityIdempotency = "idempotency"
+	entitySchedules   = "schedules"
+	entityJournal     = "journal"
+)
+
+// dumpHeaderPrefix отличает заголовок от записи: ни одна запись не начинается с "#".
+const dumpHeaderPrefix = "#wallet;"
+
+// dumpReader разбирает записи одной версии формата.
+type dumpReader struct {
+	account        func(line string) (*types.Account, error)
+	payment        func(line string) (*types.Payment, error)
+	favorite       func(line string) (*types.Favorite, error)
+	posting        func(line string) (*types.Posting, error)
+	idempotencyKey func(line string) (*types.IdempotencyKey, error)
+	schedule       func(line string) (*types.Schedule, error)
+}
+
+// dumpReaders - разбор всех версий формата, которые можно прочитать.
+// При изменении формата записей dumpVersion увеличивается, а функции разбора прежней версии
+// остаются здесь под её номером, чтобы старые файлы читались и обновлялись через MigrateDir.
+var dumpReaders = map[int]dumpReader{
+	1: legacyDumpReader,
+	2: legacyDumpReader,
+	3: currentDumpReader,
+}
+
+var currentDumpReader = dumpReader{
+	account:        parseAccount,
+	payment:        parsePayment,
+	favorite:       parseFavorite,
+	posting:        parsePosting,
+	idempotencyKey: parseIdempotencyKey,
+	schedule:       parseSchedule,
+}
+
+func formatDumpHeader(entity string) string {
+	return dumpHeaderPrefix + entity + ";" + strconv.Itoa(dumpVersion) + "\n"
+}
+
+// parseDumpHeader возвращает версию из заголовка line, проверяя, что в файле записи вида entity.
+func parseDumpHeader(line string, entity string) (int, error) {
+	fields := strings.Split(strings.TrimPrefix(line, dumpHeaderPrefix), ";")
+	if !strings.HasPrefix(line, dumpHeaderPrefix) || len(fields) != 2 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidDumpHeader, line)
+	}
+	if fields[0] != entity {
+		return 0, fmt.Errorf("%w: want %s, file contains %s", ErrInvalidDumpHeader, entity, fields[0])
+	}
+	version, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidDumpHeader, line)
+	}
+	return version, nil
+}
+
+// dumpReaderFor возвращает разбор записей версии version.
+func dumpReaderFor(version int) (dumpReader, error) {
+	reader, ok := dumpReaders[version]
+	if !ok {
+		return dumpReader{}, fmt.Errorf("%w: %d", ErrUnsupportedDumpVersion, version)
+	}
+	return reader, nil
+}
+
+// dumpReaderForHeader возвращает разбор записей по первой строке файла: заголовку или, если заголовка нет, записи версии 1.
+func dumpReaderForHeader(line string, entity string) (dumpReader, error) {
+	version := 1
+	if strings.HasPrefix(line, "#") {
+		var err error
+		version, err = parseDumpHeader(line, entity)
+		if err != nil {
+			return dumpReader{}, err
+		}
+	}
+	return dumpReaderFor(version)
+}
+
+// formatTime записывает время в наносекундах Unix, нулевое время - как "0".
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// ParseError описывает запись, которую не удалось разобрать, например "payments.dump:17: amount: invalid integer".
+// Line - номер строки в файле (для ImportFromFile - номер записи), Field - имя поля или пустое,
+// если ошибка относится ко всей записи.
+type ParseError struct {
+	File  string
+	Line  int
+	Field string
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %s: %v", e.File, e.Line, e.Field, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// newParseError дополняет ошибку разбора записи файлом и номером строки.
+func newParseError(file string, line int, err error) *ParseError {
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return &ParseError{File: file, Line: line, Field: parseErr.Field, Err: parseErr.Err}
+	}
+	return &ParseError{File: file, Line: line, Err: err}
+}
+
+func fieldCountError(want int, got int) error {
+	return fmt.Errorf("%w: want %d, got %d", ErrInvalidFieldCount, want, got)
+}
+
+// splitRecord разбирает запись текущей версии из ровно count полей.
+func splitRecord(line string, count int) ([]string, error) {
+	fields, err := record.Split(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != count {
+		return nil, fieldCountError(count, len(fields))
+	}
+	return fields, nil
+}
+
+// fieldReader разбирает поля записи и запоминает первую ошибку вместе с именем поля,
+// поэтому ошибку достаточно проверить один раз после разбора всех полей.
+type fieldReader struct {
+	fields []string
+	err    error
+}
+
+func (r *fieldReader) fail(name string, err error) {
+	if r.err == nil {
+		r.err = &ParseError{Field: name, Err: err}
+	}
+}
+
+func (r *fieldReader) int(i int, name string) int64 {
+	value, err := strconv.ParseInt(r.fields[i], 10, 64)
+	if err != nil {
+		r.fail(name, ErrInvalidInteger)
+	}
+	return value
+}
+
+// time разбирает время в наносекундах Unix, "0" - нулевое время.
+func (r *fieldReader) time(i int, name string) time.Time {
+	nanos := r.int(i, name)
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos).UTC()
+}
+
+// phone разбирает номер телефона и приводит его к виду, в котором номера хранятся в счетах (см. normalizeStoredPhone).
+func (r *fieldReader) phone(i int, name string) types.Phone {
+	phone, err := normalizeStoredPhone(types.Phone(r.fields[i]))
+	if err != nil {
+		r.fail(name, err)
+	}
+	return phone
+}
+
+// currency разбирает код валюты, для пустого поля (старые файлы) возвращает валюту по умолчанию.
+func (r *fieldReader) currency(i int, name string) types.Currency {
+	if r.fields[i] == "" {
+		return types.DefaultCurrency
+	}
+	currency := types.Currency(r.fields[i])
+	if !currency.Valid() {
+		r.fail(name, fmt.Errorf("%w: %q", ErrInvalidCurrency, r.fields[i]))
+	}
+	return currency
+}
+
+// accountStatus разбирает состояние счёта, для пустого поля возвращает AccountStatusActive.
+func (r *fieldReader) accountStatus(i int, name string) types.AccountStatus {
+	switch status := types.AccountStatus(r.fields[i]); status {
+	case "":
+		return types.AccountStatusActive
+	case types.AccountStatusActive, types.AccountStatusBlocked, types.AccountStatusClosed:
+		return status
+	default:
+		r.fail(name, fmt.Errorf("%w: %q", ErrInvalidAccountStatus, r.fields[i]))
+		return ""
+	}
+}
+
+func (r *fieldReader) paymentStatus(i int, name string) types.PaymentStatus {
+	switch status := types.PaymentStatus(r.fields[i]); status {
+	case types.PaymentStatusOk, types.PaymentStatusFail, types.PaymentStatusInProgress, types.PaymentStatusExpired:
+		return status
+	default:
+		r.fail(name, fmt.Errorf("%w: %q", ErrInvalidPaymentStatus, r.fields[i]))
+		return ""
+	}
+}
+
+func formatAccount(account *types.Account) string {
+	return record.Join(fmt.Sprint(account.ID), string(account.Phone), fmt.Sprint(account.Balance), formatTime(account.CreatedAt), formatTime(account.UpdatedAt), string(account.Currency), string(account.Status)) + "\n"
+}
+
+func formatPayment(payment *types.Payment) string {
+	return record.Join(payment.ID, fmt.Sprint(payment.Amount), string(payment.Category), fmt.Sprint(payment.AccountID), string(payment.Status), payment.LinkedID, formatTime(payment.CreatedAt), formatTime(payment.UpdatedAt), string(payment.Currency)) + "\n"
+}
+
+func formatFavorite(favorite *types.Favorite) string {
+	return record.Join(favorite.ID, fmt.Sprint(favorite.Amount), string(favorite.Category), fmt.Sprint(favorite.AccountID), favorite.Name, formatTime(favorite.CreatedAt), formatTime(favorite.UpdatedAt)) + "\n"
+}
+
+func formatPosting(posting *types.Posting) string {
+	return record.Join(posting.ID, string(posting.Reason), posting.PaymentID, posting.From, posting.To, fmt.Sprint(posting.Amount), formatTime(posting.CreatedAt), string(posting.Currency)) + "\n"
+}
+
+func formatIdempotencyKey(key *types.IdempotencyKey) string {
+	return record.Join(key.Key, key.Operation, key.Request, key.PaymentID, formatTime(key.CreatedAt)) + "\n"
+}
+
+func formatSchedule(schedule *types.Schedule) string {
+	return record.Join(schedule.ID, schedule.FavoriteID, schedule.Spec, formatTime(schedule.NextRun), fmt.Sprint(schedule.Attempts), schedule.LastPaymentID, formatTime(schedule.CreatedAt), formatTime(schedule.UpdatedAt), schedule.LastError) + "\n"
+}
+
+// parseAccount разбирает запись id;phone;balance;createdAt;updatedAt;currency;status.
+func parseAccount(line string) (*types.Account, error) {
+	fields, err := splitRecord(line, 7)
+	if err != nil {
+		return nil, err
+	}
+	r := fieldReader{fields: fields}
+	account := &types.Account{
+		ID:        r.int(0, "id"),
+		Phone:     r.phone(1, "phone"),
+		Balance:   types.Money(r.int(2, "balance")),
+		CreatedAt: r.time(3, "createdAt"),
+		UpdatedAt: r.time(4, "updatedAt"),
+		Currency:  r.currency(5, "currency"),
+		Status:    r.accountStatus(6, "status"),
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return account, nil
+}
+
+// parsePayment разбирает запись id;amount;category;accountID;status;linkedID;createdAt;updatedAt;currency.
+func parsePayment(line string) (*types.Payment, error) {
+	fields, err := splitRecord(line, 9)
+	if err != nil {
+		return nil, err
+	}
+	r := fieldReader{fields: fields}
+	payment := &types.Payment{
+		ID:        fields[0],
+		Amount:    types.Money(r.int(1, "amount")),
+		Category:  types.PaymentCategory(fields[2]),
+		AccountID: r.int(3, "accountID"),
+		Status:    r.paymentStatus(4, "status"),
+		LinkedID:  fields[5],
+		CreatedAt: r.time(6, "createdAt"),
+		UpdatedAt: r.time(7, "updatedAt"),
+		Currency:  r.currency(8, "currency"),
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return payment, nil
+}
+
+// parseFavorite разбирает запись id;amount;category;accountID;name;createdAt;updatedAt.
+func parseFavorite(line string) (*types.Favorite, error) {
+	fields, err := splitRecord(line, 7)
+	if err != nil {
+		return nil, err
+	}
+	r := fieldReader{fields: fields}
+	favorite := &types.Favorite{
+		ID:        fields[0],
+		Amount:    types.Money(r.int(1, "amount")),
+		Category:  types.PaymentCategory(fields[2]),
+		AccountID: r.int(3, "accountID"),
+		Name:      fields[4],
+		CreatedAt: r.time(5, "createdAt"),
+		UpdatedAt: r.time(6, "updatedAt"),
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return favorite, nil
+}
+
+// parsePosting разбирает запись id;reason;paymentID;from;to;amount;createdAt;currency.
+func parsePosting(line string) (*types.Posting, error) {
+	fields, err := splitRecord(line, 8)
+	if err != nil {
+		return nil, err
+	}
+	r := fieldReader{fields: fields}
+	posting := &types.Posting{
+		ID:        fields[0],
+		Reason:    types.PostingReason(fields[1]),
+		PaymentID: fields[2],
+		From:      fields[3],
+		To:        fields[4],
+		Amount:    types.Money(r.int(5, "amount")),
+		CreatedAt: r.time(6, "createdAt"),
+		Currency:  r.currency(7, "currency"),
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return posting, nil
+}
+
+// parseIdempotencyKey разбирает запись key;operation;request;paymentID;createdAt.
+func parseIdempotencyKey(line string) (*types.IdempotencyKey, error) {
+	fields, err := splitRecord(line, 5)
+	if err != nil {
+		return nil, err
+	}
+	r := fieldReader{fields: fields}
+	key := &types.IdempotencyKey{
+		Key:       fields[0],
+		Operation: fields[1],
+		Request:   fields[2],
+		PaymentID: fields[3],
+		CreatedAt: r.time(4, "createdAt"),
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return key, nil
+}
+
+// parseSchedule разбирает запись id;favoriteID;spec;nextRun;attempts;lastPaymentID;createdAt;updatedAt;lastError.
+func parseSchedule(line string) (*types.Schedule, error) {
+	fields, err := splitRecord(line, 9)
+	if err != nil {
+		return nil, err
+	}
+	r := fieldReader{fields: fields}
+	schedule := &types.Schedule{
+		ID:            fields[0],
+		FavoriteID:    fields[1],
+		Spec:          fields[2],
+		NextRun:       r.time(3, "nextRun"),
+		Attempts:      int(r.int(4, "attempts")),
+		LastPaymentID: fields[5],
+		CreatedAt:     r.time(6, "createdAt"),
+		UpdatedAt:     r.time(7, "updatedAt"),
+		LastError:     fields[8],
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return schedule, nil
+}
+
+func writeAccounts(path string, accounts []*types.Account) error {
+	return writeRecords(path, entityAccounts, len(accounts), func(i int) string {
+		return formatAccount(accounts[i])
+	})
+}
+
+func writePayments(path string, payments []*types.Payment) error {
+	return writeRecords(path, entityPayments, len(payments), func(i int) string {
+		return formatPayment(payments[i])
+	})
+}
+
+func writeFavorites(path string, favorites []*types.Favorite) error {
+	return writeRecords(path, entityFavorites, len(favorites), func(i int) string {
+		return formatFavorite(favorites[i])
+	})
+}
+
+func writePostings(path string, postings []*types.Posting) error {
+	return writeRecords(path, entityPostings, len(postings), func(i int) string {
+		return formatPosting(postings[i])
+	})
+}
+
+func writeIdempotencyKeys(path string, keys []*types.IdempotencyKey) error {
+	return writeRecords(path, entityIdempotency, len(keys), func(i int) string {
+		return formatIdempotencyKey(keys[i])
+	})
+}
+
+func writeSchedules(path string, schedules []*types.Schedule) error {
+	return writeRecords(path, entitySchedules, len(schedules), func(i int) string {
+		return formatSchedule(schedules[i])
+	})
+}
+
+// writeRecords записывает в файл path заголовок entity и count записей, i-ю из которых возвращает format.
+// Файлы CSV и JSON Lines (по расширению path) записываются в своём формате, остальные - в FormatDump.
+func writeRecords(path string, entity string, count int, format func(i int) string) error {
+	fileFormat, ok := tableFormat(path)
+	if !ok {
+		fileFormat = FormatDump
+	}
+	encoder, err := newRecordEncoder(fileFormat, entity)
+	if err != nil {
+		return err
+	}
+	return writeDump(path, func(w *bufio.Writer) error {
+		header, err := encoder.header()
+		if err != nil {
+			return err
+		}
+		_, err = w.WriteString(header)
+		for i := 0; i < count && err == nil; i++ {
+			var item string
+			item, err = encoder.encode(format(i))
+			if err == nil {
+				_, err = w.WriteString(item)
+			}
+		}
+		return err
+	})
+}
+
+// writeDump создаёт файл path и записывает его содержимое через буфер функцией write.
+// Файл с расширением сжатия (см. compressionOf) сжимается. Файл сохраняется на диск (fsync) до возврата.
+func writeDump(path string, write func(w *bufio.Writer) error) (err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+			if err == nil {
+				err = cerr
+			}
+		}
+	}()
+
+	c := newCompressor(file, compressionOf(path))
+	w := bufio.NewWriter(c)
+	err = write(w)
+	if err != nil {
+		return err
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	err = c.close()
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// replaceFile атомарно заменяет файл path: write записывает новое содержимое во временный path.tmp,
+// который затем переименовывается в path. При сбое на диске остаётся прежний файл целиком.
+func replaceFile(path string, write func(tmp string) error) error {
+	tmp := path + ".tmp"
+	err := write(tmp)
+	if err != nil {
+		if rerr := removeIfExists(tmp); rerr != nil {
+			log.Print(rerr)
+		}
+		return err
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func readAccounts(path string, report *ImportReport) ([]*types.Account, error) {
+	accounts := []*types.Account{}
+	err := readDump(path, entityAccounts, report, func(reader dumpReader, line string) error {
+		account, err := reader.account(line)
+		if err != nil {
+			return err
+		}
+		accounts = append(accounts, account)
+		return nil
+	})
+	return accounts, err
+}
+
+func readPayments(path string, report *ImportReport) ([]*types.Payment, error) {
+	payments := []*types.Payment{}
+	err := readDump(path, entityPayments, report, func(reader dumpReader, line string) error {
+		payment, err := reader.payment(line)
+		if err != nil {
+			return err
+		}
+		payments = append(payments, payment)
+		return nil
+	})
+	return payments, err
+}
+
+func readFavorites(path string, report *ImportReport) ([]*types.Favorite, error) {
+	favorites := []*types.Favorite{}
+	err := readDump(path, entityFavorites, report, func(reader dumpReader, line string) error {
+		favorite, err := reader.favorite(line)
+		if err != nil {
+			return err
+		}
+		favorites = append(favorites, favorite)
+		return nil
+	})
+	return favorites, err
+}
+
+func readPostings(path string, report *ImportReport) ([]*types.Posting, error) {
+	postings := []*types.Posting{}
+	err := readDump(path, entityPostings, report, func(reader dumpReader, line string) error {
+		posting, err := reader.posting(line)
+		if err != nil {
+			return err
+		}
+		postings = append(postings, posting)
+		return nil
+	})
+	return postings, err
+}
+
+func readIdempotencyKeys(path string, report *ImportReport) ([]*types.IdempotencyKey, error) {
+	keys := []*types.IdempotencyKey{}
+	err := readDump(path, entityIdempotency, report, func(reader dumpReader, line string) error {
+		key, err := reader.idempotencyKey(line)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	return keys, err
+}
+
+func readSchedules(path string, report *ImportReport) ([]*types.Schedule, error) {
+	schedules := []*types.Schedule{}
+	err := readDump(path, entitySchedules, report, func(reader dumpReader, line string) error {
+		schedule, err := reader.schedule(line)
+		if err != nil {
+			return err
+		}
+		schedules = append(schedules, schedule)
+		return nil
+	})
+	return schedules, err
+}
+
+// ImportReport - записи, пропущенные при нестрогом импорте (см. ImportLenient и ImportFromFileLenient).
+type ImportReport struct {
+	Skipped []*ParseError
+}
+
+// skip добавляет в отчёт пропущенную запись. Для строгого импорта (r == nil) возвращает err.
+func (r *ImportReport) skip(err *ParseError) error {
+	if r == nil {
+		return err
+	}
+	r.Skipped = append(r.Skipped, err)
+	return nil
+}
+
+// readDump вызывает handle для каждой непустой строки файла path с записями вида entity,
+// передавая разбор записей версии, указанной в заголовке файла. Файлы CSV и JSON Lines читаются через readTable.
+// Сжатый файл распаковывается (см. openDump).
+// Ошибка handle возвращается как *ParseError. Если report не nil, строка с такой ошибкой
+// добавляется в report и чтение продолжается. Ошибка заголовка всегда прерывает чтение,
+// errStopReading прерывает его без ошибки.
+func readDump(path string, entity string, report *ImportReport, handle func(reader dumpReader, line string) error) error {
+	if format, ok := tableFormat(path); ok {
+		return readTable(path, format, entity, report, handle)
+	}
+
+	file, err := openDump(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+
+	name := filepath.Base(path)
+	reader := bufio.NewReader(file)
+	records := dumpReader{}
+	first := true
+	for lineNum := 1; ; lineNum++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			log.Print(err)
+			return err
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if first && len(line) > 0 {
+			first = false
+			var herr error
+			records, herr = dumpReaderForHeader(line, entity)
+			if herr != nil {
+				return newParseError(name, lineNum, herr)
+			}
+			if strings.HasPrefix(line, "#") {
+				line = ""
+			}
+		}
+		if len(line) > 0 {
+			if herr := handle(records, line); herr != nil {
+				if herr == errStopReading {
+					return nil
+				}
+				herr = report.skip(newParseError(name, lineNum, herr))
+				if herr != nil {
+					return herr
+				}
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// dumpFileVersion возвращает версию формата файла path с записями вида entity.
+func dumpFileVersion(path string, entity string) (int, error) {
+	file, err := openDump(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+
+	line, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if !strings.HasPrefix(line, "#") {
+		return 1, nil
+	}
+	return parseDumpHeader(strings.TrimSuffix(line, "\n"), entity)
+}
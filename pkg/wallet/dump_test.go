@@ -0,0 +1,127 @@
+package wallet
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestService_Export_escaped(t *testing.T) {
+	dir := t.TempDir()
+	service := &Service{}
+	_, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	name := "my;car|wash\\\nsecond line"
+	favorite, err := service.FavoritePayment(payments[0].ID, name)
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+
+	err = service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+	imported := &Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	got, err := imported.FindFavoriteByID(favorite.ID)
+	if err != nil || got.Name != name {
+		t.Errorf("Import(): want name %q, result %v, %v", name, got, err)
+	}
+}
+
+func TestImport_parseError(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		paymentsDump: formatDumpHeader(entityPayments) + "p1;100;auto;1;OK;;0;0;TJS\np2;abc;auto;1;OK;;0;0;TJS\n",
+	})
+
+	err := (&Service{}).Import(dir)
+	if !errors.Is(err, ErrInvalidInteger) {
+		t.Fatalf("Import(): must return ErrInvalidInteger, returned %v", err)
+	}
+	want := "payments.dump:3: amount: invalid integer"
+	if err.Error() != want {
+		t.Errorf("Import(): want error %q, result %q", want, err)
+	}
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Line != 3 || parseErr.Field != "amount" {
+		t.Errorf("Import(): wrong parse error %#v", err)
+	}
+}
+
+func TestImport_shortRecord(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		accountsDump: formatDumpHeader(entityAccounts) + "1;+992000000001\n",
+	})
+
+	err := (&Service{}).Import(dir)
+	if !errors.Is(err, ErrInvalidFieldCount) {
+		t.Errorf("Import(): must return ErrInvalidFieldCount, returned %v", err)
+	}
+}
+
+func TestService_ImportLenient(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		accountsDump: formatDumpHeader(entityAccounts) +
+			"1;+992000000001;900;0;0;TJS;ACTIVE\n" +
+			"2;+992000000002;100;0;0;TJS;LOST\n" +
+			"3;+992000000003;100;0;0;TJS;ACTIVE\n",
+		paymentsDump: formatDumpHeader(entityPayments) + "p1;100;auto;1\n",
+	})
+
+	service := &Service{}
+	report, err := service.ImportLenient(dir)
+	if err != nil {
+		t.Fatalf("ImportLenient(): can't import, %v", err)
+	}
+	if len(report.Skipped) != 2 {
+		t.Fatalf("ImportLenient(): must skip 2 records, skipped %v", report.Skipped)
+	}
+	if report.Skipped[0].File != accountsDump || report.Skipped[0].Line != 3 || !errors.Is(report.Skipped[0], ErrInvalidAccountStatus) {
+		t.Errorf("ImportLenient(): wrong skipped account %v", report.Skipped[0])
+	}
+	if report.Skipped[1].File != paymentsDump || !errors.Is(report.Skipped[1], ErrInvalidFieldCount) {
+		t.Errorf("ImportLenient(): wrong skipped payment %v", report.Skipped[1])
+	}
+	for _, id := range []int64{1, 3} {
+		if _, err := service.FindAccountByID(id); err != nil {
+			t.Errorf("ImportLenient(): account %d must be imported, %v", id, err)
+		}
+	}
+	if _, err := service.FindAccountByID(2); err != ErrAccountNotFound {
+		t.Errorf("ImportLenient(): account 2 must be skipped, returned %v", err)
+	}
+}
+
+func TestService_ImportFromFileLenient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+	writeTestFiles(t, filepath.Dir(path), map[string]string{
+		"accounts.txt": "1;+992000000001;900|x;+992000000002;100|#wallet;accounts;3|3;+992000000003;100;0;0;TJS;ACTIVE|",
+	})
+
+	err := (&Service{}).ImportFromFile(path)
+	if err == nil || err.Error() != "accounts.txt:2: id: invalid integer" {
+		t.Errorf("ImportFromFile(): wrong error %v", err)
+	}
+
+	service := &Service{}
+	report, err := service.ImportFromFileLenient(path)
+	if err != nil {
+		t.Fatalf("ImportFromFileLenient(): can't import, %v", err)
+	}
+	if len(report.Skipped) != 1 || report.Skipped[0].Line != 2 {
+		t.Errorf("ImportFromFileLenient(): wrong report %v", report.Skipped)
+	}
+	for _, id := range []int64{1, 3} {
+		if _, err := service.FindAccountByID(id); err != nil {
+			t.Errorf("ImportFromFileLenient(): account %d must be imported, %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package wallet
+
+import (
+	"github.com/akhrorov/wallet/pkg/types"
+	"strings"
+)
+
+// Разбор записей версий 1 и 2: поля разделены ";" без экранирования,
+// новые поля добавлялись в конец, поэтому записи без них разбираются со значениями по умолчанию.
+var legacyDumpReader = dumpReader{
+	account:        parseAccountV2,
+	payment:        parsePaymentV2,
+	favorite:       parseFavoriteV2,
+	posting:        parsePostingV2,
+	idempotencyKey: parseIdempotencyKeyV2,
+	schedule:       parseScheduleV2,
+}
+
+// parseAccountV2 понимает и старые форматы без времени (id;phone;balance), без валюты и без состояния.
+func parseAccountV2(line string) (*types.Account, error) {
+	fields := strings.Split(line, ";")
+	if len(fields) < 3 {
+		return nil, fieldCountError(3, len(fields))
+	}
+	r := fieldReader{fields: fields}
+	account := &types.Account{
+		ID:       r.int(0, "id"),
+		Phone:    r.phone(1, "phone"),
+		Balance:  types.Money(r.int(2, "balance")),
+		Currency: types.DefaultCurrency,
+		Status:   types.AccountStatusActive,
+	}
+	if len(fields) >= 5 {
+		account.CreatedAt = r.time(3, "createdAt")
+		account.UpdatedAt = r.time(4, "updatedAt")
+	}
+	if len(fields) >= 6 {
+		account.Currency = r.currency(5, "currency")
+	}
+	if len(fields) >= 7 {
+		account.Status = r.accountStatus(6, "status")
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return account, nil
+}
+
+// parsePaymentV2 понимает и старые форматы: без времени (5 полей), перевод без времени (6 полей) и без валюты (8 полей).
+func parsePaymentV2(line string) (*types.Payment, error) {
+	fields := strings.Split(line, ";")
+	if len(fields) < 5 {
+		return nil, fieldCountError(5, len(fields))
+	}
+	r := fieldReader{fields: fields}
+	payment := &types.Payment{
+		ID:        fields[0],
+		Amount:    types.Money(r.int(1, "amount")),
+		Category:  types.PaymentCategory(fields[2]),
+		AccountID: r.int(3, "accountID"),
+		Status:    types.PaymentStatus(fields[4]),
+		Currency:  types.DefaultCurrency,
+	}
+	if len(fields) > 5 {
+		payment.LinkedID = fields[5]
+	}
+	if len(fields) >= 8 {
+		payment.CreatedAt = r.time(6, "createdAt")
+		payment.UpdatedAt = r.time(7, "updatedAt")
+	}
+	if len(fields) >= 9 {
+		payment.Currency = r.currency(8, "currency")
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return payment, nil
+}
+
+// parseFavoriteV2 понимает и старый формат без времени. Название может содержать ";",
+// поэтому время читается с конца строки, только если последние два поля - числа.
+func parseFavoriteV2(line string) (*types.Favorite, error) {
+	fields := strings.Split(line, ";")
+	if len(fields) < 5 {
+		return nil, fieldCountError(5, len(fields))
+	}
+	r := fieldReader{fields: fields}
+	favorite := &types.Favorite{
+		ID:        fields[0],
+		Amount:    types.Money(r.int(1, "amount")),
+		Category:  types.PaymentCategory(fields[2]),
+		AccountID: r.int(3, "accountID"),
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	name := fields[4:]
+	if len(name) >= 3 {
+		times := fieldReader{fields: name[len(name)-2:]}
+		createdAt, updatedAt := times.time(0, "createdAt"), times.time(1, "updatedAt")
+		if times.err == nil {
+			favorite.CreatedAt, favorite.UpdatedAt = createdAt, updatedAt
+			name = name[:len(name)-2]
+		}
+	}
+	favorite.Name = strings.Join(name, ";")
+	return favorite, nil
+}
+
+func parsePostingV2(line string) (*types.Posting, error) {
+	fields := strings.Split(line, ";")
+	if len(fields) < 6 {
+		return nil, fieldCountError(6, len(fields))
+	}
+	r := fieldReader{fields: fields}
+	posting := &types.Posting{
+		ID:        fields[0],
+		Reason:    types.PostingReason(fields[1]),
+		PaymentID: fields[2],
+		From:      fields[3],
+		To:        fields[4],
+		Amount:    types.Money(r.int(5, "amount")),
+		Currency:  types.DefaultCurrency,
+	}
+	if len(fields) >= 7 {
+		posting.CreatedAt = r.time(6, "createdAt")
+	}
+	if len(fields) >= 8 {
+		posting.Currency = r.currency(7, "currency")
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return posting, nil
+}
+
+// parseIdempotencyKeyV2 разбирает запись ключа. Request сам содержит ";", поэтому поля читаются с краёв.
+func parseIdempotencyKeyV2(line string) (*types.IdempotencyKey, error) {
+	fields := strings.Split(line, ";")
+	if len(fields) < 5 {
+		return nil, fieldCountError(5, len(fields))
+	}
+	last := len(fields) - 1
+	r := fieldReader{fields: fields}
+	key := &types.IdempotencyKey{
+		Key:       fields[0],
+		Operation: fields[1],
+		Request:   strings.Join(fields[2:last-1], ";"),
+		PaymentID: fields[last-1],
+		CreatedAt: r.time(last, "createdAt"),
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return key, nil
+}
+
+// parseScheduleV2 разбирает запись расписания. LastError может содержать ";", поэтому это последнее поле.
+func parseScheduleV2(line string) (*types.Schedule, error) {
+	fields := strings.Split(line, ";")
+	if len(fields) < 9 {
+		return nil, fieldCountError(9, len(fields))
+	}
+	r := fieldReader{fields: fields}
+	schedule := &types.Schedule{
+		ID:            fields[0],
+		FavoriteID:    fields[1],
+		Spec:          fields[2],
+		NextRun:       r.time(3, "nextRun"),
+		Attempts:      int(r.int(4, "attempts")),
+		LastPaymentID: fields[5],
+		CreatedAt:     r.time(6, "createdAt"),
+		UpdatedAt:     r.time(7, "updatedAt"),
+		LastError:     strings.Join(fields[8:], ";"),
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return schedule, nil
+}
@@ -0,0 +1,108 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/akhrorov/wallet/pkg/types"
+	"strings"
+)
+
+var ErrFavoriteAccessDenied = errors.New("favorite belongs to another account")
+var ErrInvalidFavoriteName = errors.New("invalid favorite name")
+
+// FavoritesByAccount возвращает элементы "Избранное" счёта accountID в порядке создания.
+func (s *Service) FavoritesByAccount(accountID int64) ([]*types.Favorite, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repository().FavoritesByAccount(accountID)
+}
+
+// RenameFavorite меняет название элемента "Избранное" favoriteID, принадлежащего счёту accountID.
+func (s *Service) RenameFavorite(accountID int64, favoriteID string, name string) (*types.Favorite, error) {
+	if name == "" || strings.ContainsAny(name, "\r\n") {
+		return nil, ErrInvalidFavoriteName
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	favorite, err := s.findOwnFavorite(accountID, favoriteID)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := *favorite
+	updated.Name = name
+	return s.updateFavorite(&updated)
+}
+
+// UpdateFavoriteAmount меняет сумму элемента "Избранное" favoriteID, принадлежащего счёту accountID.
+// Новая сумма используется в следующих платежах, в том числе по расписанию.
+func (s *Service) UpdateFavoriteAmount(accountID int64, favoriteID string, amount types.Money) (*types.Favorite, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	favorite, err := s.findOwnFavorite(accountID, favoriteID)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := *favorite
+	updated.Amount = amount
+	return s.updateFavorite(&updated)
+}
+
+// RemoveFavorite удаляет элемент "Избранное" favoriteID, принадлежащий счёту accountID,
+// вместе с расписаниями платежей по нему.
+func (s *Service) RemoveFavorite(accountID int64, favoriteID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.findOwnFavorite(accountID, favoriteID)
+	if err != nil {
+		return err
+	}
+
+	deleted := []deletedRecord{{kind: kindFavorite, id: favoriteID}}
+	schedules, err := s.repository().Schedules()
+	if err != nil {
+		return err
+	}
+	for _, schedule := range schedules {
+		if schedule.FavoriteID == favoriteID {
+			deleted = append(deleted, deletedRecord{kind: kindSchedule, id: schedule.ID})
+		}
+	}
+
+	return s.apply(journalEntry{op: opRemoveFavorite, deleted: deleted})
+}
+
+// findOwnFavorite возвращает элемент "Избранное" favoriteID, если он принадлежит счёту accountID.
+func (s *Service) findOwnFavorite(accountID int64, favoriteID string) (*types.Favorite, error) {
+	favorite, err := s.findFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+	if favorite.AccountID != accountID {
+		return nil, ErrFavoriteAccessDenied
+	}
+	return favorite, nil
+}
+
+func (s *Service) updateFavorite(updated *types.Favorite) (*types.Favorite, error) {
+	updated.UpdatedAt = s.currentTime()
+	err := s.apply(journalEntry{op: opUpdateFavorite, favorites: []*types.Favorite{updated}})
+	if err != nil {
+		return nil, err
+	}
+	return s.findFavoriteByID(updated.ID)
+}
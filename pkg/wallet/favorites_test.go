@@ -0,0 +1,267 @@
+package wallet
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestService_FavoritesByAccount(t *testing.T) {
+	service := &Service{}
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	other, _, err := service.addAccount(defaultExampleTestAccount2)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	first, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+	second, err := service.FavoritePayment(payments[0].ID, "my other car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+
+	favorites, err := service.FavoritesByAccount(account.ID)
+	if err != nil || len(favorites) != 2 || !reflect.DeepEqual(favorites[0], first) || !reflect.DeepEqual(favorites[1], second) {
+		t.Errorf("FavoritesByAccount(): want %v and %v, result %v, %v", first, second, favorites, err)
+	}
+	favorites, err = service.FavoritesByAccount(other.ID)
+	if err != nil || len(favorites) != 0 {
+		t.Errorf("FavoritesByAccount(): want no favorites, result %v, %v", favorites, err)
+	}
+	_, err = service.FavoritesByAccount(100)
+	if err != ErrAccountNotFound {
+		t.Errorf("FavoritesByAccount(): must return ErrAccountNotFound, returned %v", err)
+	}
+}
+
+func TestService_RenameFavorite(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	service := &Service{}
+	service.SetClock(func() time.Time { return now })
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	other, _, err := service.addAccount(defaultExampleTestAccount2)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	renamed, err := service.RenameFavorite(account.ID, favorite.ID, "family car")
+	if err != nil || renamed.Name != "family car" || !renamed.UpdatedAt.Equal(now) {
+		t.Errorf("RenameFavorite(): wrong result %v, %v", renamed, err)
+	}
+	saved, err := service.FindFavoriteByID(favorite.ID)
+	if err != nil || !reflect.DeepEqual(saved, renamed) {
+		t.Errorf("RenameFavorite(): want saved %v, result %v, %v", renamed, saved, err)
+	}
+	_, err = service.RenameFavorite(other.ID, favorite.ID, "stolen car")
+	if err != ErrFavoriteAccessDenied {
+		t.Errorf("RenameFavorite(): must return ErrFavoriteAccessDenied, returned %v", err)
+	}
+	_, err = service.RenameFavorite(account.ID, favorite.ID, "")
+	if err != ErrInvalidFavoriteName {
+		t.Errorf("RenameFavorite(): must return ErrInvalidFavoriteName, returned %v", err)
+	}
+	_, err = service.RenameFavorite(account.ID, "unknown", "car")
+	if err != ErrFavoriteNotFound {
+		t.Errorf("RenameFavorite(): must return ErrFavoriteNotFound, returned %v", err)
+	}
+}
+
+func TestService_UpdateFavoriteAmount(t *testing.T) {
+	service := &Service{}
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	other, _, err := service.addAccount(defaultExampleTestAccount2)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+
+	_, err = service.UpdateFavoriteAmount(account.ID, favorite.ID, 50_00)
+	if err != nil {
+		t.Fatalf("UpdateFavoriteAmount(): can't update amount, %v", err)
+	}
+	payment, err := service.PayFromFavorite(favorite.ID)
+	if err != nil || payment.Amount != 50_00 {
+		t.Errorf("PayFromFavorite(): want payment of 5000, result %v, %v", payment, err)
+	}
+	_, err = service.UpdateFavoriteAmount(other.ID, favorite.ID, 1)
+	if err != ErrFavoriteAccessDenied {
+		t.Errorf("UpdateFavoriteAmount(): must return ErrFavoriteAccessDenied, returned %v", err)
+	}
+	_, err = service.UpdateFavoriteAmount(account.ID, favorite.ID, 0)
+	if err != ErrAmountMustBePositive {
+		t.Errorf("UpdateFavoriteAmount(): must return ErrAmountMustBePositive, returned %v", err)
+	}
+}
+
+func TestService_RemoveFavorite(t *testing.T) {
+	dir := t.TempDir()
+	service := &Service{}
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	other, _, err := service.addAccount(defaultExampleTestAccount2)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	kept, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+	removed, err := service.FavoritePayment(payments[0].ID, "old car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+	schedule, err := service.SchedulePayment(removed.ID, "daily")
+	if err != nil {
+		t.Fatalf("SchedulePayment(): can't schedule, %v", err)
+	}
+	err = service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+
+	err = service.RemoveFavorite(other.ID, removed.ID)
+	if err != ErrFavoriteAccessDenied {
+		t.Errorf("RemoveFavorite(): must return ErrFavoriteAccessDenied, returned %v", err)
+	}
+	err = service.RemoveFavorite(account.ID, removed.ID)
+	if err != nil {
+		t.Fatalf("RemoveFavorite(): can't remove favorite, %v", err)
+	}
+	_, err = service.FindFavoriteByID(removed.ID)
+	if err != ErrFavoriteNotFound {
+		t.Errorf("FindFavoriteByID(): must return ErrFavoriteNotFound, returned %v", err)
+	}
+	_, err = service.FindScheduleByID(schedule.ID)
+	if err != ErrScheduleNotFound {
+		t.Errorf("FindScheduleByID(): schedule must be removed with favorite, returned %v", err)
+	}
+
+	err = service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+	imported := &Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	_, err = imported.FindFavoriteByID(removed.ID)
+	if err != ErrFavoriteNotFound {
+		t.Errorf("Import(): removed favorite must not be exported, returned %v", err)
+	}
+	_, err = imported.FindFavoriteByID(kept.ID)
+	if err != nil {
+		t.Errorf("Import(): can't find kept favorite, %v", err)
+	}
+
+	err = service.RemoveFavorite(account.ID, kept.ID)
+	if err != nil {
+		t.Fatalf("RemoveFavorite(): can't remove favorite, %v", err)
+	}
+	err = service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+	for _, name := range []string{favoritesDump, schedulesDump} {
+		_, err = os.Stat(filepath.Join(dir, name))
+		if !os.IsNotExist(err) {
+			t.Errorf("Export(): %s must be removed when empty, %v", name, err)
+		}
+	}
+}
+
+func TestService_Import_updatesFavorite(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	service := &Service{}
+	service.SetClock(func() time.Time { return now })
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+	err = service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+	imported := &Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	_, err = service.RenameFavorite(account.ID, favorite.ID, "family car")
+	if err != nil {
+		t.Fatalf("RenameFavorite(): can't rename favorite, %v", err)
+	}
+	err = service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	got, err := imported.FindFavoriteByID(favorite.ID)
+	if err != nil || got.Name != "family car" {
+		t.Errorf("Import(): want renamed favorite, result %v, %v", got, err)
+	}
+}
+
+func TestFileRepository_DeleteFavorite(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileRepository(dir)
+	if err != nil {
+		t.Fatalf("NewFileRepository(): can't create repository, %v", err)
+	}
+	service := NewService(repo)
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+	err = service.RemoveFavorite(account.ID, favorite.ID)
+	if err != nil {
+		t.Fatalf("RemoveFavorite(): can't remove favorite, %v", err)
+	}
+
+	reopened, err := NewFileRepository(dir)
+	if err != nil {
+		t.Fatalf("NewFileRepository(): can't reopen repository, %v", err)
+	}
+	defer reopened.Close()
+	_, err = NewService(reopened).FindFavoriteByID(favorite.ID)
+	if err != ErrFavoriteNotFound {
+		t.Errorf("FindFavoriteByID(): removed favorite must not be loaded, returned %v", err)
+	}
+}
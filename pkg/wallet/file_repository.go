@@ -0,0 +1,204 @@
+package wallet
+
+import (
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/types"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// repositoryLog - журнал FileRepository: изменения после последнего снимка.
+const repositoryLog = "repository.log"
+
+// compactLogEntries - наименьшее число записей журнала, после которого FileRepository сворачивает его в снимок.
+const compactLogEntries = 1_000
+
+// FileRepository хранит данные в памяти и в каталоге dir: снимок - файлы accounts.dump, payments.dump,
+// favorites.dump, postings.dump, idempotency.dump, schedules.dump в том же формате, что и Export,
+// и журнал repository.log с изменениями после снимка.
+// Каждое изменение целиком дописывается в журнал (с fsync) и только после этого применяется в памяти,
+// поэтому после сбоя в хранилище остаются либо все его записи, либо ни одной.
+// Когда в журнале записей не меньше, чем в снимке, журнал сворачивается в новый снимок,
+// так что запись изменения в среднем не зависит от объёма данных.
+type FileRepository struct {
+	*MemoryRepository
+	dir    string
+	log    *journal
+	logged int
+}
+
+// NewFileRepository создаёт хранилище в каталоге dir и загружает из него ранее сохранённые данные.
+// Если каталога нет, он будет создан. После работы хранилище нужно закрыть через Close.
+func NewFileRepository(dir string) (*FileRepository, error) {
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := &FileRepository{MemoryRepository: NewMemoryRepository(), dir: dir}
+	err = repo.load()
+	if err != nil {
+		return nil, err
+	}
+
+	changes, entries, err := openJournal(filepath.Join(dir, repositoryLog))
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		err = saveEntry(repo.MemoryRepository, entry)
+		if err != nil {
+			if cerr := changes.close(); cerr != nil {
+				log.Print(cerr)
+			}
+			return nil, err
+		}
+	}
+	repo.log = changes
+	repo.logged = len(entries)
+
+	return repo, nil
+}
+
+func (r *FileRepository) load() error {
+	accounts, err := readAccounts(filepath.Join(r.dir, accountsDump), nil)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	payments, err := readPayments(filepath.Join(r.dir, paymentsDump), nil)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	favorites, err := readFavorites(filepath.Join(r.dir, favoritesDump), nil)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	postings, err := readPostings(filepath.Join(r.dir, postingsDump), nil)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	keys, err := readIdempotencyKeys(filepath.Join(r.dir, idempotencyDump), nil)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	schedules, err := readSchedules(filepath.Join(r.dir, schedulesDump), nil)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return saveEntry(r.MemoryRepository, journalEntry{
+		accounts:  accounts,
+		payments:  payments,
+		favorites: favorites,
+		postings:  postings,
+		keys:      keys,
+		schedules: schedules,
+	})
+}
+
+// saveEntry дописывает entry в журнал и применяет его в памяти.
+// Если записать не удалось, память не изменяется.
+func (r *FileRepository) saveEntry(entry journalEntry) error {
+	for _, record := range entry.deleted {
+		if record.kind != kindFavorite && record.kind != kindSchedule && record.kind != kindIdempotencyKey {
+			return fmt.Errorf("%w: unknown record kind %q", ErrJournalCorrupted, record.kind)
+		}
+	}
+	_, err := r.log.append(entry)
+	if err != nil {
+		return err
+	}
+	err = saveEntry(r.MemoryRepository, entry)
+	if err != nil {
+		return err
+	}
+
+	r.logged++
+	if r.logged >= compactLogEntries && r.logged >= r.size() {
+		// изменение уже сохранено в журнале, поэтому ошибка свёртки его не отменяет: свёртка повторится позже
+		if err := r.compact(); err != nil {
+			log.Print(err)
+		}
+	}
+	return nil
+}
+
+// size возвращает число записей в хранилище.
+func (r *FileRepository) size() int {
+	return len(r.accounts) + len(r.payments) + len(r.favorites) + len(r.postings) + len(r.keys) + len(r.schedules)
+}
+
+// compact записывает снимок текущего состояния и очищает журнал.
+// Если сбой прервал запись снимка, журнал не очищен: его записи содержат состояние записей после изменения,
+// поэтому при загрузке поверх любой смеси старого и нового снимка получается то же состояние.
+func (r *FileRepository) compact() error {
+	writes := []struct {
+		name  string
+		write func(path string) error
+	}{
+		{accountsDump, func(path string) error { return writeAccounts(path, r.accounts) }},
+		{paymentsDump, func(path string) error { return writePayments(path, r.payments) }},
+		{favoritesDump, func(path string) error { return writeFavorites(path, r.favorites) }},
+		{postingsDump, func(path string) error { return writePostings(path, r.postings) }},
+		{idempotencyDump, func(path string) error { return writeIdempotencyKeys(path, r.keys) }},
+		{schedulesDump, func(path string) error { return writeSchedules(path, r.schedules) }},
+	}
+	for _, file := range writes {
+		err := replaceFile(filepath.Join(r.dir, file.name), file.write)
+		if err != nil {
+			return err
+		}
+	}
+	err := r.log.reset()
+	if err != nil {
+		return err
+	}
+	r.logged = 0
+	return nil
+}
+
+// Close сворачивает журнал в снимок и закрывает его.
+func (r *FileRepository) Close() error {
+	err := r.compact()
+	if cerr := r.log.close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (r *FileRepository) SaveAccount(account *types.Account) error {
+	return r.saveEntry(journalEntry{op: opSave, accounts: []*types.Account{account}})
+}
+
+func (r *FileRepository) SavePayment(payment *types.Payment) error {
+	return r.saveEntry(journalEntry{op: opSave, payments: []*types.Payment{payment}})
+}
+
+func (r *FileRepository) SaveFavorite(favorite *types.Favorite) error {
+	return r.saveEntry(journalEntry{op: opSave, favorites: []*types.Favorite{favorite}})
+}
+
+func (r *FileRepository) DeleteFavorite(favoriteID string) error {
+	return r.saveEntry(journalEntry{op: opSave, deleted: []deletedRecord{{kind: kindFavorite, id: favoriteID}}})
+}
+
+func (r *FileRepository) SavePosting(posting *types.Posting) error {
+	return r.saveEntry(journalEntry{op: opSave, postings: []*types.Posting{posting}})
+}
+
+func (r *FileRepository) SaveIdempotencyKey(record *types.IdempotencyKey) error {
+	return r.saveEntry(journalEntry{op: opSave, keys: []*types.IdempotencyKey{record}})
+}
+
+func (r *FileRepository) DeleteIdempotencyKey(key string) error {
+	return r.saveEntry(journalEntry{op: opSave, deleted: []deletedRecord{{kind: kindIdempotencyKey, id: key}}})
+}
+
+func (r *FileRepository) SaveSchedule(schedule *types.Schedule) error {
+	return r.saveEntry(journalEntry{op: opSave, schedules: []*types.Schedule{schedule}})
+}
+
+func (r *FileRepository) DeleteSchedule(scheduleID string) error {
+	return r.saveEntry(journalEntry{op: opSave, deleted: []deletedRecord{{kind: kindSchedule, id: scheduleID}}})
+}
@@ -0,0 +1,438 @@
+package wallet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/record"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format - формат файлов выгрузки. Формат файла определяется по расширению имени.
+type Format string
+
+const (
+	// FormatDump - записи через ";" с заголовком версии (см. dumpVersion и пакет record).
+	FormatDump Format = "dump"
+	// FormatCSV - RFC 4180 CSV, первая строка - имена полей.
+	FormatCSV Format = "csv"
+	// FormatJSON - JSON Lines: по объекту на строку.
+	FormatJSON Format = "jsonl"
+)
+
+// formats - поддерживаемые форматы в порядке, в котором Import ищет файлы.
+var formats = []Format{FormatDump, FormatCSV, FormatJSON}
+
+var ErrUnknownFormat = errors.New("unknown dump format")
+var ErrInvalidTime = errors.New("invalid time")
+var ErrMissingField = errors.New("missing field")
+var ErrInvalidValue = errors.New("invalid value")
+
+// Extension возвращает расширение файлов формата, например ".csv".
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+func (f Format) valid() bool {
+	for _, format := range formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatOf возвращает формат файла path по его расширению. Расширение сжатия не учитывается,
+// например формат payments1.csv.gz - FormatCSV.
+func FormatOf(path string) (Format, error) {
+	ext := filepath.Ext(uncompressedName(path))
+	for _, format := range formats {
+		if ext == format.Extension() {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownFormat, ext)
+}
+
+// tableFormat возвращает формат файла path, если это CSV или JSON Lines.
+// Остальные файлы (выгрузки, журнал, индексы) записываются в формате FormatDump.
+// Временный файл name.tmp записывается в формате файла name, сжатый файл - в формате несжатого.
+func tableFormat(path string) (Format, bool) {
+	format, err := FormatOf(strings.TrimSuffix(path, ".tmp"))
+	if err != nil || format == FormatDump {
+		return "", false
+	}
+	return format, true
+}
+
+// dumpName возвращает имя файла выгрузки записей вида entity в формате format, например "accounts.csv".
+func dumpName(entity string, format Format) string {
+	return entity + format.Extension()
+}
+
+// dumpEntity возвращает вид записей по имени файла выгрузки, в том числе сжатого.
+func dumpEntity(name string) string {
+	name = uncompressedName(name)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+type columnKind int
+
+const (
+	columnString columnKind = iota
+	columnInt
+	columnTime
+)
+
+// column - поле записи в CSV и JSON. Поля идут в том же порядке, что и в записи FormatDump.
+type column struct {
+	name string
+	kind columnKind
+}
+
+// tableColumns - поля записей каждого вида. Записи CSV и JSON разбираются и записываются
+// через запись текущей версии FormatDump, поэтому значения в ней - строки, числа и время в наносекундах.
+var tableColumns = map[string][]column{
+	entityAccounts: {
+		{"id", columnInt}, {"phone", columnString}, {"balance", columnInt}, {"createdAt", columnTime},
+		{"updatedAt", columnTime}, {"currency", columnString}, {"status", columnString},
+	},
+	entityPayments: {
+		{"id", columnString}, {"amount", columnInt}, {"category", columnString}, {"accountID", columnInt}, {"status", columnString},
+		{"linkedID", columnString}, {"createdAt", columnTime}, {"updatedAt", columnTime}, {"currency", columnString},
+	},
+	entityFavorites: {
+		{"id", columnString}, {"amount", columnInt}, {"category", columnString}, {"accountID", columnInt},
+		{"name", columnString}, {"createdAt", columnTime}, {"updatedAt", columnTime},
+	},
+	entityPostings: {
+		{"id", columnString}, {"reason", columnString}, {"paymentID", columnString}, {"from", columnString},
+		{"to", columnString}, {"amount", columnInt}, {"createdAt", columnTime}, {"currency", columnString},
+	},
+	entityIdempotency: {
+		{"key", columnString}, {"operation", columnString}, {"request", columnString}, {"paymentID", columnString}, {"createdAt", columnTime},
+	},
+	entitySchedules: {
+		{"id", columnString}, {"favoriteID", columnString}, {"spec", columnString}, {"nextRun", columnTime}, {"attempts", columnInt},
+		{"lastPaymentID", columnString}, {"createdAt", columnTime}, {"updatedAt", columnTime}, {"lastError", columnString},
+	},
+}
+
+func columnsFor(entity string) ([]column, error) {
+	columns, ok := tableColumns[entity]
+	if !ok {
+		return nil, fmt.Errorf("%w: no columns for %s", ErrUnknownFormat, entity)
+	}
+	return columns, nil
+}
+
+// formatColumnTime переводит время из наносекунд в RFC 3339, нулевое время - в пустую строку.
+func formatColumnTime(nanos string) string {
+	value, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil || value == 0 {
+		return ""
+	}
+	return time.Unix(0, value).UTC().Format(time.RFC3339Nano)
+}
+
+// parseColumnTime переводит время из RFC 3339 в наносекунды, пустую строку - в нулевое время.
+func parseColumnTime(value string) (string, error) {
+	if value == "" {
+		return "0", nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return "", ErrInvalidTime
+	}
+	return formatTime(t), nil
+}
+
+// recordEncoder переводит записи текущей версии FormatDump в формат format.
+// Записи кодируются по одной, чтобы можно было узнать размер записи до её записи в файл.
+type recordEncoder struct {
+	format  Format
+	entity  string
+	columns []column
+	buf     bytes.Buffer
+	csv     *csv.Writer
+}
+
+// newRecordEncoder возвращает кодировщик записей вида entity. Для FormatDump записи не меняются,
+// поэтому так записываются и файлы, для которых нет полей CSV и JSON (журнал, манифест).
+func newRecordEncoder(format Format, entity string) (*recordEncoder, error) {
+	if !format.valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+	encoder := &recordEncoder{format: format, entity: entity}
+	if format == FormatDump {
+		return encoder, nil
+	}
+	columns, err := columnsFor(entity)
+	if err != nil {
+		return nil, err
+	}
+	encoder.columns = columns
+	encoder.csv = csv.NewWriter(&encoder.buf)
+	return encoder, nil
+}
+
+// header возвращает начало файла: заголовок с версией, строку с именами полей CSV или ничего для JSON Lines.
+func (e *recordEncoder) header() (string, error) {
+	switch e.format {
+	case FormatDump:
+		return formatDumpHeader(e.entity), nil
+	case FormatCSV:
+		names := []string{}
+		for _, column := range e.columns {
+			names = append(names, column.name)
+		}
+		return e.encodeCSV(names)
+	default:
+		return "", nil
+	}
+}
+
+// encode переводит запись line (с переводом строки в конце) в формат кодировщика.
+func (e *recordEncoder) encode(line string) (string, error) {
+	if e.format == FormatDump {
+		return line, nil
+	}
+	fields, err := record.Split(strings.TrimSuffix(line, "\n"))
+	if err != nil {
+		return "", err
+	}
+	if len(fields) != len(e.columns) {
+		return "", fieldCountError(len(e.columns), len(fields))
+	}
+
+	if e.format == FormatCSV {
+		for i, column := range e.columns {
+			if column.kind == columnTime {
+				fields[i] = formatColumnTime(fields[i])
+			}
+		}
+		return e.encodeCSV(fields)
+	}
+	return e.encodeJSON(fields)
+}
+
+func (e *recordEncoder) encodeCSV(fields []string) (string, error) {
+	e.buf.Reset()
+	err := e.csv.Write(fields)
+	if err != nil {
+		return "", err
+	}
+	e.csv.Flush()
+	return e.buf.String(), e.csv.Error()
+}
+
+// encodeJSON записывает объект с полями в порядке columns: числа - числами, время - строкой RFC 3339 или null.
+func (e *recordEncoder) encodeJSON(fields []string) (string, error) {
+	object := []byte{'{'}
+	for i, column := range e.columns {
+		if i > 0 {
+			object = append(object, ',')
+		}
+		object = append(strconv.AppendQuote(object, column.name), ':')
+		switch column.kind {
+		case columnInt:
+			object = append(object, fields[i]...)
+		case columnTime:
+			value := formatColumnTime(fields[i])
+			if value == "" {
+				object = append(object, "null"...)
+				continue
+			}
+			object = strconv.AppendQuote(object, value)
+		default:
+			value, err := json.Marshal(fields[i])
+			if err != nil {
+				return "", err
+			}
+			object = append(object, value...)
+		}
+	}
+	return string(append(object, '}', '\n')), nil
+}
+
+// tableReader читает записи в формате CSV или JSON Lines. После последней записи read возвращает io.EOF.
+// line - номер строки записи (для CSV - номер записи, считая строку с именами полей).
+type tableReader interface {
+	read() (fields []string, line int, err error)
+}
+
+func newTableReader(r io.Reader, format Format, columns []column) (tableReader, error) {
+	switch format {
+	case FormatCSV:
+		reader := &csvReader{r: csv.NewReader(r), columns: columns}
+		reader.r.FieldsPerRecord = len(columns)
+		header, err := reader.r.Read()
+		if err == io.EOF {
+			return reader, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		reader.line = 1
+		for i, column := range columns {
+			if header[i] != column.name {
+				return nil, fmt.Errorf("%w: column %d is %q, want %q", ErrInvalidDumpHeader, i+1, header[i], column.name)
+			}
+		}
+		return reader, nil
+	case FormatJSON:
+		return &jsonReader{r: bufio.NewReader(r), columns: columns}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns []column
+	line    int
+}
+
+func (c *csvReader) read() ([]string, int, error) {
+	row, err := c.r.Read()
+	if err == io.EOF {
+		return nil, c.line, err
+	}
+	c.line++
+	if errors.Is(err, csv.ErrFieldCount) {
+		return nil, c.line, fieldCountError(len(c.columns), len(row))
+	}
+	if err != nil {
+		return nil, c.line, err
+	}
+	for i, column := range c.columns {
+		if column.kind != columnTime {
+			continue
+		}
+		row[i], err = parseColumnTime(row[i])
+		if err != nil {
+			return nil, c.line, &ParseError{Field: column.name, Err: err}
+		}
+	}
+	return row, c.line, nil
+}
+
+type jsonReader struct {
+	r       *bufio.Reader
+	columns []column
+	line    int
+}
+
+func (j *jsonReader) read() ([]string, int, error) {
+	for {
+		line, err := j.r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, j.line, err
+		}
+		if err == io.EOF && len(line) == 0 {
+			return nil, j.line, io.EOF
+		}
+		j.line++
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields, err := j.parse(line)
+		return fields, j.line, err
+	}
+}
+
+func (j *jsonReader) parse(line string) ([]string, error) {
+	object := map[string]json.RawMessage{}
+	err := json.Unmarshal([]byte(line), &object)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, len(j.columns))
+	for i, column := range j.columns {
+		value, ok := object[column.name]
+		if !ok {
+			return nil, &ParseError{Field: column.name, Err: ErrMissingField}
+		}
+		switch column.kind {
+		case columnInt:
+			var number json.Number
+			if json.Unmarshal(value, &number) != nil {
+				return nil, &ParseError{Field: column.name, Err: ErrInvalidInteger}
+			}
+			fields[i] = number.String()
+		case columnTime:
+			var text *string
+			if json.Unmarshal(value, &text) != nil {
+				return nil, &ParseError{Field: column.name, Err: ErrInvalidTime}
+			}
+			fields[i] = "0"
+			if text != nil {
+				fields[i], err = parseColumnTime(*text)
+				if err != nil {
+					return nil, &ParseError{Field: column.name, Err: err}
+				}
+			}
+		default:
+			if json.Unmarshal(value, &fields[i]) != nil {
+				return nil, &ParseError{Field: column.name, Err: ErrInvalidValue}
+			}
+		}
+	}
+	return fields, nil
+}
+
+// readTable читает файл path формата format с записями вида entity и передаёт handle
+// каждую запись в текущей версии FormatDump. Ошибки обрабатываются так же, как в readDump.
+func readTable(path string, format Format, entity string, report *ImportReport, handle func(reader dumpReader, line string) error) error {
+	columns, err := columnsFor(entity)
+	if err != nil {
+		return err
+	}
+	file, err := openDump(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+
+	name := filepath.Base(path)
+	table, err := newTableReader(file, format, columns)
+	if err != nil {
+		return newParseError(name, 1, err)
+	}
+	for {
+		fields, line, err := table.read()
+		if err == io.EOF {
+			return nil
+		}
+		var csvErr *csv.ParseError
+		if errors.As(err, &csvErr) {
+			// после ошибки в кавычках CSV границы записей неизвестны, дальше читать нельзя
+			return newParseError(name, csvErr.Line, csvErr.Err)
+		}
+		if err == nil {
+			err = handle(currentDumpReader, record.Join(fields...))
+		}
+		if err == errStopReading {
+			return nil
+		}
+		if err != nil {
+			err = report.skip(newParseError(name, line, err))
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
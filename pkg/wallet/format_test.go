@@ -0,0 +1,186 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/akhrorov/wallet/pkg/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// formatTestService возвращает сервис со счётом, платежами, избранным и расписанием,
+// в названиях которых есть символы, которые нужно экранировать во всех форматах.
+func formatTestService(t *testing.T) (*Service, *types.Favorite, *types.Schedule) {
+	t.Helper()
+	service := &Service{}
+	service.SetClock(func() time.Time { return time.Date(2021, 3, 1, 10, 0, 0, 123, time.UTC) })
+	_, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my \"car\", wash;|\\\nsecond line")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+	schedule, err := service.SchedulePayment(favorite.ID, "daily")
+	if err != nil {
+		t.Fatalf("SchedulePayment(): can't schedule payment, %v", err)
+	}
+	return service, favorite, schedule
+}
+
+func TestService_ExportAs_roundTrip(t *testing.T) {
+	service, favorite, schedule := formatTestService(t)
+	accounts, _ := service.repository().Accounts()
+	payments, _ := service.repository().Payments()
+
+	for _, format := range formats {
+		t.Run(string(format), func(t *testing.T) {
+			dir := t.TempDir()
+			err := service.ExportAs(dir, format)
+			if err != nil {
+				t.Fatalf("ExportAs(): can't export, %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, dumpName(entityAccounts, format))); err != nil {
+				t.Errorf("ExportAs(): accounts must be written in %s, %v", format, err)
+			}
+
+			imported := &Service{}
+			err = imported.Import(dir)
+			if err != nil {
+				t.Fatalf("Import(): can't import, %v", err)
+			}
+			for _, account := range accounts {
+				got, err := imported.FindAccountByID(account.ID)
+				if err != nil || !reflect.DeepEqual(got, account) {
+					t.Errorf("Import(): want account %v, result %v, %v", account, got, err)
+				}
+			}
+			for _, payment := range payments {
+				got, err := imported.FindPaymentByID(payment.ID)
+				if err != nil || !reflect.DeepEqual(got, payment) {
+					t.Errorf("Import(): want payment %v, result %v, %v", payment, got, err)
+				}
+			}
+			gotFavorite, err := imported.FindFavoriteByID(favorite.ID)
+			if err != nil || !reflect.DeepEqual(gotFavorite, favorite) {
+				t.Errorf("Import(): want favorite %v, result %v, %v", favorite, gotFavorite, err)
+			}
+			gotSchedule, err := imported.repository().ScheduleByID(schedule.ID)
+			if err != nil || !reflect.DeepEqual(gotSchedule, schedule) {
+				t.Errorf("Import(): want schedule %v, result %v, %v", schedule, gotSchedule, err)
+			}
+		})
+	}
+}
+
+func TestService_ExportAs_replacesFormat(t *testing.T) {
+	service, favorite, _ := formatTestService(t)
+	dir := t.TempDir()
+	err := service.ExportAs(dir, FormatCSV)
+	if err != nil {
+		t.Fatalf("ExportAs(): can't export, %v", err)
+	}
+	err = service.ExportAs(dir, FormatJSON)
+	if err != nil {
+		t.Fatalf("ExportAs(): can't export, %v", err)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.csv"))
+	if len(matches) != 0 {
+		t.Errorf("ExportAs(): files of the previous format must be removed, found %v", matches)
+	}
+	imported := &Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	if _, err := imported.FindFavoriteByID(favorite.ID); err != nil {
+		t.Errorf("Import(): can't find favorite, %v", err)
+	}
+
+	err = service.ExportAs(dir, "xml")
+	if !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("ExportAs(): must return ErrUnknownFormat, returned %v", err)
+	}
+}
+
+func TestService_ExportAs_csv(t *testing.T) {
+	service, _, _ := formatTestService(t)
+	dir := t.TempDir()
+	err := service.ExportAs(dir, FormatCSV)
+	if err != nil {
+		t.Fatalf("ExportAs(): can't export, %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "accounts.csv"))
+	if err != nil {
+		t.Fatalf("ReadFile(): can't read accounts, %v", err)
+	}
+	want := "id,phone,balance,createdAt,updatedAt,currency,status\n" +
+		"1,+992900000001,900000,2021-03-01T10:00:00.000000123Z,2021-03-01T10:00:00.000000123Z,TJS,ACTIVE\n"
+	if string(content) != want {
+		t.Errorf("ExportAs(): want %q, result %q", want, content)
+	}
+}
+
+func TestImport_jsonParseError(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"payments.jsonl": `{"id":"p1","amount":100,"category":"auto","accountID":1,"status":"OK","linkedID":"","createdAt":null,"updatedAt":null,"currency":"TJS"}` + "\n" +
+			`{"id":"p2","amount":"x","category":"auto","accountID":1,"status":"OK","linkedID":"","createdAt":null,"updatedAt":null,"currency":"TJS"}` + "\n" +
+			`{"id":"p3","amount":100,"category":"auto","accountID":1,"status":"OK","linkedID":"","createdAt":null,"currency":"TJS"}` + "\n",
+	})
+
+	err := (&Service{}).Import(dir)
+	if err == nil || err.Error() != "payments.jsonl:2: amount: invalid integer" {
+		t.Errorf("Import(): wrong error %v", err)
+	}
+
+	service := &Service{}
+	report, err := service.ImportLenient(dir)
+	if err != nil {
+		t.Fatalf("ImportLenient(): can't import, %v", err)
+	}
+	if len(report.Skipped) != 2 || !errors.Is(report.Skipped[1], ErrMissingField) || report.Skipped[1].Field != "updatedAt" {
+		t.Errorf("ImportLenient(): wrong report %v", report.Skipped)
+	}
+	if _, err := service.FindPaymentByID("p1"); err != nil {
+		t.Errorf("ImportLenient(): p1 must be imported, %v", err)
+	}
+}
+
+func TestService_HistoryToShards_formats(t *testing.T) {
+	service, history := historyTestPayments(t, 5)
+	for _, format := range []Format{FormatCSV, FormatJSON} {
+		dir := t.TempDir()
+		err := service.HistoryToShards(history, dir, HistoryOptions{Records: 2, Format: format})
+		if err != nil {
+			t.Fatalf("HistoryToShards(): can't write history, %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, dumpName("payments3", format))); err != nil {
+			t.Errorf("HistoryToShards(): shard must be written in %s, %v", format, err)
+		}
+		got, err := ReadHistory(dir)
+		if err != nil || !reflect.DeepEqual(got, history) {
+			t.Errorf("ReadHistory(): want %v, result %v, %v", history, got, err)
+		}
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	for path, want := range map[string]Format{"accounts.dump": FormatDump, "a/b/payments1.csv": FormatCSV, "favorites.jsonl": FormatJSON} {
+		got, err := FormatOf(path)
+		if err != nil || got != want {
+			t.Errorf("FormatOf(%q): want %s, result %s, %v", path, want, got, err)
+		}
+	}
+	_, err := FormatOf("accounts.txt")
+	if !errors.Is(err, ErrUnknownFormat) || strings.Contains(err.Error(), "accounts") {
+		t.Errorf("FormatOf(): must return ErrUnknownFormat, returned %v", err)
+	}
+}
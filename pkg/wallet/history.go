@@ -0,0 +1,350 @@
+package wallet
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/record"
+	"github.com/akhrorov/wallet/pkg/types"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// historyIndex - файл, в котором HistoryToShards перечисляет шарды истории платежей.
+const historyIndex = "history.index"
+
+const entityHistory = "history"
+
+var ErrHistoryMismatch = errors.New("history shards do not match index")
+
+// HistoryOptions задаёт ротацию шардов истории: новый шард начинается, когда в текущем
+// Records записей или следующая запись сделает его больше Bytes байт. Нулевое значение - без ограничения.
+// Format - формат шардов, по умолчанию FormatDump. Compression - сжатие шардов, по умолчанию заданное
+// через SetCompression. Для сжатых шардов Bytes ограничивает размер записей до сжатия.
+type HistoryOptions struct {
+	Records     int
+	Bytes       int64
+	Format      Format
+	Compression Compression
+}
+
+// historyShard - шард истории в индексе: номер первого платежа в истории, число платежей,
+// время создания первого и последнего из них и контрольная сумма SHA-256 файла.
+type historyShard struct {
+	name     string
+	first    int
+	records  int
+	from     time.Time
+	to       time.Time
+	checksum string
+}
+
+func formatHistoryShard(shard historyShard) string {
+	return record.Join(shard.name, strconv.Itoa(shard.first), strconv.Itoa(shard.records), formatTime(shard.from), formatTime(shard.to), shard.checksum) + "\n"
+}
+
+func parseHistoryShard(line string) (historyShard, error) {
+	fields, err := splitRecord(line, 6)
+	if err != nil {
+		return historyShard{}, err
+	}
+	r := fieldReader{fields: fields}
+	shard := historyShard{
+		name:     fields[0],
+		first:    int(r.int(1, "first")),
+		records:  int(r.int(2, "records")),
+		from:     r.time(3, "from"),
+		to:       r.time(4, "to"),
+		checksum: fields[5],
+	}
+	if r.err != nil {
+		return historyShard{}, r.err
+	}
+	return shard, nil
+}
+
+// HistoryToShards записывает payments в шарды paymentsN.dump (paymentsN.csv, paymentsN.dump.gz, ...) каталога dir
+// с ротацией по options и индекс history.index. Шарды нумеруются с 1, даже если шард один:
+// payments.dump - файл Export, и история не должна его заменять.
+// Каждый шард записывается один раз, потоково; индекс записывается последним,
+// после него удаляются шарды прежней истории, которых нет в новом индексе.
+func (s *Service) HistoryToShards(payments []types.Payment, dir string, options HistoryOptions) error {
+	if options.Format == "" {
+		options.Format = FormatDump
+	}
+	if options.Compression == "" {
+		s.mu.RLock()
+		options.Compression = s.currentCompression()
+		s.mu.RUnlock()
+	}
+	if !options.Compression.valid() {
+		return fmt.Errorf("%w: %q", ErrUnknownCompression, options.Compression)
+	}
+	encoder, err := newRecordEncoder(options.Format, entityPayments)
+	if err != nil {
+		return err
+	}
+
+	writer := &historyWriter{dir: dir, options: options, encoder: encoder}
+	for i := range payments {
+		err := writer.write(&payments[i])
+		if err != nil {
+			writer.abort()
+			return err
+		}
+	}
+	return writer.close()
+}
+
+// ImportHistory импортирует платежи из шардов истории каталога dir (см. ReadHistory).
+// Уже существующие платежи пропускаются.
+func (s *Service) ImportHistory(dir string) error {
+	payments, err := ReadHistory(dir)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := journalEntry{op: opImport}
+	for i := range payments {
+		entry.payments = append(entry.payments, &payments[i])
+	}
+	return s.importEntry(entry)
+}
+
+// ReadHistory собирает историю платежей из шардов каталога dir в исходном порядке.
+// Шарды проверяются по индексу: непрерывность номеров, число записей и контрольная сумма.
+func ReadHistory(dir string) ([]types.Payment, error) {
+	shards, err := readHistoryIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	payments := []types.Payment{}
+	for _, shard := range shards {
+		if shard.first != len(payments) || filepath.Base(shard.name) != shard.name {
+			return nil, fmt.Errorf("%w: %s starts at %d, want %d", ErrHistoryMismatch, shard.name, shard.first, len(payments))
+		}
+		path := filepath.Join(dir, shard.name)
+		err := verifyShard(path, shard)
+		if err != nil {
+			return nil, err
+		}
+
+		shardPayments, err := readPayments(path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(shardPayments) != shard.records {
+			return nil, fmt.Errorf("%w: %s has %d records, want %d", ErrHistoryMismatch, shard.name, len(shardPayments), shard.records)
+		}
+		for _, payment := range shardPayments {
+			payments = append(payments, *payment)
+		}
+	}
+	return payments, nil
+}
+
+// readHistoryIndex читает индекс шардов истории каталога dir. Если индекса нет, возвращает ошибку os.IsNotExist.
+func readHistoryIndex(dir string) ([]historyShard, error) {
+	shards := []historyShard{}
+	err := readDump(filepath.Join(dir, historyIndex), entityHistory, nil, func(reader dumpReader, line string) error {
+		shard, err := parseHistoryShard(line)
+		if err != nil {
+			return err
+		}
+		shards = append(shards, shard)
+		return nil
+	})
+	return shards, err
+}
+
+// writeHistoryIndex записывает индекс shards в каталог dir: во временный файл, который затем заменяет прежний индекс.
+func writeHistoryIndex(dir string, shards []historyShard) error {
+	tmp := filepath.Join(dir, historyIndex+".tmp")
+	err := writeRecords(tmp, entityHistory, len(shards), func(i int) string {
+		return formatHistoryShard(shards[i])
+	})
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, historyIndex))
+}
+
+// verifyShard проверяет контрольную сумму файла path шарда shard.
+func verifyShard(path string, shard historyShard) error {
+	checksum, _, err := fileChecksum(path)
+	if err != nil {
+		return err
+	}
+	if checksum != shard.checksum {
+		return fmt.Errorf("%w: %s checksum %s, want %s", ErrHistoryMismatch, shard.name, checksum, shard.checksum)
+	}
+	return nil
+}
+
+// historyWriter записывает платежи в шарды истории, начиная новый шард по HistoryOptions.
+type historyWriter struct {
+	dir     string
+	options HistoryOptions
+	encoder *recordEncoder
+	shards  []historyShard
+	written int
+
+	file       *os.File
+	compressor compressor
+	w          *bufio.Writer
+	hash       hash.Hash
+	size       int64
+}
+
+func (h *historyWriter) write(payment *types.Payment) error {
+	item, err := h.encoder.encode(formatPayment(payment))
+	if err != nil {
+		return err
+	}
+	if h.file != nil && h.full(len(item)) {
+		err := h.closeShard()
+		if err != nil {
+			return err
+		}
+	}
+	if h.file == nil {
+		err := h.openShard()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = h.writeString(item)
+	if err != nil {
+		return err
+	}
+	shard := &h.shards[len(h.shards)-1]
+	if shard.records == 0 {
+		shard.from = payment.CreatedAt
+	}
+	shard.to = payment.CreatedAt
+	shard.records++
+	h.written++
+	return nil
+}
+
+// full сообщает, нужно ли начать новый шард перед записью длиной size.
+// В шарде всегда есть хотя бы одна запись, даже если она больше Bytes.
+func (h *historyWriter) full(size int) bool {
+	records := h.shards[len(h.shards)-1].records
+	if records == 0 {
+		return false
+	}
+	if h.options.Records > 0 && records >= h.options.Records {
+		return true
+	}
+	return h.options.Bytes > 0 && h.size+int64(size) > h.options.Bytes
+}
+
+func (h *historyWriter) openShard() error {
+	name := compressedName(dumpName(entityPayments+strconv.Itoa(len(h.shards)+1), h.options.Format), h.options.Compression)
+	file, err := os.Create(filepath.Join(h.dir, name))
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	h.file = file
+	h.hash = sha256.New()
+	h.compressor = newCompressor(io.MultiWriter(file, h.hash), h.options.Compression)
+	h.w = bufio.NewWriter(h.compressor)
+	h.size = 0
+	h.shards = append(h.shards, historyShard{name: name, first: h.written})
+	header, err := h.encoder.header()
+	if err != nil {
+		return err
+	}
+	return h.writeString(header)
+}
+
+func (h *historyWriter) writeString(s string) error {
+	n, err := h.w.WriteString(s)
+	h.size += int64(n)
+	return err
+}
+
+func (h *historyWriter) closeShard() error {
+	file := h.file
+	h.file = nil
+	err := h.w.Flush()
+	if err == nil {
+		err = h.compressor.close()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	h.shards[len(h.shards)-1].checksum = hex.EncodeToString(h.hash.Sum(nil))
+	return nil
+}
+
+// close закрывает последний шард, записывает индекс и удаляет шарды прежней истории.
+func (h *historyWriter) close() error {
+	if h.file != nil {
+		err := h.closeShard()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := writeHistoryIndex(h.dir, h.shards)
+	if err != nil {
+		return err
+	}
+	return h.removeStaleShards()
+}
+
+// removeStaleShards удаляет шарды paymentsN каталога во всех форматах, которых нет в индексе,
+// чтобы они не смешивались с новой историей. Файлы выгрузки payments.* не трогаются.
+func (h *historyWriter) removeStaleShards() error {
+	files, err := entityFiles(h.dir, entityPayments)
+	if err != nil {
+		return err
+	}
+	listed := make(map[string]bool)
+	for _, shard := range h.shards {
+		listed[shard.name] = true
+	}
+	for _, path := range files {
+		name := filepath.Base(path)
+		if dumpEntity(name) == entityPayments || listed[name] {
+			continue
+		}
+		err = removeIfExists(path)
+		if err != nil {
+			return err
+		}
+	}
+	return syncDir(h.dir)
+}
+
+// abort закрывает незаконченный шард после ошибки.
+func (h *historyWriter) abort() {
+	if h.file == nil {
+		return
+	}
+	if err := h.file.Close(); err != nil {
+		log.Print(err)
+	}
+	h.file = nil
+}
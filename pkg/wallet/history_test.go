@@ -0,0 +1,164 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/akhrorov/wallet/pkg/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// historyTestPayments возвращает сервис и историю счёта с count платежами.
+func historyTestPayments(t *testing.T, count int) (*Service, []types.Payment) {
+	t.Helper()
+	service := &Service{}
+	account, err := service.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatalf("RegisterAccount(): can't register account, %v", err)
+	}
+	err = service.Deposit(account.ID, 1_000_000)
+	if err != nil {
+		t.Fatalf("Deposit(): can't deposit, %v", err)
+	}
+	for i := 0; i < count; i++ {
+		_, err = service.Pay(account.ID, types.Money(100+i), "food")
+		if err != nil {
+			t.Fatalf("Pay(): can't pay, %v", err)
+		}
+	}
+	history, err := service.ExportAccountHistory(account.ID)
+	if err != nil {
+		t.Fatalf("ExportAccountHistory(): can't export history, %v", err)
+	}
+	return service, history
+}
+
+func TestService_HistoryToShards_records(t *testing.T) {
+	service, history := historyTestPayments(t, 5)
+	dir := t.TempDir()
+	err := service.HistoryToShards(history, dir, HistoryOptions{Records: 2})
+	if err != nil {
+		t.Fatalf("HistoryToShards(): can't write history, %v", err)
+	}
+
+	for _, name := range []string{"payments1.dump", "payments2.dump", "payments3.dump", historyIndex} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("HistoryToShards(): %s must be written, %v", name, err)
+		}
+	}
+	got, err := ReadHistory(dir)
+	if err != nil || !reflect.DeepEqual(got, history) {
+		t.Errorf("ReadHistory(): want %v, result %v, %v", history, got, err)
+	}
+}
+
+func TestService_HistoryToShards_bytes(t *testing.T) {
+	service, history := historyTestPayments(t, 10)
+	dir := t.TempDir()
+	limit := int64(len(formatDumpHeader(entityPayments)) + 3*len(formatPayment(&history[0])))
+	err := service.HistoryToShards(history, dir, HistoryOptions{Bytes: limit})
+	if err != nil {
+		t.Fatalf("HistoryToShards(): can't write history, %v", err)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "payments*.dump"))
+	if len(matches) < 4 {
+		t.Errorf("HistoryToShards(): want at least 4 shards, result %v", matches)
+	}
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || info.Size() > limit {
+			t.Errorf("HistoryToShards(): %s must not exceed %d bytes, %v, %v", match, limit, info, err)
+		}
+	}
+	got, err := ReadHistory(dir)
+	if err != nil || !reflect.DeepEqual(got, history) {
+		t.Errorf("ReadHistory(): want %v, result %v, %v", history, got, err)
+	}
+}
+
+func TestService_HistoryToShards_single(t *testing.T) {
+	service, history := historyTestPayments(t, 3)
+	dir := t.TempDir()
+	err := service.HistoryToFiles(history, dir, len(history))
+	if err != nil {
+		t.Fatalf("HistoryToFiles(): can't write history, %v", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "payments*.dump"))
+	if len(matches) != 1 || filepath.Base(matches[0]) != "payments1.dump" {
+		t.Errorf("HistoryToFiles(): want only payments1.dump, result %v", matches)
+	}
+}
+
+func TestService_HistoryToShards_keepsExport(t *testing.T) {
+	service, history := historyTestPayments(t, 5)
+	dir := t.TempDir()
+	err := service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+	err = service.HistoryToShards(history, dir, HistoryOptions{Records: 2})
+	if err != nil {
+		t.Fatalf("HistoryToShards(): can't write history, %v", err)
+	}
+	// новая история короче: шарды payments2 и payments3 прежней истории удаляются
+	err = service.HistoryToShards(history, dir, HistoryOptions{Compression: CompressionNone})
+	if err != nil {
+		t.Fatalf("HistoryToShards(): can't write history, %v", err)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "payments*"))
+	want := []string{filepath.Join(dir, paymentsDump), filepath.Join(dir, "payments1.dump")}
+	if !reflect.DeepEqual(matches, want) {
+		t.Errorf("HistoryToShards(): want files %v, result %v", want, matches)
+	}
+	got, err := ReadHistory(dir)
+	if err != nil || !reflect.DeepEqual(got, history) {
+		t.Errorf("ReadHistory(): want %v, result %v, %v", history, got, err)
+	}
+	err = (&Service{}).Import(dir)
+	if err != nil {
+		t.Errorf("Import(): export must stay intact, %v", err)
+	}
+}
+
+func TestReadHistory_mismatch(t *testing.T) {
+	service, history := historyTestPayments(t, 4)
+	dir := t.TempDir()
+	err := service.HistoryToShards(history, dir, HistoryOptions{Records: 2})
+	if err != nil {
+		t.Fatalf("HistoryToShards(): can't write history, %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "payments2.dump"), []byte(formatDumpHeader(entityPayments)), 0666)
+	if err != nil {
+		t.Fatalf("WriteFile(): can't truncate shard, %v", err)
+	}
+
+	_, err = ReadHistory(dir)
+	if !errors.Is(err, ErrHistoryMismatch) {
+		t.Errorf("ReadHistory(): must return ErrHistoryMismatch, returned %v", err)
+	}
+}
+
+func TestService_ImportHistory(t *testing.T) {
+	service, history := historyTestPayments(t, 3)
+	dir := t.TempDir()
+	err := service.HistoryToShards(history, dir, HistoryOptions{Records: 1})
+	if err != nil {
+		t.Fatalf("HistoryToShards(): can't write history, %v", err)
+	}
+
+	imported := &Service{}
+	err = imported.ImportHistory(dir)
+	if err != nil {
+		t.Fatalf("ImportHistory(): can't import, %v", err)
+	}
+	for _, payment := range history {
+		got, err := imported.FindPaymentByID(payment.ID)
+		if err != nil || !reflect.DeepEqual(*got, payment) {
+			t.Errorf("ImportHistory(): want %v, result %v, %v", payment, got, err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/types"
+	"time"
+)
+
+var ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+var ErrIdempotencyKeyMismatch = errors.New("idempotency key already used for another request")
+
+// Операции, результат которых сохраняется по ключу идемпотентности.
+const (
+	keyOpDeposit         = "DEPOSIT"
+	keyOpPay             = "PAY"
+	keyOpRepeat          = "REPEAT"
+	keyOpPayFromFavorite = "PAY_FAVORITE"
+	keyOpTransfer        = "TRANSFER"
+)
+
+// defaultIdempotencyRetention - сколько по умолчанию хранится результат операции с ключом идемпотентности.
+const defaultIdempotencyRetention = 24 * time.Hour
+
+// SetIdempotencyRetention задаёт, сколько хранится результат операции с ключом идемпотентности.
+// После этого срока тот же ключ снова выполняет операцию.
+func (s *Service) SetIdempotencyRetention(retention time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.idempotencyRetention = retention
+}
+
+func (s *Service) retention() time.Duration {
+	if s.idempotencyRetention <= 0 {
+		return defaultIdempotencyRetention
+	}
+	return s.idempotencyRetention
+}
+
+// checkIdempotencyKey ищет операцию, уже выполненную с ключом key.
+// Если она есть и срок хранения не истёк, возвращает её запись и replay == true.
+// Иначе возвращает новую запись, которую нужно сохранить вместе с операцией.
+// Для пустого key возвращает nil - операция выполняется без ключа.
+func (s *Service) checkIdempotencyKey(key string, operation string, request string) (*types.IdempotencyKey, bool, error) {
+	if key == "" {
+		return nil, false, nil
+	}
+
+	err := s.purgeIdempotencyKeys()
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := s.currentTime()
+	record, err := s.repository().IdempotencyKey(key)
+	if err != nil && err != ErrIdempotencyKeyNotFound {
+		return nil, false, err
+	}
+	if err == nil && now.Sub(record.CreatedAt) < s.retention() {
+		if record.Operation != operation || record.Request != request {
+			return nil, false, ErrIdempotencyKeyMismatch
+		}
+		return record, true, nil
+	}
+
+	return &types.IdempotencyKey{
+		Key:       key,
+		Operation: operation,
+		Request:   request,
+		CreatedAt: now,
+	}, false, nil
+}
+
+// idempotencyKeys привязывает key к платежу paymentID и возвращает его для записи в журнал.
+// Для nil возвращает nil.
+func idempotencyKeys(key *types.IdempotencyKey, paymentID string) []*types.IdempotencyKey {
+	if key == nil {
+		return nil
+	}
+	key.PaymentID = paymentID
+	return []*types.IdempotencyKey{key}
+}
+
+// liveIdempotencyKeys возвращает ключи, срок хранения которых ещё не истёк.
+func (s *Service) liveIdempotencyKeys(keys []*types.IdempotencyKey) []*types.IdempotencyKey {
+	now := s.currentTime()
+	live := []*types.IdempotencyKey{}
+	for _, key := range keys {
+		if now.Sub(key.CreatedAt) < s.retention() {
+			live = append(live, key)
+		}
+	}
+	return live
+}
+
+// purgeIdempotencyKeys удаляет из хранилища ключи, срок хранения которых истёк. Чтобы не перебирать ключи
+// при каждой операции, удаление выполняется не чаще раза за срок хранения: истёкший ключ хранится
+// не дольше двух сроков, но уже не влияет на операции (см. checkIdempotencyKey).
+func (s *Service) purgeIdempotencyKeys() error {
+	now := s.currentTime()
+	if now.Sub(s.keysPurgedAt) < s.retention() {
+		return nil
+	}
+
+	keys, err := s.repository().IdempotencyKeys()
+	if err != nil {
+		return err
+	}
+	deleted := []deletedRecord{}
+	for _, key := range keys {
+		if now.Sub(key.CreatedAt) >= s.retention() {
+			deleted = append(deleted, deletedRecord{kind: kindIdempotencyKey, id: key.Key})
+		}
+	}
+	if len(deleted) > 0 {
+		err = s.apply(journalEntry{op: opPurgeKeys, deleted: deleted})
+		if err != nil {
+			return err
+		}
+	}
+	s.keysPurgedAt = now
+	return nil
+}
+
+// replayPayment возвращает платёж, созданный операцией с ключом идемпотентности.
+func (s *Service) replayPayment(record *types.IdempotencyKey) (*types.Payment, error) {
+	return s.findPaymentByID(record.PaymentID)
+}
+
+// DepositWithKey работает как Deposit (в том числе в валюте types.DefaultCurrency), но повторный вызов с тем же key в течение срока хранения
+// не зачисляет средства повторно.
+func (s *Service) DepositWithKey(key string, accountID int64, amount types.Money) error {
+	if amount <= 0 {
+		return ErrAmountMustBePositive
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, replay, err := s.checkIdempotencyKey(key, keyOpDeposit, fmt.Sprintf("%d;%d", accountID, amount))
+	if err != nil || replay {
+		return err
+	}
+	err = s.checkCurrency(accountID, types.DefaultCurrency)
+	if err != nil {
+		return err
+	}
+
+	return s.deposit(accountID, amount, record)
+}
+
+// PayWithKey работает как Pay (в том числе в валюте types.DefaultCurrency), но повторный вызов с тем же key в течение срока хранения
+// возвращает исходный платёж вместо нового списания.
+func (s *Service) PayWithKey(key string, accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, replay, err := s.checkIdempotencyKey(key, keyOpPay, fmt.Sprintf("%d;%d;%s", accountID, amount, category))
+	if err != nil {
+		return nil, err
+	}
+	if replay {
+		return s.replayPayment(record)
+	}
+	err = s.checkCurrency(accountID, types.DefaultCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.pay(accountID, amount, category, record)
+}
+
+// RepeatWithKey работает как Repeat, но повторный вызов с тем же key в течение срока хранения
+// возвращает платёж, созданный первым вызовом.
+func (s *Service) RepeatWithKey(key string, paymentID string) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, replay, err := s.checkIdempotencyKey(key, keyOpRepeat, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if replay {
+		return s.replayPayment(record)
+	}
+
+	return s.repeat(paymentID, record)
+}
+
+// PayFromFavoriteWithKey работает как PayFromFavorite, но повторный вызов с тем же key в течение срока хранения
+// возвращает платёж, созданный первым вызовом.
+func (s *Service) PayFromFavoriteWithKey(key string, favoriteID string) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, replay, err := s.checkIdempotencyKey(key, keyOpPayFromFavorite, favoriteID)
+	if err != nil {
+		return nil, err
+	}
+	if replay {
+		return s.replayPayment(record)
+	}
+
+	return s.payFromFavorite(favoriteID, record)
+}
+
+// TransferWithKey работает как Transfer, но повторный вызов с тем же key в течение срока хранения
+// возвращает платёж отправителя, созданный первым вызовом.
+func (s *Service) TransferWithKey(key string, fromID int64, toID int64, amount types.Money) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, replay, err := s.checkIdempotencyKey(key, keyOpTransfer, fmt.Sprintf("%d;%d;%d", fromID, toID, amount))
+	if err != nil {
+		return nil, err
+	}
+	if replay {
+		return s.replayPayment(record)
+	}
+
+	return s.transfer(fromID, toID, amount, record)
+}
@@ -0,0 +1,276 @@
+package wallet
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestService_PayWithKey_retry(t *testing.T) {
+	service := &Service{}
+	account, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	balance := account.Balance
+
+	first, err := service.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil {
+		t.Fatalf("PayWithKey(): can't pay, %v", err)
+	}
+	second, err := service.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil {
+		t.Fatalf("PayWithKey(): can't retry, %v", err)
+	}
+	if first.ID != second.ID {
+		t.Errorf("PayWithKey(): retry must return the same payment, first %v, second %v", first, second)
+	}
+	account = currentAccount(t, service, account.ID)
+	if account.Balance != balance-100_00 {
+		t.Errorf("PayWithKey(): retry must not debit twice, balance %v", account.Balance)
+	}
+
+	_, err = service.PayWithKey("order-1", account.ID, 200_00, "auto")
+	if err != ErrIdempotencyKeyMismatch {
+		t.Errorf("PayWithKey(): must return ErrIdempotencyKeyMismatch, returned %v", err)
+	}
+	_, err = service.RepeatWithKey("order-1", first.ID)
+	if err != ErrIdempotencyKeyMismatch {
+		t.Errorf("RepeatWithKey(): must return ErrIdempotencyKeyMismatch, returned %v", err)
+	}
+}
+
+func TestService_PayWithKey_expired(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	service := &Service{now: func() time.Time { return now }}
+	service.SetIdempotencyRetention(time.Hour)
+	account, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+
+	first, err := service.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil {
+		t.Fatalf("PayWithKey(): can't pay, %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	second, err := service.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil {
+		t.Fatalf("PayWithKey(): can't pay after retention, %v", err)
+	}
+	if first.ID == second.ID {
+		t.Errorf("PayWithKey(): must create new payment after retention, returned %v", second)
+	}
+}
+
+func TestService_DepositWithKey(t *testing.T) {
+	service := &Service{}
+	account, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	balance := account.Balance
+
+	for i := 0; i < 3; i++ {
+		err = service.DepositWithKey("topup-1", account.ID, 50_00)
+		if err != nil {
+			t.Fatalf("DepositWithKey(): can't deposit, %v", err)
+		}
+	}
+	account = currentAccount(t, service, account.ID)
+	if account.Balance != balance+50_00 {
+		t.Errorf("DepositWithKey(): retry must not credit twice, balance %v", account.Balance)
+	}
+
+	err = service.Audit()
+	if err != nil {
+		t.Errorf("Audit(): ledger must match balances, %v", err)
+	}
+}
+
+func TestService_PayFromFavoriteWithKey(t *testing.T) {
+	service := &Service{}
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+	balance := account.Balance
+
+	first, err := service.PayFromFavoriteWithKey("fav-1", favorite.ID)
+	if err != nil {
+		t.Fatalf("PayFromFavoriteWithKey(): can't pay, %v", err)
+	}
+	second, err := service.PayFromFavoriteWithKey("fav-1", favorite.ID)
+	if err != nil || first.ID != second.ID {
+		t.Errorf("PayFromFavoriteWithKey(): retry must return %v, returned %v, %v", first, second, err)
+	}
+	account = currentAccount(t, service, account.ID)
+	if account.Balance != balance-favorite.Amount {
+		t.Errorf("PayFromFavoriteWithKey(): retry must not debit twice, balance %v", account.Balance)
+	}
+}
+
+func TestService_TransferWithKey(t *testing.T) {
+	service := &Service{}
+	from, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	to, _, err := service.addAccount(defaultExampleTestAccount2)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	fromBalance, toBalance := from.Balance, to.Balance
+
+	first, err := service.TransferWithKey("transfer-1", from.ID, to.ID, 500_00)
+	if err != nil {
+		t.Fatalf("TransferWithKey(): can't transfer, %v", err)
+	}
+	second, err := service.TransferWithKey("transfer-1", from.ID, to.ID, 500_00)
+	if err != nil || first.ID != second.ID {
+		t.Errorf("TransferWithKey(): retry must return %v, returned %v, %v", first, second, err)
+	}
+	from, to = currentAccount(t, service, from.ID), currentAccount(t, service, to.ID)
+	if from.Balance != fromBalance-500_00 || to.Balance != toBalance+500_00 {
+		t.Errorf("TransferWithKey(): retry must not transfer twice, from = %v, to = %v", from, to)
+	}
+
+	_, err = service.TransferWithKey("transfer-1", to.ID, from.ID, 500_00)
+	if err != ErrIdempotencyKeyMismatch {
+		t.Errorf("TransferWithKey(): must return ErrIdempotencyKeyMismatch, returned %v", err)
+	}
+}
+
+func TestService_PayWithKey_purgesExpiredKeys(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "wallet.journal")
+	service := &Service{now: func() time.Time { return now }}
+	service.SetIdempotencyRetention(time.Hour)
+	err := service.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't open journal, %v", err)
+	}
+	account, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+
+	_, err = service.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil {
+		t.Fatalf("PayWithKey(): can't pay, %v", err)
+	}
+	err = service.DepositWithKey("topup-1", account.ID, 100_00)
+	if err != nil {
+		t.Fatalf("DepositWithKey(): can't deposit, %v", err)
+	}
+	now = now.Add(30 * time.Minute)
+	late, err := service.PayWithKey("order-late", account.ID, 100_00, "auto")
+	if err != nil {
+		t.Fatalf("PayWithKey(): can't pay, %v", err)
+	}
+
+	now = now.Add(30 * time.Minute)
+	_, err = service.PayWithKey("order-2", account.ID, 100_00, "auto")
+	if err != nil {
+		t.Fatalf("PayWithKey(): can't pay, %v", err)
+	}
+	keys, err := service.repository().IdempotencyKeys()
+	if err != nil {
+		t.Fatalf("IdempotencyKeys(): can't get keys, %v", err)
+	}
+	if len(keys) != 2 || keys[0].Key != "order-late" || keys[1].Key != "order-2" {
+		t.Errorf("PayWithKey(): expired keys must be purged, keys %v", keys)
+	}
+	retried, err := service.PayWithKey("order-late", account.ID, 100_00, "auto")
+	if err != nil || retried.ID != late.ID {
+		t.Errorf("PayWithKey(): live key must be kept, want %v, returned %v, %v", late, retried, err)
+	}
+	err = service.CloseJournal()
+	if err != nil {
+		t.Fatalf("CloseJournal(): can't close journal, %v", err)
+	}
+
+	restored := &Service{}
+	err = restored.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't replay journal, %v", err)
+	}
+	defer restored.CloseJournal()
+	keys, err = restored.repository().IdempotencyKeys()
+	if err != nil {
+		t.Fatalf("IdempotencyKeys(): can't get keys, %v", err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("OpenJournal(): purged keys must stay deleted after replay, keys %v", keys)
+	}
+}
+
+func TestService_Import_idempotencyKeys(t *testing.T) {
+	dir := t.TempDir()
+	service := &Service{}
+	account, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	payment, err := service.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil {
+		t.Fatalf("PayWithKey(): can't pay, %v", err)
+	}
+	err = service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+
+	imported := &Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	retried, err := imported.PayWithKey("order-1", account.ID, 100_00, "auto")
+	if err != nil || retried.ID != payment.ID {
+		t.Errorf("PayWithKey(): retry after Import must return %v, returned %v, %v", payment, retried, err)
+	}
+}
+
+func TestService_OpenJournal_idempotencyKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallet.journal")
+	service := &Service{}
+	err := service.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't open journal, %v", err)
+	}
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	payment, err := service.RepeatWithKey("repeat-1", payments[0].ID)
+	if err != nil {
+		t.Fatalf("RepeatWithKey(): can't repeat, %v", err)
+	}
+	account = currentAccount(t, service, account.ID)
+	err = service.CloseJournal()
+	if err != nil {
+		t.Fatalf("CloseJournal(): can't close journal, %v", err)
+	}
+
+	restored := &Service{}
+	err = restored.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't replay journal, %v", err)
+	}
+	defer restored.CloseJournal()
+
+	retried, err := restored.RepeatWithKey("repeat-1", payments[0].ID)
+	if err != nil || retried.ID != payment.ID {
+		t.Errorf("RepeatWithKey(): retry after replay must return %v, returned %v, %v", payment, retried, err)
+	}
+	restoredAccount, err := restored.FindAccountByID(account.ID)
+	if err != nil || restoredAccount.Balance != account.Balance {
+		t.Errorf("RepeatWithKey(): retry after replay must not debit, want %v, result %v, %v", account, restoredAccount, err)
+	}
+}
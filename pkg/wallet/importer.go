@@ -0,0 +1,322 @@
+package wallet
+
+import (
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/types"
+)
+
+// importChunkRecords - наибольшее число записей в одной записи журнала, которую добавляет импорт.
+const importChunkRecords = 1_000
+
+// importOrder - порядок, в котором импортируются виды записей. Проводки идут первыми: к моменту импорта счёта
+// его остаток уже подтверждён проводками выгрузки, и проводка начального остатка добавляется только на разницу.
+var importOrder = []string{entityPostings, entityAccounts, entityPayments, entityFavorites, entityIdempotency, entitySchedules}
+
+// importer применяет записи импорта по мере чтения частями по importChunkRecords записей, поэтому импорт
+// не держит в памяти всю выгрузку. Записи, которые уже есть в хранилище или в текущей части, пропускаются:
+// записи прежних частей к этому времени сохранены и находятся в хранилище.
+type importer struct {
+	s     *Service
+	chunk journalEntry
+	size  int
+
+	// postings - ID проводок хранилища: в Repository нет поиска проводки по ID.
+	// balances - остатки по этим проводкам (см. ledgerBalances) для проводок начального остатка.
+	postings map[string]bool
+	balances map[string]types.Money
+
+	accounts  map[int64]bool
+	payments  map[string]bool
+	favorites map[string]bool
+	keys      map[string]bool
+	schedules map[string]bool
+
+	// check - проверочный проход: readDump только разбирает записи, ничего не сохраняя
+	check bool
+	// err - ошибка сохранения, которая прервала readDump
+	err error
+}
+
+func (s *Service) newImporter() (*importer, error) {
+	postings, err := s.repository().Postings()
+	if err != nil {
+		return nil, err
+	}
+	im := &importer{s: s, postings: make(map[string]bool, len(postings)), balances: ledgerBalances(postings)}
+	for _, posting := range postings {
+		im.postings[posting.ID] = true
+	}
+	im.reset()
+	return im, nil
+}
+
+// reset начинает новую часть.
+func (im *importer) reset() {
+	im.chunk = journalEntry{op: opImport}
+	im.size = 0
+	im.accounts = make(map[int64]bool)
+	im.payments = make(map[string]bool)
+	im.favorites = make(map[string]bool)
+	im.keys = make(map[string]bool)
+	im.schedules = make(map[string]bool)
+}
+
+func (im *importer) account(account *types.Account) error {
+	_, err := im.s.findAccountByID(account.ID)
+	if err != nil && err != ErrAccountNotFound {
+		return err
+	}
+	if err != ErrAccountNotFound || im.accounts[account.ID] {
+		return nil
+	}
+	im.accounts[account.ID] = true
+	im.chunk.accounts = append(im.chunk.accounts, account)
+	return im.next()
+}
+
+func (im *importer) payment(payment *types.Payment) error {
+	_, err := im.s.findPaymentByID(payment.ID)
+	if err != nil && err != ErrPaymentNotFound {
+		return err
+	}
+	if err != ErrPaymentNotFound || im.payments[payment.ID] {
+		return nil
+	}
+	im.payments[payment.ID] = true
+	im.chunk.payments = append(im.chunk.payments, payment)
+	return im.next()
+}
+
+// favorite добавляет элемент избранного. Избранное можно изменять, поэтому существующий элемент заменяется,
+// если в выгрузке он изменён позже.
+func (im *importer) favorite(favorite *types.Favorite) error {
+	existing, err := im.s.findFavoriteByID(favorite.ID)
+	if err != nil && err != ErrFavoriteNotFound {
+		return err
+	}
+	if im.favorites[favorite.ID] {
+		return nil
+	}
+	if err == nil && !favorite.UpdatedAt.After(existing.UpdatedAt) {
+		return nil
+	}
+	im.favorites[favorite.ID] = true
+	im.chunk.favorites = append(im.chunk.favorites, favorite)
+	return im.next()
+}
+
+func (im *importer) posting(posting *types.Posting) error {
+	if im.postings[posting.ID] {
+		return nil
+	}
+	im.postings[posting.ID] = true
+	im.chunk.postings = append(im.chunk.postings, posting)
+	return im.next()
+}
+
+// key добавляет ключ идемпотентности, срок хранения которого ещё не истёк.
+func (im *importer) key(key *types.IdempotencyKey) error {
+	if im.s.currentTime().Sub(key.CreatedAt) >= im.s.retention() {
+		return nil
+	}
+	_, err := im.s.repository().IdempotencyKey(key.Key)
+	if err != nil && err != ErrIdempotencyKeyNotFound {
+		return err
+	}
+	if err != ErrIdempotencyKeyNotFound || im.keys[key.Key] {
+		return nil
+	}
+	im.keys[key.Key] = true
+	im.chunk.keys = append(im.chunk.keys, key)
+	return im.next()
+}
+
+func (im *importer) schedule(schedule *types.Schedule) error {
+	_, err := im.s.repository().ScheduleByID(schedule.ID)
+	if err != nil && err != ErrScheduleNotFound {
+		return err
+	}
+	if err != ErrScheduleNotFound || im.schedules[schedule.ID] {
+		return nil
+	}
+	im.schedules[schedule.ID] = true
+	im.chunk.schedules = append(im.chunk.schedules, schedule)
+	return im.next()
+}
+
+// next применяет часть, если она заполнена.
+func (im *importer) next() error {
+	im.size++
+	if im.size < importChunkRecords {
+		return nil
+	}
+	return im.flush()
+}
+
+// flush применяет текущую часть. Для новых счетов, остаток которых не подтверждён проводками,
+// добавляются проводки начального остатка. Вместе с ними часть может превысить importChunkRecords,
+// поэтому в журнал она записывается через journalEntry.split.
+func (im *importer) flush() error {
+	if im.size == 0 {
+		return nil
+	}
+	for _, posting := range im.chunk.postings {
+		im.addBalance(posting)
+	}
+	opening := im.s.openingPostings(im.chunk.accounts, im.balances)
+	for _, posting := range opening {
+		im.addBalance(posting)
+	}
+	im.chunk.postings = append(im.chunk.postings, opening...)
+
+	for _, chunk := range im.chunk.split(importChunkRecords) {
+		err := im.s.apply(chunk)
+		if err != nil {
+			return err
+		}
+	}
+	im.reset()
+	return nil
+}
+
+func (im *importer) addBalance(posting *types.Posting) {
+	im.balances[posting.From] -= posting.Amount
+	im.balances[posting.To] += posting.Amount
+}
+
+// importEntry импортирует записи entry (см. importer).
+func (s *Service) importEntry(entry journalEntry) error {
+	im, err := s.newImporter()
+	if err != nil {
+		return err
+	}
+	for _, posting := range entry.postings {
+		if err := im.posting(posting); err != nil {
+			return err
+		}
+	}
+	for _, account := range entry.accounts {
+		if err := im.account(account); err != nil {
+			return err
+		}
+	}
+	for _, payment := range entry.payments {
+		if err := im.payment(payment); err != nil {
+			return err
+		}
+	}
+	for _, favorite := range entry.favorites {
+		if err := im.favorite(favorite); err != nil {
+			return err
+		}
+	}
+	for _, key := range entry.keys {
+		if err := im.key(key); err != nil {
+			return err
+		}
+	}
+	for _, schedule := range entry.schedules {
+		if err := im.schedule(schedule); err != nil {
+			return err
+		}
+	}
+	return im.flush()
+}
+
+// readDump импортирует записи файла выгрузки path с именем name в любом формате (см. readDump).
+// Ошибки разбора записей пропускаются в report, ошибки сохранения всегда прерывают импорт.
+func (im *importer) readDump(path string, name string, report *ImportReport) error {
+	var add func(reader dumpReader, line string) error
+	switch dumpEntity(name) {
+	case entityAccounts:
+		add = func(reader dumpReader, line string) error {
+			account, err := reader.account(line)
+			if err != nil {
+				return err
+			}
+			return im.add(func() error { return im.account(account) })
+		}
+	case entityPayments:
+		add = func(reader dumpReader, line string) error {
+			payment, err := reader.payment(line)
+			if err != nil {
+				return err
+			}
+			return im.add(func() error { return im.payment(payment) })
+		}
+	case entityFavorites:
+		add = func(reader dumpReader, line string) error {
+			favorite, err := reader.favorite(line)
+			if err != nil {
+				return err
+			}
+			return im.add(func() error { return im.favorite(favorite) })
+		}
+	case entityPostings:
+		add = func(reader dumpReader, line string) error {
+			posting, err := reader.posting(line)
+			if err != nil {
+				return err
+			}
+			return im.add(func() error { return im.posting(posting) })
+		}
+	case entityIdempotency:
+		add = func(reader dumpReader, line string) error {
+			key, err := reader.idempotencyKey(line)
+			if err != nil {
+				return err
+			}
+			return im.add(func() error { return im.key(key) })
+		}
+	case entitySchedules:
+		add = func(reader dumpReader, line string) error {
+			schedule, err := reader.schedule(line)
+			if err != nil {
+				return err
+			}
+			return im.add(func() error { return im.schedule(schedule) })
+		}
+	default:
+		return fmt.Errorf("%w: unknown dump %s", ErrManifestMismatch, name)
+	}
+
+	err := readDump(path, dumpEntity(name), report, add)
+	if im.err != nil {
+		err, im.err = im.err, nil
+	}
+	return err
+}
+
+// add сохраняет разобранную запись функцией save. Ошибка сохранения запоминается и останавливает чтение:
+// иначе readDump принял бы её за ошибку разбора записи. При проверке (check) записи только разбираются.
+func (im *importer) add(save func() error) error {
+	if im.check {
+		return nil
+	}
+	err := save()
+	if err != nil {
+		im.err = err
+		return errStopReading
+	}
+	return nil
+}
+
+// checkReport возвращает отчёт для проверочного прохода импорта с отчётом report: записи с ошибками
+// пропускаются так же, но попадут в report только при втором проходе.
+func checkReport(report *ImportReport) *ImportReport {
+	if report == nil {
+		return nil
+	}
+	return &ImportReport{}
+}
+
+// importRank возвращает место файла выгрузки name в importOrder.
+func importRank(name string) int {
+	entity := dumpEntity(name)
+	for i, ordered := range importOrder {
+		if entity == ordered {
+			return i
+		}
+	}
+	return len(importOrder)
+}
@@ -0,0 +1,410 @@
+package wallet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/types"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ErrJournalCorrupted = errors.New("journal corrupted")
+var ErrJournalOpened = errors.New("journal already opened")
+
+// Операции, которые записываются в журнал.
+const (
+	opRegisterAccount = "REGISTER"
+	opDeposit         = "DEPOSIT"
+	opPay             = "PAY"
+	opConfirm         = "CONFIRM"
+	opReject          = "REJECT"
+	opExpire          = "EXPIRE"
+	opTransfer        = "TRANSFER"
+	opFavoritePayment = "FAVORITE"
+	opImport          = "IMPORT"
+	opSchedule        = "SCHEDULE"
+	opUnschedule      = "UNSCHEDULE"
+	opScheduleRun     = "SCHEDULE_RUN"
+	opUpdateFavorite  = "FAVORITE_UPDATE"
+	opRemoveFavorite  = "FAVORITE_REMOVE"
+	opBlockAccount    = "BLOCK"
+	opUnblockAccount  = "UNBLOCK"
+	opCloseAccount    = "CLOSE"
+	opPurgeKeys       = "KEY_PURGE"
+	// opSave - запись, сохранённая через Repository напрямую, а не операцией Service
+	opSave = "SAVE"
+)
+
+// Виды записей, которые можно удалить.
+const (
+	kindFavorite       = "favorite"
+	kindSchedule       = "schedule"
+	kindIdempotencyKey = "key"
+)
+
+// journalEntry - одна запись журнала: операция, состояние изменённых ею записей после операции
+// и удалённые ею записи. При восстановлении записи просто сохраняются в хранилище (или удаляются из него),
+// поэтому повторное применение безопасно.
+type journalEntry struct {
+	op        string
+	accounts  []*types.Account
+	payments  []*types.Payment
+	favorites []*types.Favorite
+	postings  []*types.Posting
+	keys      []*types.IdempotencyKey
+	schedules []*types.Schedule
+	deleted   []deletedRecord
+}
+
+// deletedRecord - удалённая запись вида kind с идентификатором id.
+type deletedRecord struct {
+	kind string
+	id   string
+}
+
+// journal - файл, в который записи только дописываются.
+// Первая строка - заголовок с версией формата записей (см. dumpVersion), журнал без заголовка - версии 1.
+// Каждая следующая строка имеет вид "<crc32> <op>\t<kind>:<quoted record>...", после записи делается fsync.
+type journal struct {
+	file *os.File
+	// err - ошибка, после которой журнал нельзя дописывать: в нём могла остаться оборванная запись
+	err error
+}
+
+func (e journalEntry) encode() string {
+	var payload strings.Builder
+	payload.WriteString(e.op)
+	for _, account := range e.accounts {
+		writeJournalRecord(&payload, "account", formatAccount(account))
+	}
+	for _, payment := range e.payments {
+		writeJournalRecord(&payload, "payment", formatPayment(payment))
+	}
+	for _, favorite := range e.favorites {
+		writeJournalRecord(&payload, "favorite", formatFavorite(favorite))
+	}
+	for _, posting := range e.postings {
+		writeJournalRecord(&payload, "posting", formatPosting(posting))
+	}
+	for _, key := range e.keys {
+		writeJournalRecord(&payload, "key", formatIdempotencyKey(key))
+	}
+	for _, schedule := range e.schedules {
+		writeJournalRecord(&payload, "schedule", formatSchedule(schedule))
+	}
+	for _, record := range e.deleted {
+		writeJournalRecord(&payload, "delete", record.kind+";"+record.id)
+	}
+
+	line := payload.String()
+	return fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE([]byte(line)), line)
+}
+
+// writeJournalRecord дописывает в payload запись record вида kind как "\t<kind>:<quoted record>".
+func writeJournalRecord(payload *strings.Builder, kind string, record string) {
+	payload.WriteByte('\t')
+	payload.WriteString(kind)
+	payload.WriteByte(':')
+	payload.WriteString(strconv.Quote(strings.TrimSuffix(record, "\n")))
+}
+
+// split делит e на записи журнала с той же операцией не больше чем по limit записей в каждой.
+// Проводки идут первыми: если импорт прерван между частями, при повторном импорте уже сохранённые
+// проводки начального остатка подтверждают остатки счетов и не добавляются ещё раз.
+func (e journalEntry) split(limit int) []journalEntry {
+	chunks := []journalEntry{}
+	chunk := journalEntry{op: e.op}
+	size := 0
+	next := func() {
+		size++
+		if size == limit {
+			chunks = append(chunks, chunk)
+			chunk = journalEntry{op: e.op}
+			size = 0
+		}
+	}
+	for _, posting := range e.postings {
+		chunk.postings = append(chunk.postings, posting)
+		next()
+	}
+	for _, account := range e.accounts {
+		chunk.accounts = append(chunk.accounts, account)
+		next()
+	}
+	for _, payment := range e.payments {
+		chunk.payments = append(chunk.payments, payment)
+		next()
+	}
+	for _, favorite := range e.favorites {
+		chunk.favorites = append(chunk.favorites, favorite)
+		next()
+	}
+	for _, key := range e.keys {
+		chunk.keys = append(chunk.keys, key)
+		next()
+	}
+	for _, schedule := range e.schedules {
+		chunk.schedules = append(chunk.schedules, schedule)
+		next()
+	}
+	for _, record := range e.deleted {
+		chunk.deleted = append(chunk.deleted, record)
+		next()
+	}
+	if size > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// decodeJournalEntry разбирает строку журнала, записи в которой разбираются через reader.
+func decodeJournalEntry(line string, reader dumpReader) (journalEntry, error) {
+	entry := journalEntry{}
+	space := strings.IndexByte(line, ' ')
+	if space < 0 {
+		return entry, ErrJournalCorrupted
+	}
+	checksum, err := strconv.ParseUint(line[:space], 16, 32)
+	if err != nil {
+		return entry, ErrJournalCorrupted
+	}
+	payload := line[space+1:]
+	if uint32(checksum) != crc32.ChecksumIEEE([]byte(payload)) {
+		return entry, ErrJournalCorrupted
+	}
+
+	fields := strings.Split(payload, "\t")
+	entry.op = fields[0]
+	for _, field := range fields[1:] {
+		colon := strings.IndexByte(field, ':')
+		if colon < 0 {
+			return entry, ErrJournalCorrupted
+		}
+		record, err := strconv.Unquote(field[colon+1:])
+		if err != nil {
+			return entry, ErrJournalCorrupted
+		}
+
+		switch field[:colon] {
+		case "account":
+			account, err := reader.account(record)
+			if err != nil {
+				return entry, err
+			}
+			entry.accounts = append(entry.accounts, account)
+		case "payment":
+			payment, err := reader.payment(record)
+			if err != nil {
+				return entry, err
+			}
+			entry.payments = append(entry.payments, payment)
+		case "favorite":
+			favorite, err := reader.favorite(record)
+			if err != nil {
+				return entry, err
+			}
+			entry.favorites = append(entry.favorites, favorite)
+		case "posting":
+			posting, err := reader.posting(record)
+			if err != nil {
+				return entry, err
+			}
+			entry.postings = append(entry.postings, posting)
+		case "key":
+			key, err := reader.idempotencyKey(record)
+			if err != nil {
+				return entry, err
+			}
+			entry.keys = append(entry.keys, key)
+		case "schedule":
+			schedule, err := reader.schedule(record)
+			if err != nil {
+				return entry, err
+			}
+			entry.schedules = append(entry.schedules, schedule)
+		case "delete":
+			semicolon := strings.IndexByte(record, ';')
+			if semicolon < 0 {
+				return entry, ErrJournalCorrupted
+			}
+			entry.deleted = append(entry.deleted, deletedRecord{kind: record[:semicolon], id: record[semicolon+1:]})
+		default:
+			return entry, ErrJournalCorrupted
+		}
+	}
+
+	return entry, nil
+}
+
+// openJournal открывает (или создаёт) журнал и читает все записи из него.
+// Недописанная последняя строка (например, после падения процесса во время записи) отбрасывается.
+// Журнал старой версии (и новый пустой) перезаписывается в текущей, чтобы дописывать в него записи.
+func openJournal(path string) (*journal, []journalEntry, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries, version, valid, err := readJournal(file)
+	if err == nil && version != dumpVersion {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+		err = rewriteJournal(path, entries)
+		if err != nil {
+			return nil, nil, err
+		}
+		file, err = os.OpenFile(path, os.O_RDWR, 0666)
+		if err != nil {
+			return nil, nil, err
+		}
+		valid, err = file.Seek(0, io.SeekEnd)
+	}
+	if err == nil {
+		err = file.Truncate(valid)
+	}
+	if err == nil {
+		_, err = file.Seek(valid, io.SeekStart)
+	}
+	if err != nil {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+		return nil, nil, err
+	}
+
+	return &journal{file: file}, entries, nil
+}
+
+// readJournal возвращает записи журнала, версию его формата и длину его корректной части в байтах.
+// Для пустого журнала возвращает версию 0.
+func readJournal(file *os.File) ([]journalEntry, int, int64, error) {
+	entries := []journalEntry{}
+	version := 0
+	records := dumpReader{}
+	valid := int64(0)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			// строка без '\n' - запись не была дописана до конца
+			return entries, version, valid, nil
+		}
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		if version == 0 {
+			version = 1
+			if strings.HasPrefix(line, "#") {
+				version, err = parseDumpHeader(strings.TrimSuffix(line, "\n"), entityJournal)
+				if err != nil {
+					return nil, 0, 0, fmt.Errorf("%w: %v", ErrJournalCorrupted, err)
+				}
+			}
+			records, err = dumpReaderFor(version)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			if strings.HasPrefix(line, "#") {
+				valid += int64(len(line))
+				continue
+			}
+		}
+
+		entry, err := decodeJournalEntry(strings.TrimSuffix(line, "\n"), records)
+		if err != nil {
+			if _, perr := reader.Peek(1); perr == io.EOF {
+				// повреждена только последняя запись - отбрасываем её
+				return entries, version, valid, nil
+			}
+			return nil, 0, 0, fmt.Errorf("%w: offset %d", ErrJournalCorrupted, valid)
+		}
+		entries = append(entries, entry)
+		valid += int64(len(line))
+	}
+}
+
+// rewriteJournal записывает entries в журнал path в текущей версии формата.
+// Журнал заменяется целиком через временный файл, поэтому при сбое остаётся прежний.
+func rewriteJournal(path string, entries []journalEntry) error {
+	tmp := path + ".tmp"
+	err := writeRecords(tmp, entityJournal, len(entries), func(i int) string {
+		return entries[i].encode()
+	})
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// append дописывает entry в конец журнала и возвращает смещение, с которого он записан,
+// чтобы запись можно было отменить через truncate. Если записать не удалось, журнал обрезается до этого смещения,
+// иначе следующая запись легла бы после оборванной строки.
+func (j *journal) append(entry journalEntry) (int64, error) {
+	if j.err != nil {
+		return 0, j.err
+	}
+	offset, err := j.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	_, err = j.file.WriteString(entry.encode())
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		if terr := j.truncate(offset); terr != nil {
+			log.Print(terr)
+		}
+		return 0, err
+	}
+	return offset, nil
+}
+
+// truncate отменяет записи журнала начиная со смещения offset.
+// Если обрезать журнал не удалось, дальнейшие записи в него возвращают ошибку.
+func (j *journal) truncate(offset int64) error {
+	err := j.file.Truncate(offset)
+	if err == nil {
+		_, err = j.file.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		j.err = fmt.Errorf("%w: can't truncate journal, %v", ErrJournalCorrupted, err)
+	}
+	return err
+}
+
+func (j *journal) reset() error {
+	err := j.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = j.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = j.file.WriteString(formatDumpHeader(entityJournal))
+	if err != nil {
+		return err
+	}
+	err = j.file.Sync()
+	if err != nil {
+		return err
+	}
+	// журнал переписан целиком, поэтому оборванной записи в нём больше нет
+	j.err = nil
+	return nil
+}
+
+func (j *journal) close() error {
+	return j.file.Close()
+}
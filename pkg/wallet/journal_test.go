@@ -0,0 +1,370 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestService_OpenJournal_replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallet.journal")
+
+	service := &Service{}
+	err := service.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't open journal, %v", err)
+	}
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+	err = service.Reject(payments[0].ID)
+	if err != nil {
+		t.Fatalf("Reject(): can't reject payment, %v", err)
+	}
+	account = currentAccount(t, service, account.ID)
+	payments[0] = currentPayment(t, service, payments[0].ID)
+	err = service.CloseJournal()
+	if err != nil {
+		t.Fatalf("CloseJournal(): can't close journal, %v", err)
+	}
+
+	restored := &Service{}
+	err = restored.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't replay journal, %v", err)
+	}
+	defer restored.CloseJournal()
+
+	gotAccount, err := restored.FindAccountByID(account.ID)
+	if err != nil || !reflect.DeepEqual(account, gotAccount) {
+		t.Errorf("OpenJournal(): want account %v, result %v, %v", account, gotAccount, err)
+	}
+	gotPayment, err := restored.FindPaymentByID(payments[0].ID)
+	if err != nil || !reflect.DeepEqual(payments[0], gotPayment) {
+		t.Errorf("OpenJournal(): want payment %v, result %v, %v", payments[0], gotPayment, err)
+	}
+	gotFavorite, err := restored.FindFavoriteByID(favorite.ID)
+	if err != nil || !reflect.DeepEqual(favorite, gotFavorite) {
+		t.Errorf("OpenJournal(): want favorite %v, result %v, %v", favorite, gotFavorite, err)
+	}
+}
+
+// failingRepository - хранилище, которое не сохраняет платежи, пока задана ошибка err.
+type failingRepository struct {
+	*MemoryRepository
+	err error
+}
+
+func (r *failingRepository) SavePayment(payment *types.Payment) error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.MemoryRepository.SavePayment(payment)
+}
+
+func TestService_apply_saveError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallet.journal")
+	repo := &failingRepository{MemoryRepository: NewMemoryRepository()}
+	service := NewService(repo)
+	err := service.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't open journal, %v", err)
+	}
+	account, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	before, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(): can't read journal, %v", err)
+	}
+
+	repo.err = errors.New("disk full")
+	_, err = service.Pay(account.ID, 1_00, "auto")
+	if err != repo.err {
+		t.Fatalf("Pay(): must return save error, returned %v", err)
+	}
+	after, err := ioutil.ReadFile(path)
+	if err != nil || string(after) != string(before) {
+		t.Errorf("Pay(): unsaved change must be removed from journal, result %q, %v", after, err)
+	}
+
+	repo.err = nil
+	payment, err := service.Pay(account.ID, 2_00, "auto")
+	if err != nil {
+		t.Fatalf("Pay(): can't pay, %v", err)
+	}
+	err = service.CloseJournal()
+	if err != nil {
+		t.Fatalf("CloseJournal(): can't close journal, %v", err)
+	}
+
+	restored := &Service{}
+	err = restored.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't replay journal, %v", err)
+	}
+	defer restored.CloseJournal()
+	payments, err := restored.ExportAccountHistory(account.ID)
+	if err != nil || len(payments) != 2 || payments[1].ID != payment.ID {
+		t.Errorf("OpenJournal(): want only saved payments, result %v, %v", payments, err)
+	}
+}
+
+func TestService_OpenJournal_tornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallet.journal")
+
+	service := &Service{}
+	err := service.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't open journal, %v", err)
+	}
+	account, err := service.RegisterAccount("+992900000001")
+	if err != nil {
+		t.Fatalf("RegisterAccount(): can't register account, %v", err)
+	}
+	_ = service.CloseJournal()
+
+	// имитируем падение процесса посреди записи
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("can't open journal file, %v", err)
+	}
+	_, _ = file.WriteString("1234abcd DEPOSIT\taccount:\"1;+9929")
+	_ = file.Close()
+
+	restored := &Service{}
+	err = restored.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): must skip torn tail, returned %v", err)
+	}
+	err = restored.Deposit(account.ID, 100)
+	if err != nil {
+		t.Fatalf("Deposit(): can't deposit, %v", err)
+	}
+	_ = restored.CloseJournal()
+
+	again := &Service{}
+	err = again.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't replay journal after recovery, %v", err)
+	}
+	defer again.CloseJournal()
+	got, err := again.FindAccountByID(account.ID)
+	if err != nil || got.Balance != 100 {
+		t.Errorf("OpenJournal(): want balance 100, result %v, %v", got, err)
+	}
+}
+
+func TestService_OpenJournal_corrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallet.journal")
+	content := "00000000 REGISTER\taccount:\"1;+992900000001;0\"\n" +
+		"00000000 REGISTER\taccount:\"2;+992900000002;0\"\n"
+	err := ioutil.WriteFile(path, []byte(content), 0666)
+	if err != nil {
+		t.Fatalf("can't write journal file, %v", err)
+	}
+
+	service := &Service{}
+	err = service.OpenJournal(path)
+	if !errors.Is(err, ErrJournalCorrupted) {
+		t.Errorf("OpenJournal(): must return ErrJournalCorrupted, returned %v", err)
+	}
+}
+
+func TestService_CompactJournal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wallet.journal")
+
+	service := &Service{}
+	err := service.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't open journal, %v", err)
+	}
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	err = service.CompactJournal(dir)
+	if err != nil {
+		t.Fatalf("CompactJournal(): can't compact journal, %v", err)
+	}
+	err = service.Deposit(account.ID, 500)
+	if err != nil {
+		t.Fatalf("Deposit(): can't deposit, %v", err)
+	}
+	account = currentAccount(t, service, account.ID)
+	_ = service.CloseJournal()
+
+	restored := &Service{}
+	err = restored.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import snapshot, %v", err)
+	}
+	err = restored.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't replay journal, %v", err)
+	}
+	defer restored.CloseJournal()
+
+	got, err := restored.FindAccountByID(account.ID)
+	if err != nil || !reflect.DeepEqual(account, got) {
+		t.Errorf("CompactJournal(): want account %v, result %v, %v", account, got, err)
+	}
+	_, err = restored.FindPaymentByID(payments[0].ID)
+	if err != nil {
+		t.Errorf("CompactJournal(): can't find payment from snapshot, %v", err)
+	}
+}
+
+func TestService_OpenJournal_importChunks(t *testing.T) {
+	dir := t.TempDir()
+	count := importChunkRecords + 1
+	accounts := formatDumpHeader(entityAccounts)
+	for i := 1; i <= count; i++ {
+		accounts += fmt.Sprintf("%d;+992%09d;100;0;0;TJS;ACTIVE\n", i, i)
+	}
+	writeTestFiles(t, dir, map[string]string{accountsDump: accounts})
+
+	path := filepath.Join(t.TempDir(), "wallet.journal")
+	service := &Service{}
+	err := service.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't open journal, %v", err)
+	}
+	err = service.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	_ = service.CloseJournal()
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(): can't read journal, %v", err)
+	}
+	// счета и проводки начального остатка: 2 * count записей, не больше importChunkRecords в строке
+	lines := strings.Count(string(content), "\n") - 1
+	if want := (2*count + importChunkRecords - 1) / importChunkRecords; lines != want {
+		t.Errorf("Import(): want %d journal entries, result %d", want, lines)
+	}
+
+	restored := &Service{}
+	err = restored.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't replay journal, %v", err)
+	}
+	defer restored.CloseJournal()
+	got, err := restored.repository().Accounts()
+	if err != nil || len(got) != count {
+		t.Errorf("OpenJournal(): want %d accounts, result %d, %v", count, len(got), err)
+	}
+	err = restored.Audit()
+	if err != nil {
+		t.Errorf("Audit(): opening postings must be replayed, %v", err)
+	}
+}
+
+func TestService_Import_postingsAcrossChunks(t *testing.T) {
+	dir := t.TempDir()
+	count := importChunkRecords + 1
+	accounts := formatDumpHeader(entityAccounts)
+	postings := formatDumpHeader(entityPostings)
+	for i := 1; i <= count; i++ {
+		accounts += fmt.Sprintf("%d;+992%09d;100;0;0;TJS;ACTIVE\n", i, i)
+		postings += fmt.Sprintf("d%d;deposit;;external;account:%d;100;0;TJS\n", i, i)
+	}
+	// проводки в выгрузке после счетов, но импортируются первыми: остатки подтверждены и начальные не нужны
+	writeTestFiles(t, dir, map[string]string{accountsDump: accounts, postingsDump: postings})
+
+	for name, load := range map[string]func(service *Service) error{
+		"Import": func(service *Service) error { return service.Import(dir) },
+		"ImportSnapshot": func(service *Service) error {
+			imported := &Service{}
+			err := imported.Import(dir)
+			if err != nil {
+				return err
+			}
+			path := filepath.Join(t.TempDir(), "wallet.snapshot")
+			err = imported.ExportSnapshot(path)
+			if err != nil {
+				return err
+			}
+			return service.ImportSnapshot(path)
+		},
+	} {
+		service := &Service{}
+		err := load(service)
+		if err != nil {
+			t.Fatalf("%s(): can't import, %v", name, err)
+		}
+		got, err := service.repository().Postings()
+		if err != nil || len(got) != count {
+			t.Errorf("%s(): want %d postings without opening ones, result %d, %v", name, count, len(got), err)
+		}
+		err = service.Audit()
+		if err != nil {
+			t.Errorf("%s(): ledger must match balances, %v", name, err)
+		}
+	}
+}
+
+func TestService_Import_invalidRecordSavesNothing(t *testing.T) {
+	dir := t.TempDir()
+	accounts := formatDumpHeader(entityAccounts)
+	for i := 1; i <= importChunkRecords+1; i++ {
+		accounts += fmt.Sprintf("%d;+992%09d;100;0;0;TJS;ACTIVE\n", i, i)
+	}
+	accounts += "x;+992000000000;100;0;0;TJS;ACTIVE\n"
+	writeTestFiles(t, dir, map[string]string{accountsDump: accounts})
+
+	service := &Service{}
+	err := service.Import(dir)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Import(): must return *ParseError, returned %v", err)
+	}
+	got, err := service.repository().Accounts()
+	if err != nil || len(got) != 0 {
+		t.Errorf("Import(): accounts before the invalid record must not be saved, result %d, %v", len(got), err)
+	}
+
+	report, err := service.ImportLenient(dir)
+	if err != nil || len(report.Skipped) != 1 {
+		t.Fatalf("ImportLenient(): want 1 skipped record, result %v, %v", report, err)
+	}
+	got, err = service.repository().Accounts()
+	if err != nil || len(got) != importChunkRecords+1 {
+		t.Errorf("ImportLenient(): want %d accounts, result %d, %v", importChunkRecords+1, len(got), err)
+	}
+}
+
+func TestJournalEntry_split(t *testing.T) {
+	entry := journalEntry{
+		op:       opImport,
+		accounts: []*types.Account{{ID: 1}, {ID: 2}},
+		postings: []*types.Posting{{ID: "p1"}},
+		deleted:  []deletedRecord{{kind: kindFavorite, id: "f1"}},
+	}
+	chunks := entry.split(2)
+	want := []journalEntry{
+		{op: opImport, postings: entry.postings, accounts: entry.accounts[:1]},
+		{op: opImport, accounts: entry.accounts[1:], deleted: entry.deleted},
+	}
+	if !reflect.DeepEqual(chunks, want) {
+		t.Errorf("split(): want %v, result %v", want, chunks)
+	}
+	if chunks := (journalEntry{op: opImport}).split(2); len(chunks) != 0 {
+		t.Errorf("split(): empty entry must not be split into entries, result %v", chunks)
+	}
+}
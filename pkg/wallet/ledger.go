@@ -0,0 +1,214 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/types"
+	"github.com/google/uuid"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrLedgerMismatch = errors.New("ledger does not match balances")
+
+// Счета учёта. Каждому счёту пользователя соответствует счёт учёта "account:<ID>",
+// внешние источники средств (пополнения, начальные остатки) - "external",
+// получатели платежей - "category:<категория>",
+// обмен валют при переводе между счетами в разных валютах - "exchange:<валюта>".
+const (
+	ledgerAccountPrefix  = "account:"
+	ledgerCategoryPrefix = "category:"
+	ledgerExchangePrefix = "exchange:"
+	ledgerExternal       = "external"
+)
+
+func ledgerAccount(accountID int64) string {
+	return ledgerAccountPrefix + strconv.FormatInt(accountID, 10)
+}
+
+func ledgerCategory(category types.PaymentCategory) string {
+	return ledgerCategoryPrefix + string(category)
+}
+
+func ledgerExchange(currency types.Currency) string {
+	return ledgerExchangePrefix + string(currency)
+}
+
+func (s *Service) newPosting(reason types.PostingReason, paymentID string, from string, to string, amount types.Amount) *types.Posting {
+	return &types.Posting{
+		ID:        uuid.New().String(),
+		Reason:    reason,
+		PaymentID: paymentID,
+		From:      from,
+		To:        to,
+		Amount:    amount.Value,
+		Currency:  amount.Currency,
+		CreatedAt: s.currentTime(),
+	}
+}
+
+// exchangePostings перемещает fromAmount со счёта учёта from и fromAmount, пересчитанную в toAmount, на счёт учёта to.
+// Если валюты совпадают, это одна проводка, иначе две - через счета обмена каждой из валют.
+func (s *Service) exchangePostings(reason types.PostingReason, paymentID string, from string, fromAmount types.Amount, to string, toAmount types.Amount) []*types.Posting {
+	if fromAmount.Currency == toAmount.Currency {
+		return []*types.Posting{s.newPosting(reason, paymentID, from, to, fromAmount)}
+	}
+	return []*types.Posting{
+		s.newPosting(reason, paymentID, from, ledgerExchange(fromAmount.Currency), fromAmount),
+		s.newPosting(reason, paymentID, ledgerExchange(toAmount.Currency), to, toAmount),
+	}
+}
+
+// ledgerBalances возвращает остатки всех счетов учёта по проводкам.
+func ledgerBalances(postings []*types.Posting) map[string]types.Money {
+	balances := make(map[string]types.Money)
+	for _, posting := range postings {
+		balances[posting.From] -= posting.Amount
+		balances[posting.To] += posting.Amount
+	}
+	return balances
+}
+
+// LedgerBalance возвращает остаток счёта accountID, вычисленный по проводкам.
+func (s *Service) LedgerBalance(accountID int64) (types.Money, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return 0, err
+	}
+	postings, err := s.repository().Postings()
+	if err != nil {
+		return 0, err
+	}
+
+	return ledgerBalances(postings)[ledgerAccount(accountID)], nil
+}
+
+// Audit проверяет проводки и остатки:
+//   - каждая проводка ссылается только на существующие счета и платежи, а её валюта совпадает с валютой счетов;
+//   - проводки каждого платежа списывают со счёта платежа его сумму, а проводки отменённого платежа - ноль;
+//   - остаток каждого счёта пользователя совпадает с остатком, вычисленным по проводкам.
+//
+// При расхождении возвращает ошибку, оборачивающую ErrLedgerMismatch.
+func (s *Service) Audit() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	postings, err := s.repository().Postings()
+	if err != nil {
+		return err
+	}
+	accounts, err := s.repository().Accounts()
+	if err != nil {
+		return err
+	}
+	payments, err := s.repository().Payments()
+	if err != nil {
+		return err
+	}
+
+	currencies := make(map[string]types.Currency)
+	for _, account := range accounts {
+		currencies[ledgerAccount(account.ID)] = account.Currency
+	}
+	paymentsByID := make(map[string]*types.Payment)
+	for _, payment := range payments {
+		paymentsByID[payment.ID] = payment
+	}
+
+	mismatches := []string{}
+	// debited - сколько проводки каждого платежа списали со счёта платежа
+	debited := make(map[string]types.Money)
+	for _, posting := range postings {
+		if posting.Amount <= 0 {
+			mismatches = append(mismatches, fmt.Sprintf("posting %s: amount %d", posting.ID, posting.Amount))
+		}
+		for _, key := range []string{posting.From, posting.To} {
+			mismatch := checkLedgerAccount(key, posting.Currency, currencies)
+			if mismatch != "" {
+				mismatches = append(mismatches, fmt.Sprintf("posting %s: %s", posting.ID, mismatch))
+			}
+		}
+		if posting.PaymentID == "" {
+			continue
+		}
+		payment, ok := paymentsByID[posting.PaymentID]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("posting %s: unknown payment %s", posting.ID, posting.PaymentID))
+			continue
+		}
+		key := ledgerAccount(payment.AccountID)
+		if posting.From == key {
+			debited[payment.ID] += posting.Amount
+		}
+		if posting.To == key {
+			debited[payment.ID] -= posting.Amount
+		}
+	}
+	for paymentID, amount := range debited {
+		payment := paymentsByID[paymentID]
+		want := payment.Amount
+		if payment.Status == types.PaymentStatusFail || payment.Status == types.PaymentStatusExpired {
+			want = 0
+		}
+		if amount != want {
+			mismatches = append(mismatches, fmt.Sprintf("payment %s: %s %d, postings %d", paymentID, payment.Status, want, amount))
+		}
+	}
+
+	balances := ledgerBalances(postings)
+	for _, account := range accounts {
+		key := ledgerAccount(account.ID)
+		if balances[key] != account.Balance {
+			mismatches = append(mismatches, fmt.Sprintf("account %d: balance %d, ledger %d", account.ID, account.Balance, balances[key]))
+		}
+	}
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return fmt.Errorf("%w: %s", ErrLedgerMismatch, strings.Join(mismatches, "; "))
+	}
+
+	return nil
+}
+
+// checkLedgerAccount проверяет, что счёт учёта key существует и ведётся в валюте currency.
+// currencies - валюты счетов учёта пользователей. Возвращает описание расхождения или пустую строку.
+func checkLedgerAccount(key string, currency types.Currency, currencies map[string]types.Currency) string {
+	switch {
+	case strings.HasPrefix(key, ledgerAccountPrefix):
+		accountCurrency, ok := currencies[key]
+		if !ok {
+			return "unknown account " + key
+		}
+		if accountCurrency != currency {
+			return fmt.Sprintf("currency %s, %s is in %s", currency, key, accountCurrency)
+		}
+	case strings.HasPrefix(key, ledgerExchangePrefix):
+		if types.Currency(strings.TrimPrefix(key, ledgerExchangePrefix)) != currency {
+			return fmt.Sprintf("currency %s, %s", currency, key)
+		}
+	case strings.HasPrefix(key, ledgerCategoryPrefix), key == ledgerExternal:
+	default:
+		return "unknown ledger account " + key
+	}
+	return ""
+}
+
+// openingPostings возвращает проводки, которые приводят остатки по проводкам balances (см. ledgerBalances) для accounts
+// к их текущим остаткам. Используется при импорте счетов, история которых неизвестна.
+func (s *Service) openingPostings(accounts []*types.Account, balances map[string]types.Money) []*types.Posting {
+	opening := []*types.Posting{}
+	for _, account := range accounts {
+		diff := account.Balance - balances[ledgerAccount(account.ID)]
+		if diff > 0 {
+			opening = append(opening, s.newPosting(types.PostingReasonOpening, "", ledgerExternal, ledgerAccount(account.ID), types.Amount{Value: diff, Currency: account.Currency}))
+		}
+		if diff < 0 {
+			opening = append(opening, s.newPosting(types.PostingReasonOpening, "", ledgerAccount(account.ID), ledgerExternal, types.Amount{Value: -diff, Currency: account.Currency}))
+		}
+	}
+	return opening
+}
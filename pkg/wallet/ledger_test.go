@@ -0,0 +1,184 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/akhrorov/wallet/pkg/types"
+	"testing"
+)
+
+func TestService_Audit_success(t *testing.T) {
+	service := &Service{}
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	_, _, err = service.addAccount(defaultExampleTestAccount2)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	err = service.Reject(payments[0].ID)
+	if err != nil {
+		t.Fatalf("Reject(): can't reject payment, %v", err)
+	}
+	_, err = service.Repeat(payments[0].ID)
+	if err != nil {
+		t.Fatalf("Repeat(): can't repeat payment, %v", err)
+	}
+
+	err = service.Audit()
+	if err != nil {
+		t.Errorf("Audit(): ledger must match balances, %v", err)
+	}
+
+	balance, err := service.LedgerBalance(account.ID)
+	if err != nil {
+		t.Fatalf("LedgerBalance(): can't get balance, %v", err)
+	}
+	if balance != account.Balance {
+		t.Errorf("LedgerBalance(): want %v, result %v", account.Balance, balance)
+	}
+}
+
+func TestService_Audit_fail(t *testing.T) {
+	service := &Service{}
+	account, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+
+	// изменяем остаток в обход проводок
+	tampered := *account
+	tampered.Balance += 1
+	err = service.repository().SaveAccount(&tampered)
+	if err != nil {
+		t.Fatalf("SaveAccount(): can't save account, %v", err)
+	}
+
+	err = service.Audit()
+	if !errors.Is(err, ErrLedgerMismatch) {
+		t.Errorf("Audit(): must return ErrLedgerMismatch, returned %v", err)
+	}
+}
+
+func TestService_Audit_corruptedPosting(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(posting *types.Posting, other *types.Posting)
+	}{
+		{"unknown payment", func(posting *types.Posting, other *types.Posting) { posting.PaymentID = "missing" }},
+		{"unknown account", func(posting *types.Posting, other *types.Posting) { posting.From = ledgerAccount(99) }},
+		{"unknown ledger account", func(posting *types.Posting, other *types.Posting) { posting.To = "nowhere" }},
+		{"currency", func(posting *types.Posting, other *types.Posting) { posting.Currency = types.CurrencyUSD }},
+		{"amount", func(posting *types.Posting, other *types.Posting) { posting.Amount = -posting.Amount }},
+		// остатки счетов не меняются, но проводки не совпадают с суммами платежей
+		{"payment", func(posting *types.Posting, other *types.Posting) {
+			posting.PaymentID, other.PaymentID = other.PaymentID, posting.PaymentID
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &Service{}
+			service.SetConverter(newTestRates(t))
+			account, _, err := service.addAccount(defaultExampleTestAccount)
+			if err != nil {
+				t.Fatalf("addAccount(): can't add account, %v", err)
+			}
+			usd, err := service.RegisterAccountWithCurrency("+992900000002", types.CurrencyUSD)
+			if err != nil {
+				t.Fatalf("RegisterAccountWithCurrency(): can't register account, %v", err)
+			}
+			first, err := service.Pay(account.ID, 100_00, "food")
+			if err != nil {
+				t.Fatalf("Pay(): can't pay, %v", err)
+			}
+			second, err := service.Pay(account.ID, 200_00, "food")
+			if err != nil {
+				t.Fatalf("Pay(): can't pay, %v", err)
+			}
+			// перевод в другой валюте и его отмена проходят через счета обмена
+			transfer, err := service.Transfer(account.ID, usd.ID, 113_00)
+			if err != nil {
+				t.Fatalf("Transfer(): can't transfer, %v", err)
+			}
+			err = service.Reject(transfer.ID)
+			if err != nil {
+				t.Fatalf("Reject(): can't reject transfer, %v", err)
+			}
+			err = service.Audit()
+			if err != nil {
+				t.Fatalf("Audit(): ledger must match balances, %v", err)
+			}
+
+			postings, err := service.repository().Postings()
+			if err != nil {
+				t.Fatalf("Postings(): can't get postings, %v", err)
+			}
+			// проводки не изменяются через Repository, поэтому портим сохранённые записи напрямую
+			var posting, other *types.Posting
+			for _, p := range postings {
+				if p.PaymentID == first.ID {
+					posting = p
+				}
+				if p.PaymentID == second.ID {
+					other = p
+				}
+			}
+			tt.corrupt(posting, other)
+
+			err = service.Audit()
+			if !errors.Is(err, ErrLedgerMismatch) {
+				t.Errorf("Audit(): must return ErrLedgerMismatch, returned %v", err)
+			}
+		})
+	}
+}
+
+func TestService_Import_openingPostings(t *testing.T) {
+	dir := t.TempDir()
+	service := &Service{}
+	_, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	err = service.ExportToFile(dir + "/accounts.txt")
+	if err != nil {
+		t.Fatalf("ExportToFile(): can't export, %v", err)
+	}
+	err = service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+
+	// в accounts.txt нет истории проводок - остатки должны стать начальными
+	fromFile := &Service{}
+	err = fromFile.ImportFromFile(dir + "/accounts.txt")
+	if err != nil {
+		t.Fatalf("ImportFromFile(): can't import, %v", err)
+	}
+	err = fromFile.Audit()
+	if err != nil {
+		t.Errorf("Audit(): ledger must match balances after ImportFromFile, %v", err)
+	}
+
+	// в каталоге с дампами есть postings.dump - начальные остатки не нужны
+	fromDir := &Service{}
+	err = fromDir.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	err = fromDir.Audit()
+	if err != nil {
+		t.Errorf("Audit(): ledger must match balances after Import, %v", err)
+	}
+	postings, err := fromDir.repository().Postings()
+	if err != nil {
+		t.Fatalf("Postings(): can't get postings, %v", err)
+	}
+	original, err := service.repository().Postings()
+	if err != nil {
+		t.Fatalf("Postings(): can't get postings, %v", err)
+	}
+	if len(postings) != len(original) {
+		t.Errorf("Import(): want %v postings, result %v", len(original), len(postings))
+	}
+}
@@ -0,0 +1,154 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/akhrorov/wallet/pkg/types"
+)
+
+var ErrAccountBlocked = errors.New("account blocked")
+var ErrAccountClosed = errors.New("account closed")
+var ErrAccountNotEmpty = errors.New("account balance must be paid out before closing")
+var ErrPaymentsInProgress = errors.New("account has payments in progress")
+
+// BlockAccount блокирует счёт: платежи, пополнения и переводы по нему не проводятся до UnblockAccount.
+// Подтвердить или отклонить уже созданные платежи можно.
+func (s *Service) BlockAccount(accountID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.setAccountStatus(accountID, types.AccountStatusBlocked, opBlockAccount)
+}
+
+// UnblockAccount снимает блокировку со счёта.
+func (s *Service) UnblockAccount(accountID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.setAccountStatus(accountID, types.AccountStatusActive, opUnblockAccount)
+}
+
+// CloseAccount закрывает счёт с нулевым остатком. Если на счёте есть средства, возвращает ErrAccountNotEmpty -
+// их нужно вывести через CloseAccountWithPayout. Расписания платежей по счёту удаляются.
+func (s *Service) CloseAccount(accountID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+	if account.Balance != 0 {
+		return ErrAccountNotEmpty
+	}
+
+	return s.closeAccount(account, nil)
+}
+
+// CloseAccountWithPayout выплачивает весь остаток счёта владельцу и закрывает счёт.
+// Возвращает выплаченную сумму.
+func (s *Service) CloseAccountWithPayout(accountID int64) (types.Money, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return 0, err
+	}
+
+	payout := account.Balance
+	postings := []*types.Posting{}
+	if payout != 0 {
+		postings = append(postings, s.newPosting(types.PostingReasonPayout, "", ledgerAccount(accountID), ledgerExternal, types.Amount{Value: payout, Currency: account.Currency}))
+	}
+	err = s.closeAccount(account, postings)
+	if err != nil {
+		return 0, err
+	}
+	return payout, nil
+}
+
+// closeAccount закрывает счёт, обнуляя остаток. postings должны списать весь остаток со счёта.
+func (s *Service) closeAccount(account *types.Account, postings []*types.Posting) error {
+	err := account.Status.Transition(types.AccountStatusClosed)
+	if err != nil {
+		return err
+	}
+
+	// отклонённый после закрытия платёж вернул бы средства на закрытый счёт
+	payments, err := s.repository().PaymentsByAccount(account.ID)
+	if err != nil {
+		return err
+	}
+	for _, payment := range payments {
+		if payment.Status == types.PaymentStatusInProgress {
+			return ErrPaymentsInProgress
+		}
+	}
+
+	deleted, err := s.accountSchedules(account.ID)
+	if err != nil {
+		return err
+	}
+
+	updated := *account
+	updated.Status = types.AccountStatusClosed
+	updated.Balance = 0
+	updated.UpdatedAt = s.currentTime()
+	return s.apply(journalEntry{
+		op:       opCloseAccount,
+		accounts: []*types.Account{&updated},
+		postings: postings,
+		deleted:  deleted,
+	})
+}
+
+// accountSchedules возвращает расписания платежей по элементам "Избранное" счёта accountID как удаляемые записи.
+func (s *Service) accountSchedules(accountID int64) ([]deletedRecord, error) {
+	schedules, err := s.repository().Schedules()
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := []deletedRecord{}
+	for _, schedule := range schedules {
+		favorite, err := s.findFavoriteByID(schedule.FavoriteID)
+		if err == ErrFavoriteNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if favorite.AccountID == accountID {
+			deleted = append(deleted, deletedRecord{kind: kindSchedule, id: schedule.ID})
+		}
+	}
+	return deleted, nil
+}
+
+func (s *Service) setAccountStatus(accountID int64, status types.AccountStatus, op string) error {
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+	err = account.Status.Transition(status)
+	if err != nil {
+		return err
+	}
+
+	updated := *account
+	updated.Status = status
+	updated.UpdatedAt = s.currentTime()
+	return s.apply(journalEntry{op: op, accounts: []*types.Account{&updated}})
+}
+
+// checkActive возвращает ErrAccountBlocked или ErrAccountClosed, если по счёту нельзя проводить операции.
+func checkActive(account *types.Account) error {
+	switch account.Status {
+	case types.AccountStatusBlocked:
+		return ErrAccountBlocked
+	case types.AccountStatusClosed:
+		return ErrAccountClosed
+	default:
+		return nil
+	}
+}
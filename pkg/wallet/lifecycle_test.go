@@ -0,0 +1,230 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/akhrorov/wallet/pkg/types"
+	"path/filepath"
+	"testing"
+)
+
+func TestService_BlockAccount(t *testing.T) {
+	service := &Service{}
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	other, _, err := service.addAccount(defaultExampleTestAccount2)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+
+	err = service.BlockAccount(account.ID)
+	if err != nil {
+		t.Fatalf("BlockAccount(): can't block account, %v", err)
+	}
+	account = currentAccount(t, service, account.ID)
+	if account.Status != types.AccountStatusBlocked {
+		t.Errorf("BlockAccount(): want status %s, result %s", types.AccountStatusBlocked, account.Status)
+	}
+
+	_, err = service.Pay(account.ID, 1_00, "auto")
+	if err != ErrAccountBlocked {
+		t.Errorf("Pay(): must return ErrAccountBlocked, returned %v", err)
+	}
+	err = service.Deposit(account.ID, 1_00)
+	if err != ErrAccountBlocked {
+		t.Errorf("Deposit(): must return ErrAccountBlocked, returned %v", err)
+	}
+	_, err = service.Repeat(payments[0].ID)
+	if err != ErrAccountBlocked {
+		t.Errorf("Repeat(): must return ErrAccountBlocked, returned %v", err)
+	}
+	_, err = service.PayFromFavorite(favorite.ID)
+	if err != ErrAccountBlocked {
+		t.Errorf("PayFromFavorite(): must return ErrAccountBlocked, returned %v", err)
+	}
+	_, err = service.Transfer(other.ID, account.ID, 1_00)
+	if err != ErrAccountBlocked {
+		t.Errorf("Transfer(): must return ErrAccountBlocked, returned %v", err)
+	}
+	err = service.Reject(payments[0].ID)
+	if err != nil {
+		t.Errorf("Reject(): payments of blocked account must be rejectable, returned %v", err)
+	}
+	err = service.BlockAccount(account.ID)
+	if !errors.Is(err, types.ErrInvalidAccountStatusTransition) {
+		t.Errorf("BlockAccount(): must return ErrInvalidAccountStatusTransition, returned %v", err)
+	}
+
+	err = service.UnblockAccount(account.ID)
+	if err != nil {
+		t.Fatalf("UnblockAccount(): can't unblock account, %v", err)
+	}
+	_, err = service.Pay(account.ID, 1_00, "auto")
+	if err != nil {
+		t.Errorf("Pay(): must work after UnblockAccount, returned %v", err)
+	}
+}
+
+func TestService_CloseAccount(t *testing.T) {
+	service := &Service{}
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	other, _, err := service.addAccount(defaultExampleTestAccount2)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+	schedule, err := service.SchedulePayment(favorite.ID, "daily")
+	if err != nil {
+		t.Fatalf("SchedulePayment(): can't schedule, %v", err)
+	}
+
+	err = service.CloseAccount(account.ID)
+	if err != ErrAccountNotEmpty {
+		t.Errorf("CloseAccount(): must return ErrAccountNotEmpty, returned %v", err)
+	}
+	_, err = service.CloseAccountWithPayout(account.ID)
+	if err != ErrPaymentsInProgress {
+		t.Errorf("CloseAccountWithPayout(): must return ErrPaymentsInProgress, returned %v", err)
+	}
+	err = service.Confirm(payments[0].ID)
+	if err != nil {
+		t.Fatalf("Confirm(): can't confirm payment, %v", err)
+	}
+
+	balance := currentAccount(t, service, account.ID).Balance
+	payout, err := service.CloseAccountWithPayout(account.ID)
+	if err != nil {
+		t.Fatalf("CloseAccountWithPayout(): can't close account, %v", err)
+	}
+	account = currentAccount(t, service, account.ID)
+	if payout != balance || account.Balance != 0 || account.Status != types.AccountStatusClosed {
+		t.Errorf("CloseAccountWithPayout(): want payout %v, result %v, account %v", balance, payout, account)
+	}
+	_, err = service.FindScheduleByID(schedule.ID)
+	if err != ErrScheduleNotFound {
+		t.Errorf("CloseAccountWithPayout(): schedules must be removed, returned %v", err)
+	}
+	err = service.Audit()
+	if err != nil {
+		t.Errorf("Audit(): payout must be posted, %v", err)
+	}
+
+	err = service.Deposit(account.ID, 1_00)
+	if err != ErrAccountClosed {
+		t.Errorf("Deposit(): must return ErrAccountClosed, returned %v", err)
+	}
+	_, err = service.Transfer(other.ID, account.ID, 1_00)
+	if err != ErrAccountClosed {
+		t.Errorf("Transfer(): must return ErrAccountClosed, returned %v", err)
+	}
+	err = service.UnblockAccount(account.ID)
+	if !errors.Is(err, types.ErrInvalidAccountStatusTransition) {
+		t.Errorf("UnblockAccount(): must return ErrInvalidAccountStatusTransition, returned %v", err)
+	}
+
+	empty, err := service.RegisterAccount("+992000000003")
+	if err != nil {
+		t.Fatalf("RegisterAccount(): can't register account, %v", err)
+	}
+	err = service.CloseAccount(empty.ID)
+	if err == nil {
+		empty = currentAccount(t, service, empty.ID)
+	}
+	if err != nil || empty.Status != types.AccountStatusClosed {
+		t.Errorf("CloseAccount(): empty account must be closed, result %v, %v", empty, err)
+	}
+}
+
+func TestService_Import_accountStatus(t *testing.T) {
+	dir := t.TempDir()
+	service := &Service{}
+	blocked, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	closed, err := service.RegisterAccount("+992000000003")
+	if err != nil {
+		t.Fatalf("RegisterAccount(): can't register account, %v", err)
+	}
+	err = service.BlockAccount(blocked.ID)
+	if err != nil {
+		t.Fatalf("BlockAccount(): can't block account, %v", err)
+	}
+	err = service.CloseAccount(closed.ID)
+	if err != nil {
+		t.Fatalf("CloseAccount(): can't close account, %v", err)
+	}
+	blocked = currentAccount(t, service, blocked.ID)
+	closed = currentAccount(t, service, closed.ID)
+	err = service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+
+	imported := &Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	for _, want := range []*types.Account{blocked, closed} {
+		got, err := imported.FindAccountByID(want.ID)
+		if err != nil || got.Status != want.Status {
+			t.Errorf("Import(): want status %s, result %v, %v", want.Status, got, err)
+		}
+	}
+}
+
+func TestService_OpenJournal_accountStatus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallet.journal")
+	service := &Service{}
+	err := service.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't open journal, %v", err)
+	}
+	account, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	err = service.BlockAccount(account.ID)
+	if err != nil {
+		t.Fatalf("BlockAccount(): can't block account, %v", err)
+	}
+	err = service.CloseJournal()
+	if err != nil {
+		t.Fatalf("CloseJournal(): can't close journal, %v", err)
+	}
+
+	restored := &Service{}
+	err = restored.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't replay journal, %v", err)
+	}
+	defer restored.CloseJournal()
+
+	_, err = restored.Pay(account.ID, 1_00, "auto")
+	if err != ErrAccountBlocked {
+		t.Errorf("Pay(): must return ErrAccountBlocked after replay, returned %v", err)
+	}
+}
+
+func TestParseAccount_withoutStatus(t *testing.T) {
+	account, err := parseAccountV2("1;+992000000001;100;0;0;TJS")
+	if err != nil || account.Status != types.AccountStatusActive {
+		t.Errorf("parseAccountV2(): want active account, result %v, %v", account, err)
+	}
+	_, err = parseAccount("1;+992000000001;100;0;0;TJS;FROZEN")
+	if err == nil {
+		t.Errorf("parseAccount(): must fail on unknown status")
+	}
+}
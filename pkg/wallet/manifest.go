@@ -0,0 +1,410 @@
+package wallet
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/record"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// manifestDump - файл, в котором Export перечисляет файлы одного поколения выгрузки.
+const manifestDump = "manifest.dump"
+
+const entityManifest = "manifest"
+
+// exportEntities - виды записей, из которых состоит поколение выгрузки.
+var exportEntities = []string{entityAccounts, entityPayments, entityFavorites, entityPostings, entityIdempotency, entitySchedules}
+
+// exportDumps возвращает имена всех файлов выгрузки во всех форматах, несжатых и сжатых.
+func exportDumps() []string {
+	names := []string{}
+	for _, compression := range compressions {
+		for _, format := range formats {
+			for _, entity := range exportEntities {
+				names = append(names, compressedName(dumpName(entity, format), compression))
+			}
+		}
+	}
+	return names
+}
+
+var ErrManifestMismatch = errors.New("dump does not match manifest")
+var ErrNothingToExport = errors.New("nothing to export")
+
+// manifestEntry - файл поколения выгрузки: число записей и контрольная сумма SHA-256 содержимого.
+type manifestEntry struct {
+	name     string
+	records  int
+	checksum string
+}
+
+func formatManifestEntry(entry manifestEntry) string {
+	return record.Join(entry.name, strconv.Itoa(entry.records), entry.checksum) + "\n"
+}
+
+func parseManifestEntry(line string) (manifestEntry, error) {
+	fields, err := splitRecord(line, 3)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+	r := fieldReader{fields: fields}
+	entry := manifestEntry{name: fields[0], records: int(r.int(1, "records")), checksum: fields[2]}
+	if r.err != nil {
+		return manifestEntry{}, r.err
+	}
+	return entry, nil
+}
+
+// readManifest читает манифест каталога dir. Если манифеста нет, возвращает ошибку os.IsNotExist.
+func readManifest(dir string) ([]manifestEntry, error) {
+	entries := []manifestEntry{}
+	err := readDump(filepath.Join(dir, manifestDump), entityManifest, nil, func(reader dumpReader, line string) error {
+		entry, err := parseManifestEntry(line)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// writeManifest атомарно записывает манифест entries в каталог dir.
+func writeManifest(dir string, entries []manifestEntry) error {
+	return replaceFile(filepath.Join(dir, manifestDump), func(tmp string) error {
+		return writeRecords(tmp, entityManifest, len(entries), func(i int) string {
+			return formatManifestEntry(entries[i])
+		})
+	})
+}
+
+// fileChecksum возвращает контрольную сумму SHA-256 файла path и число записей в нём
+// (непустых строк, кроме заголовка, а для CSV - записей, кроме строки с именами полей).
+// Для сжатого файла контрольная сумма считается по сжатому содержимому, а записи - по распакованному.
+func fileChecksum(path string) (checksum string, records int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+
+	hash := sha256.New()
+	content := io.TeeReader(file, hash)
+	decompressed, err := decompress(content)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		if cerr := decompressed.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	if format, ok := tableFormat(path); ok && format == FormatCSV {
+		// в CSV запись может занимать несколько строк
+		records, err = countCSVRecords(decompressed)
+	} else {
+		records, err = countLines(decompressed)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	// после конца сжатого потока в файле могут остаться байты, они тоже входят в контрольную сумму
+	_, err = io.Copy(io.Discard, content)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), records, nil
+}
+
+// countLines возвращает число непустых строк r, не считая заголовка.
+func countLines(r io.Reader) (int, error) {
+	reader := bufio.NewReader(r)
+	records := 0
+	for first := true; ; first = false {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if len(line) > 0 && !(first && strings.HasPrefix(line, "#")) {
+			records++
+		}
+		if err == io.EOF {
+			return records, nil
+		}
+	}
+}
+
+// countCSVRecords возвращает число записей CSV, не считая строки с именами полей.
+func countCSVRecords(r io.Reader) (int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records := -1
+	for {
+		_, err := reader.Read()
+		if err == io.EOF {
+			if records < 0 {
+				return 0, nil
+			}
+			return records, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		records++
+	}
+}
+
+// verifyEntry проверяет, что файл path совпадает с записью манифеста entry.
+func verifyEntry(path string, entry manifestEntry) error {
+	checksum, records, err := fileChecksum(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s is missing", ErrManifestMismatch, entry.name)
+	}
+	if err != nil {
+		return err
+	}
+	if checksum != entry.checksum {
+		return fmt.Errorf("%w: %s checksum %s, want %s", ErrManifestMismatch, entry.name, checksum, entry.checksum)
+	}
+	if records != entry.records {
+		return fmt.Errorf("%w: %s has %d records, want %d", ErrManifestMismatch, entry.name, records, entry.records)
+	}
+	return nil
+}
+
+// exportGeneration записывает поколение выгрузки: файлы пишутся во временные name.tmp,
+// а после commit заменяют прежние. Точка фиксации - переименование манифеста:
+// если сбой произошёл до него, остаётся прежнее поколение, если после -
+// recoverExport доводит замену файлов до конца.
+type exportGeneration struct {
+	dir     string
+	entries []manifestEntry
+}
+
+// write записывает файл name поколения через write. Если записей нет (count == 0), файла в поколении не будет.
+func (g *exportGeneration) write(name string, count int, write func(path string) error) error {
+	if count == 0 {
+		return nil
+	}
+	tmp := filepath.Join(g.dir, name+".tmp")
+	err := write(tmp)
+	if err != nil {
+		return err
+	}
+	checksum, records, err := fileChecksum(tmp)
+	if err != nil {
+		return err
+	}
+	g.entries = append(g.entries, manifestEntry{name: name, records: records, checksum: checksum})
+	return nil
+}
+
+// commit фиксирует поколение и заменяет им файлы каталога. Файлы выгрузки, которых нет в поколении, удаляются.
+func (g *exportGeneration) commit() error {
+	listed := make(map[string]bool)
+	for _, entry := range g.entries {
+		listed[entry.name] = true
+	}
+	err := writeManifest(g.dir, g.entries)
+	if err != nil {
+		return err
+	}
+
+	err = recoverExport(g.dir)
+	if err != nil {
+		return err
+	}
+	// удалённые записи (например, избранное) не должны вернуться при импорте из старого файла
+	for _, name := range exportDumps() {
+		if !listed[name] {
+			err = removeIfExists(filepath.Join(g.dir, name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return syncDir(g.dir)
+}
+
+// discard удаляет временные файлы незафиксированного поколения.
+func (g *exportGeneration) discard() {
+	for _, entry := range g.entries {
+		err := removeIfExists(filepath.Join(g.dir, entry.name+".tmp"))
+		if err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// recoverExport доводит до конца замену файлов зафиксированного поколения в каталоге dir
+// и удаляет временные файлы незафиксированного. Вызывается только под lockExportDir.
+func recoverExport(dir string) error {
+	err := removeIfExists(filepath.Join(dir, manifestDump+".tmp"))
+	if err != nil {
+		return err
+	}
+	entries, err := readManifest(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listed := make(map[string]manifestEntry)
+	for _, entry := range entries {
+		listed[entry.name] = entry
+	}
+
+	renamed := false
+	for _, name := range exportDumps() {
+		path := filepath.Join(dir, name)
+		checksum, _, err := fileChecksum(path + ".tmp")
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		entry, ok := listed[name]
+		if !ok || checksum != entry.checksum {
+			err = removeIfExists(path + ".tmp")
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = os.Rename(path+".tmp", path)
+		if err != nil {
+			return err
+		}
+		renamed = true
+	}
+	if !renamed {
+		return nil
+	}
+	return syncDir(dir)
+}
+
+// generationFile - файл выгрузки name и путь, по которому его нужно читать.
+type generationFile struct {
+	name string
+	path string
+}
+
+// generationDumps возвращает файлы выгрузки, которые нужно читать из каталога dir:
+// перечисленные в манифесте после проверки их содержимого или, если манифеста нет, все.
+// Каталог не изменяется: незавершённую замену файлов доводит до конца следующая выгрузка.
+func generationDumps(dir string) ([]generationFile, error) {
+	entries, err := readManifest(dir)
+	if os.IsNotExist(err) {
+		files := []generationFile{}
+		for _, name := range exportDumps() {
+			files = append(files, generationFile{name: name, path: filepath.Join(dir, name)})
+		}
+		return files, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := []generationFile{}
+	for _, entry := range entries {
+		path, err := generationPath(dir, entry)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, generationFile{name: entry.name, path: path})
+	}
+	return files, nil
+}
+
+// generationPath проверяет файл entry зафиксированного поколения в каталоге dir и возвращает путь к нему.
+// Если после фиксации манифеста выгрузка не успела переименовать временный файл, возвращается путь к нему.
+func generationPath(dir string, entry manifestEntry) (string, error) {
+	path := filepath.Join(dir, entry.name)
+	if verifyEntry(path+".tmp", entry) == nil {
+		return path + ".tmp", nil
+	}
+	err := verifyEntry(path, entry)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// exportLocks упорядочивает выгрузки и импорт в одном каталоге: временные файлы поколения
+// имеют постоянные имена, поэтому одновременные выгрузки испортили бы файлы друг друга.
+// Блокировка каталога удаляется, когда её больше никто не ждёт.
+var exportLocks = struct {
+	sync.Mutex
+	dirs map[string]*dirLock
+}{dirs: make(map[string]*dirLock)}
+
+// dirLock - блокировка каталога и число тех, кто её держит или ждёт.
+type dirLock struct {
+	sync.Mutex
+	users int
+}
+
+// lockExportDir блокирует каталог dir для выгрузки и импорта и возвращает функцию снятия блокировки.
+// Блокировка действует в пределах процесса для всех Service.
+func lockExportDir(dir string) (func(), error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	exportLocks.Lock()
+	lock, ok := exportLocks.dirs[abs]
+	if !ok {
+		lock = &dirLock{}
+		exportLocks.dirs[abs] = lock
+	}
+	lock.users++
+	exportLocks.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		exportLocks.Lock()
+		defer exportLocks.Unlock()
+		lock.users--
+		if lock.users == 0 {
+			delete(exportLocks.dirs, abs)
+		}
+	}, nil
+}
+
+func removeIfExists(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// syncDir сохраняет на диск переименования и удаления файлов в каталоге dir.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+	return file.Sync()
+}
@@ -0,0 +1,206 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/akhrorov/wallet/pkg/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// copyDump копирует файл name из каталога from в каталог to под именем target.
+func copyDump(t *testing.T, from string, to string, name string, target string) {
+	t.Helper()
+	content, err := ioutil.ReadFile(filepath.Join(from, name))
+	if err != nil {
+		t.Fatalf("ReadFile(): can't read %s, %v", name, err)
+	}
+	err = ioutil.WriteFile(filepath.Join(to, target), content, 0666)
+	if err != nil {
+		t.Fatalf("WriteFile(): can't write %s, %v", target, err)
+	}
+}
+
+// exportTestGenerations выгружает в два каталога состояние до и после пополнения счёта.
+func exportTestGenerations(t *testing.T) (string, string, *types.Account) {
+	t.Helper()
+	service := &Service{}
+	account, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	first := t.TempDir()
+	err = service.Export(first)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+	exported := *account
+	err = service.Deposit(account.ID, 500)
+	if err != nil {
+		t.Fatalf("Deposit(): can't deposit, %v", err)
+	}
+	second := t.TempDir()
+	err = service.Export(second)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+	return first, second, &exported
+}
+
+func TestService_Export_manifest(t *testing.T) {
+	dir, _, _ := exportTestGenerations(t)
+
+	entries, err := readManifest(dir)
+	if err != nil {
+		t.Fatalf("readManifest(): can't read manifest, %v", err)
+	}
+	records := map[string]int{}
+	for _, entry := range entries {
+		records[entry.name] = entry.records
+	}
+	if len(records) != 3 || records[accountsDump] != 1 || records[paymentsDump] != len(defaultExampleTestAccount.payments) {
+		t.Errorf("Export(): wrong manifest %v", entries)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(matches) != 0 {
+		t.Errorf("Export(): temporary files must be removed, found %v", matches)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, paymentsDump), []byte(formatDumpHeader(entityPayments)), 0666)
+	if err != nil {
+		t.Fatalf("WriteFile(): can't truncate payments, %v", err)
+	}
+	err = (&Service{}).Import(dir)
+	if !errors.Is(err, ErrManifestMismatch) {
+		t.Errorf("Import(): must return ErrManifestMismatch, returned %v", err)
+	}
+}
+
+func TestService_Import_committedGeneration(t *testing.T) {
+	dir, second, exported := exportTestGenerations(t)
+	// сбой после фиксации манифеста: файлы нового поколения остались временными
+	copyDump(t, second, dir, manifestDump, manifestDump)
+	copyDump(t, second, dir, accountsDump, accountsDump+".tmp")
+	copyDump(t, second, dir, postingsDump, postingsDump+".tmp")
+
+	service := &Service{}
+	err := service.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	account, err := service.FindAccountByID(exported.ID)
+	if err != nil || account.Balance != exported.Balance+500 {
+		t.Errorf("Import(): want account from the new generation, result %v, %v", account, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, accountsDump+".tmp")); err != nil {
+		t.Errorf("Import(): directory must not be modified, %v", err)
+	}
+
+	// замену файлов доводит до конца следующая выгрузка
+	err = service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(matches) != 0 {
+		t.Errorf("Export(): temporary files must be removed, found %v", matches)
+	}
+}
+
+func TestService_Import_uncommittedGeneration(t *testing.T) {
+	dir, second, exported := exportTestGenerations(t)
+	// сбой до фиксации манифеста: остаётся прежнее поколение
+	copyDump(t, second, dir, accountsDump, accountsDump+".tmp")
+	copyDump(t, second, dir, manifestDump, manifestDump+".tmp")
+
+	service := &Service{}
+	err := service.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	account, err := service.FindAccountByID(exported.ID)
+	if err != nil || account.Balance != exported.Balance {
+		t.Errorf("Import(): want account from the old generation, result %v, %v", account, err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(matches) != 2 {
+		t.Errorf("Import(): directory must not be modified, found %v", matches)
+	}
+
+	err = service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+	matches, _ = filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(matches) != 0 {
+		t.Errorf("Export(): temporary files must be removed, found %v", matches)
+	}
+}
+
+func TestService_Export_concurrent(t *testing.T) {
+	dir := t.TempDir()
+	services := []*Service{}
+	for _, data := range []testExampleAccount{defaultExampleTestAccount, defaultExampleTestAccount2} {
+		service := &Service{}
+		_, _, err := service.addAccount(data)
+		if err != nil {
+			t.Fatalf("addAccount(): can't add account, %v", err)
+		}
+		services = append(services, service)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		service := services[i%len(services)]
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := service.Export(dir); err != nil {
+				t.Errorf("Export(): can't export, %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			// импорт видит каталог либо до, либо после выгрузки, но не посередине
+			err := (&Service{}).Import(dir)
+			if err != nil && !os.IsNotExist(err) {
+				t.Errorf("Import(): can't import, %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	err := (&Service{}).Import(dir)
+	if err != nil {
+		t.Errorf("Import(): can't import after concurrent exports, %v", err)
+	}
+	exportLocks.Lock()
+	locks := len(exportLocks.dirs)
+	exportLocks.Unlock()
+	if locks != 0 {
+		t.Errorf("Export(): released directory locks must be removed, %d left", locks)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(matches) != 0 {
+		t.Errorf("Export(): temporary files must be removed, found %v", matches)
+	}
+}
+
+func TestService_Export_empty(t *testing.T) {
+	dir, _, exported := exportTestGenerations(t)
+
+	err := (&Service{}).Export(dir)
+	if err != ErrNothingToExport {
+		t.Errorf("Export(): must return ErrNothingToExport, returned %v", err)
+	}
+	service := &Service{}
+	err = service.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): previous generation must stay, %v", err)
+	}
+	if _, err := service.FindAccountByID(exported.ID); err != nil {
+		t.Errorf("Import(): can't find account of the previous generation, %v", err)
+	}
+}
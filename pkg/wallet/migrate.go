@@ -0,0 +1,207 @@
+package wallet
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dumpMigration перечитывает файл from с записями вида entity и записывает их в файл to в текущей версии формата.
+type dumpMigration struct {
+	entity  string
+	migrate func(from string, to string) error
+}
+
+var dumpMigrations = []dumpMigration{
+	{entityAccounts, func(from string, to string) error {
+		accounts, err := readAccounts(from, nil)
+		if err != nil {
+			return err
+		}
+		return writeAccounts(to, accounts)
+	}},
+	{entityPayments, func(from string, to string) error {
+		payments, err := readPayments(from, nil)
+		if err != nil {
+			return err
+		}
+		return writePayments(to, payments)
+	}},
+	{entityFavorites, func(from string, to string) error {
+		favorites, err := readFavorites(from, nil)
+		if err != nil {
+			return err
+		}
+		return writeFavorites(to, favorites)
+	}},
+	{entityPostings, func(from string, to string) error {
+		postings, err := readPostings(from, nil)
+		if err != nil {
+			return err
+		}
+		return writePostings(to, postings)
+	}},
+	{entityIdempotency, func(from string, to string) error {
+		keys, err := readIdempotencyKeys(from, nil)
+		if err != nil {
+			return err
+		}
+		return writeIdempotencyKeys(to, keys)
+	}},
+	{entitySchedules, func(from string, to string) error {
+		schedules, err := readSchedules(from, nil)
+		if err != nil {
+			return err
+		}
+		return writeSchedules(to, schedules)
+	}},
+}
+
+// MigrateDir обновляет до текущей версии формата файлы выгрузки в каталоге dir во всех форматах,
+// несжатые и сжатые (в том числе шарды paymentsN.dump, записанные HistoryToFiles).
+// Файлы текущей версии не изменяются, остальные заменяются атомарно (см. replaceFile).
+// Если обновлённые файлы перечислены в манифесте или в индексе истории (history.index),
+// манифест и индекс записываются заново.
+// Возвращает имена обновлённых файлов.
+func MigrateDir(dir string) (migrated []string, err error) {
+	unlock, err := lockExportDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	entries, err := readManifest(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	listed := make(map[string]int)
+	for i, entry := range entries {
+		listed[entry.name] = i
+	}
+	shards, err := readHistoryIndex(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	sharded := make(map[string]int)
+	for i, shard := range shards {
+		sharded[shard.name] = i
+	}
+	refresh, refreshHistory := false, false
+	defer func() {
+		// файлы, обновлённые до ошибки, уже заменены, поэтому манифест и индекс записываются и после неё
+		if refresh {
+			if merr := writeManifest(dir, entries); merr != nil && err == nil {
+				err = merr
+			}
+		}
+		if refreshHistory {
+			if herr := writeHistoryIndex(dir, shards); herr != nil && err == nil {
+				err = herr
+			}
+		}
+	}()
+
+	migrated = []string{}
+	for _, migration := range dumpMigrations {
+		files, err := entityFiles(dir, migration.entity)
+		if err != nil {
+			return migrated, err
+		}
+
+		for _, path := range files {
+			current, err := isCurrentVersion(path, migration.entity)
+			if err != nil {
+				return migrated, err
+			}
+			if current {
+				continue
+			}
+			name := filepath.Base(path)
+			i, ok := listed[name]
+			if ok {
+				// для повреждённого файла нельзя пересчитывать манифест
+				err = verifyEntry(path, entries[i])
+				if err != nil {
+					return migrated, err
+				}
+			}
+			j, isShard := sharded[name]
+			if isShard {
+				err = verifyShard(path, shards[j])
+				if err != nil {
+					return migrated, err
+				}
+			}
+			// неизвестную версию migrate не прочитает и вернёт ErrUnsupportedDumpVersion
+			err = replaceFile(path, func(tmp string) error {
+				return migration.migrate(path, tmp)
+			})
+			if err != nil {
+				return migrated, err
+			}
+			migrated = append(migrated, name)
+
+			if ok {
+				entries[i].checksum, entries[i].records, err = fileChecksum(path)
+				if err != nil {
+					return migrated, err
+				}
+				refresh = true
+			}
+			if isShard {
+				shards[j].checksum, _, err = fileChecksum(path)
+				if err != nil {
+					return migrated, err
+				}
+				refreshHistory = true
+			}
+		}
+	}
+	return migrated, nil
+}
+
+// isCurrentVersion сообщает, записан ли файл path с записями вида entity в текущей версии формата.
+// В CSV и JSON Lines версии нет: поля в них названы, и эти форматы всегда записываются в текущей версии.
+func isCurrentVersion(path string, entity string) (bool, error) {
+	if _, ok := tableFormat(path); ok {
+		return true, nil
+	}
+	version, err := dumpFileVersion(path, entity)
+	if err != nil {
+		return false, err
+	}
+	return version == dumpVersion, nil
+}
+
+// entityFiles возвращает файлы выгрузки записей вида entity из каталога dir и их шарды
+// (например payments.dump, payments1.csv, payments2.jsonl.gz), отсортированные по имени.
+func entityFiles(dir string, entity string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, entity+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, match := range matches {
+		name := uncompressedName(filepath.Base(match))
+		format, err := FormatOf(name)
+		if err != nil {
+			continue
+		}
+		suffix := strings.TrimSuffix(strings.TrimPrefix(name, entity), format.Extension())
+		if _, err := strconv.Atoi(suffix); suffix != "" && err != nil {
+			continue
+		}
+		info, err := os.Stat(match)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, match)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,298 @@
+package wallet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/akhrorov/wallet/pkg/types"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666)
+		if err != nil {
+			t.Fatalf("WriteFile(): can't write %s, %v", name, err)
+		}
+	}
+}
+
+func TestService_Export_header(t *testing.T) {
+	dir := t.TempDir()
+	service := &Service{}
+	_, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	err = service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+
+	for name, entity := range map[string]string{accountsDump: entityAccounts, paymentsDump: entityPayments, postingsDump: entityPostings} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("ReadFile(): can't read %s, %v", name, err)
+		}
+		if !strings.HasPrefix(string(content), formatDumpHeader(entity)) {
+			t.Errorf("Export(): %s must start with header, content %q", name, content)
+		}
+	}
+}
+
+func TestMigrateDir(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		accountsDump:     "1;+992000000001;900\n",
+		paymentsDump:     "p1;100;auto;1;INPROGRESS\n",
+		"payments1.dump": "p2;50;auto;1;OK\n",
+		favoritesDump:    "f1;100;auto;1;my;car\n",
+		"accounts1.csv":  "id,phone,balance\n",
+	})
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, _ = gz.Write([]byte("p3;25;auto;1;OK\n"))
+	_ = gz.Close()
+	err := ioutil.WriteFile(filepath.Join(dir, "payments2.dump.gz"), compressed.Bytes(), 0666)
+	if err != nil {
+		t.Fatalf("WriteFile(): can't write payments2.dump.gz, %v", err)
+	}
+	before := &Service{}
+	err = before.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import version 1, %v", err)
+	}
+
+	migrated, err := MigrateDir(dir)
+	if err != nil {
+		t.Fatalf("MigrateDir(): can't migrate, %v", err)
+	}
+	want := []string{accountsDump, paymentsDump, "payments1.dump", "payments2.dump.gz", favoritesDump}
+	if !reflect.DeepEqual(migrated, want) {
+		t.Errorf("MigrateDir(): want %v, result %v", want, migrated)
+	}
+	for _, name := range []string{"payments1.dump", "payments2.dump.gz"} {
+		version, err := dumpFileVersion(filepath.Join(dir, name), entityPayments)
+		if err != nil || version != dumpVersion {
+			t.Errorf("MigrateDir(): want %s version %d, result %d, %v", name, dumpVersion, version, err)
+		}
+	}
+	if !isGzip(t, filepath.Join(dir, "payments2.dump.gz")) {
+		t.Error("MigrateDir(): payments2.dump.gz must stay compressed")
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(matches) != 0 {
+		t.Errorf("MigrateDir(): temporary files must be renamed, found %v", matches)
+	}
+
+	after := &Service{}
+	err = after.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import migrated files, %v", err)
+	}
+	wantPayment, _ := before.FindPaymentByID("p1")
+	payment, err := after.FindPaymentByID("p1")
+	if err != nil || !reflect.DeepEqual(payment, wantPayment) {
+		t.Errorf("MigrateDir(): want payment %v, result %v, %v", wantPayment, payment, err)
+	}
+	favorite, err := after.FindFavoriteByID("f1")
+	if err != nil || favorite.Name != "my;car" {
+		t.Errorf("MigrateDir(): wrong favorite %v, %v", favorite, err)
+	}
+
+	migrated, err = MigrateDir(dir)
+	if err != nil || len(migrated) != 0 {
+		t.Errorf("MigrateDir(): up to date files must not be migrated, result %v, %v", migrated, err)
+	}
+}
+
+func TestMigrateDir_manifest(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		accountsDump: "1;+992000000001;900\n",
+		paymentsDump: "p1;100;auto;1;INPROGRESS\n",
+	})
+	entries := []manifestEntry{}
+	for _, name := range []string{accountsDump, paymentsDump} {
+		checksum, records, err := fileChecksum(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("fileChecksum(): can't checksum %s, %v", name, err)
+		}
+		entries = append(entries, manifestEntry{name: name, records: records, checksum: checksum})
+	}
+	err := writeManifest(dir, entries)
+	if err != nil {
+		t.Fatalf("writeManifest(): can't write manifest, %v", err)
+	}
+
+	migrated, err := MigrateDir(dir)
+	if err != nil || len(migrated) != 2 {
+		t.Fatalf("MigrateDir(): want 2 migrated files, result %v, %v", migrated, err)
+	}
+	service := &Service{}
+	err = service.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): manifest must match migrated files, %v", err)
+	}
+	if _, err := service.FindPaymentByID("p1"); err != nil {
+		t.Errorf("Import(): can't find payment, %v", err)
+	}
+
+	// файл, не совпадающий с манифестом, не обновляется
+	writeTestFiles(t, dir, map[string]string{accountsDump: "1;+992000000001;1000\n"})
+	_, err = MigrateDir(dir)
+	if !errors.Is(err, ErrManifestMismatch) {
+		t.Errorf("MigrateDir(): must return ErrManifestMismatch, returned %v", err)
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(dir, accountsDump))
+	if string(content) != "1;+992000000001;1000\n" {
+		t.Errorf("MigrateDir(): mismatched file must not be rewritten, content %q", content)
+	}
+}
+
+func TestMigrateDir_history(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"payments1.dump": "p1;100;auto;1;OK\n",
+		"payments2.dump": "p2;50;auto;1;OK\np3;25;auto;1;FAIL\n",
+	})
+	created := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	shards := []historyShard{
+		{name: "payments1.dump", first: 0, records: 1, from: created, to: created},
+		{name: "payments2.dump", first: 1, records: 2, from: created, to: created},
+	}
+	for i := range shards {
+		checksum, _, err := fileChecksum(filepath.Join(dir, shards[i].name))
+		if err != nil {
+			t.Fatalf("fileChecksum(): can't checksum %s, %v", shards[i].name, err)
+		}
+		shards[i].checksum = checksum
+	}
+	err := writeHistoryIndex(dir, shards)
+	if err != nil {
+		t.Fatalf("writeHistoryIndex(): can't write index, %v", err)
+	}
+	before, err := ReadHistory(dir)
+	if err != nil {
+		t.Fatalf("ReadHistory(): can't read version 1 shards, %v", err)
+	}
+
+	migrated, err := MigrateDir(dir)
+	if err != nil || len(migrated) != 2 {
+		t.Fatalf("MigrateDir(): want 2 migrated shards, result %v, %v", migrated, err)
+	}
+	after, err := ReadHistory(dir)
+	if err != nil {
+		t.Fatalf("ReadHistory(): index must match migrated shards, %v", err)
+	}
+	if !reflect.DeepEqual(after, before) {
+		t.Errorf("ReadHistory(): want %v, result %v", before, after)
+	}
+	service := &Service{}
+	err = service.ImportHistory(dir)
+	if err != nil {
+		t.Fatalf("ImportHistory(): can't import migrated shards, %v", err)
+	}
+	if _, err := service.FindPaymentByID("p3"); err != nil {
+		t.Errorf("ImportHistory(): can't find payment, %v", err)
+	}
+
+	// шард, не совпадающий с индексом, не обновляется
+	writeTestFiles(t, dir, map[string]string{"payments1.dump": "p1;200;auto;1;OK\n"})
+	_, err = MigrateDir(dir)
+	if !errors.Is(err, ErrHistoryMismatch) {
+		t.Errorf("MigrateDir(): must return ErrHistoryMismatch, returned %v", err)
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "payments1.dump"))
+	if string(content) != "p1;200;auto;1;OK\n" {
+		t.Errorf("MigrateDir(): mismatched shard must not be rewritten, content %q", content)
+	}
+}
+
+func TestImport_unsupportedVersion(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		accountsDump: "#wallet;accounts;99\n1;+992000000001;900\n",
+	})
+
+	err := (&Service{}).Import(dir)
+	if !errors.Is(err, ErrUnsupportedDumpVersion) {
+		t.Errorf("Import(): must return ErrUnsupportedDumpVersion, returned %v", err)
+	}
+	_, err = MigrateDir(dir)
+	if !errors.Is(err, ErrUnsupportedDumpVersion) {
+		t.Errorf("MigrateDir(): must return ErrUnsupportedDumpVersion, returned %v", err)
+	}
+}
+
+func TestImport_wrongEntity(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		accountsDump: "#wallet;payments;2\np1;100;auto;1;INPROGRESS\n",
+	})
+
+	err := (&Service{}).Import(dir)
+	if !errors.Is(err, ErrInvalidDumpHeader) {
+		t.Errorf("Import(): must return ErrInvalidDumpHeader, returned %v", err)
+	}
+}
+
+func TestService_ImportFromFile_versions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+	writeTestFiles(t, filepath.Dir(path), map[string]string{
+		"accounts.txt": "1;+992000000001;900|#wallet;accounts;2|2;+992000000002;100;0;0;USD;BLOCKED|",
+	})
+
+	service := &Service{}
+	err := service.ImportFromFile(path)
+	if err != nil {
+		t.Fatalf("ImportFromFile(): can't import, %v", err)
+	}
+	account, err := service.FindAccountByID(2)
+	if err != nil || account.Currency != "USD" || account.Status != "BLOCKED" {
+		t.Errorf("ImportFromFile(): wrong account %v, %v", account, err)
+	}
+}
+
+func TestService_OpenJournal_upgradesVersion(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wallet.journal")
+	service := &Service{}
+	account, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	// журнал версии 1 - записи без заголовка
+	entry := journalEntry{op: opRegisterAccount, accounts: []*types.Account{account}}
+	writeTestFiles(t, dir, map[string]string{"wallet.journal": entry.encode()})
+
+	restored := &Service{}
+	err = restored.OpenJournal(path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't open journal version 1, %v", err)
+	}
+	defer restored.CloseJournal()
+	_, err = restored.FindAccountByID(account.ID)
+	if err != nil {
+		t.Errorf("OpenJournal(): account must be replayed, %v", err)
+	}
+	err = restored.Deposit(account.ID, 1_00)
+	if err != nil {
+		t.Fatalf("Deposit(): can't deposit, %v", err)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(): can't read journal, %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(lines) != 3 || lines[0]+"\n" != formatDumpHeader(entityJournal) {
+		t.Errorf("OpenJournal(): journal must be upgraded, content %q", content)
+	}
+}
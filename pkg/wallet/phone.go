@@ -0,0 +1,23 @@
+package wallet
+
+import (
+	"github.com/akhrorov/wallet/pkg/phone"
+	"github.com/akhrorov/wallet/pkg/types"
+	"strings"
+)
+
+// normalizePhone приводит номер к каноническому виду E.164, в котором номера хранятся в счетах.
+// Для неверного номера возвращает ошибку, обёртывающую phone.ErrInvalidPhone или phone.ErrUnsupportedCountry.
+func normalizePhone(number types.Phone) (types.Phone, error) {
+	return phone.Normalize(string(number))
+}
+
+// normalizeStoredPhone приводит к каноническому виду номер из выгрузки. Выгрузки, записанные до нормализации номеров,
+// могут хранить номер как его ввели, в том числе без "+", например "992 900 00 00 01": такой номер считается международным.
+func normalizeStoredPhone(number types.Phone) (types.Phone, error) {
+	value := strings.TrimSpace(string(number))
+	if !strings.HasPrefix(value, "+") && !strings.HasPrefix(value, "00") {
+		value = "+" + value
+	}
+	return normalizePhone(types.Phone(value))
+}
@@ -0,0 +1,118 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/akhrorov/wallet/pkg/phone"
+	"github.com/akhrorov/wallet/pkg/types"
+	"path/filepath"
+	"testing"
+)
+
+func TestService_RegisterAccount_normalizesPhone(t *testing.T) {
+	service := &Service{}
+	account, err := service.RegisterAccount("+992 900-00-00-01")
+	if err != nil {
+		t.Fatalf("RegisterAccount(): can't register account, %v", err)
+	}
+	if account.Phone != "+992900000001" {
+		t.Errorf("RegisterAccount(): want phone +992900000001, result %q", account.Phone)
+	}
+
+	_, err = service.RegisterAccount("00992900000001")
+	if err != ErrPhoneRegistered {
+		t.Errorf("RegisterAccount(): must return ErrPhoneRegistered, returned %v", err)
+	}
+	_, err = service.RegisterAccount("900000001")
+	if !errors.Is(err, phone.ErrInvalidPhone) {
+		t.Errorf("RegisterAccount(): must return ErrInvalidPhone, returned %v", err)
+	}
+	_, err = service.RegisterAccount("+1 202 555 0100")
+	if !errors.Is(err, phone.ErrUnsupportedCountry) {
+		t.Errorf("RegisterAccount(): must return ErrUnsupportedCountry, returned %v", err)
+	}
+}
+
+func TestService_FindAccountByPhone(t *testing.T) {
+	service := &Service{}
+	account, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+
+	for _, number := range []types.Phone{"+992900000001", "+992 (90) 000-00-01", "00 992 900 00 00 01"} {
+		found, err := service.FindAccountByPhone(number)
+		if err != nil || found != account {
+			t.Errorf("FindAccountByPhone(%q): want %v, result %v, %v", number, account, found, err)
+		}
+	}
+	_, err = service.FindAccountByPhone("+992900000009")
+	if err != ErrAccountNotFound {
+		t.Errorf("FindAccountByPhone(): must return ErrAccountNotFound, returned %v", err)
+	}
+	_, err = service.FindAccountByPhone("not a phone")
+	if !errors.Is(err, phone.ErrInvalidPhone) {
+		t.Errorf("FindAccountByPhone(): must return ErrInvalidPhone, returned %v", err)
+	}
+}
+
+func TestService_Import_normalizesPhone(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"accounts.dump": formatDumpHeader(entityAccounts) + "1;992 900 00 00 01;100;0;0;TJS;ACTIVE\n",
+		"accounts.txt":  "2;992 900 00 00 02;100|",
+	})
+	unnormalized := NewMemoryRepository()
+	err := unnormalized.SaveAccount(&types.Account{ID: 3, Phone: "992 900 00 00 03", Currency: types.DefaultCurrency, Status: types.AccountStatusActive})
+	if err != nil {
+		t.Fatalf("SaveAccount(): can't save account, %v", err)
+	}
+	err = NewService(unnormalized).ExportSnapshot(filepath.Join(dir, "wallet.snapshot"))
+	if err != nil {
+		t.Fatalf("ExportSnapshot(): can't export, %v", err)
+	}
+
+	imports := map[string]func(service *Service) error{
+		"Import":         func(service *Service) error { return service.Import(dir) },
+		"ImportFromFile": func(service *Service) error { return service.ImportFromFile(filepath.Join(dir, "accounts.txt")) },
+		"ImportSnapshot": func(service *Service) error { return service.ImportSnapshot(filepath.Join(dir, "wallet.snapshot")) },
+	}
+	phones := map[string]types.Phone{"Import": "+992900000001", "ImportFromFile": "+992900000002", "ImportSnapshot": "+992900000003"}
+	for name, importFn := range imports {
+		service := &Service{}
+		err := importFn(service)
+		if err != nil {
+			t.Fatalf("%s(): can't import, %v", name, err)
+		}
+		_, err = service.RegisterAccount(phones[name])
+		if err != ErrPhoneRegistered {
+			t.Errorf("%s(): RegisterAccount() must return ErrPhoneRegistered, returned %v", name, err)
+		}
+		account, err := service.FindAccountByPhone(phones[name])
+		if err != nil || account.Phone != phones[name] {
+			t.Errorf("%s(): FindAccountByPhone() must find imported account, result %v, %v", name, account, err)
+		}
+	}
+}
+
+func TestService_Import_invalidPhone(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"accounts.dump": formatDumpHeader(entityAccounts) + "1;+992900000001;100;0;0;TJS;ACTIVE\n2;not a phone;100;0;0;TJS;ACTIVE\n",
+	})
+
+	var parseErr *ParseError
+	err := (&Service{}).Import(dir)
+	if !errors.As(err, &parseErr) || parseErr.Line != 3 || parseErr.Field != "phone" || !errors.Is(err, phone.ErrInvalidPhone) {
+		t.Errorf("Import(): must return ParseError for phone on line 3, returned %v", err)
+	}
+
+	service := &Service{}
+	report, err := service.ImportLenient(dir)
+	if err != nil || len(report.Skipped) != 1 {
+		t.Fatalf("ImportLenient(): must skip account with invalid phone, result %v, %v", report, err)
+	}
+	_, err = service.FindAccountByID(1)
+	if err != nil {
+		t.Errorf("ImportLenient(): account with valid phone must be imported, %v", err)
+	}
+}
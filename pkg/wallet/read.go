@@ -0,0 +1,69 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/akhrorov/wallet/pkg/types"
+)
+
+// errStopReading возвращается из handle, чтобы readDump прекратил чтение без ошибки.
+var errStopReading = errors.New("stop reading")
+
+// DumpFiles возвращает файлы выгрузки с записями вида entity ("accounts", "payments", "favorites" и т.д.) в каталоге dir:
+// основной файл и шарды entityN в любом формате (см. FormatOf), в том числе сжатые.
+func DumpFiles(dir string, entity string) ([]string, error) {
+	_, err := columnsFor(entity)
+	if err != nil {
+		return nil, err
+	}
+	return entityFiles(dir, entity)
+}
+
+// ReadAccounts читает счета из файла выгрузки path так же, как Import: в любой версии, формате и со сжатием,
+// и вызывает handle для каждого. Если handle возвращает false, чтение прекращается.
+// Записи, которые не удалось разобрать, пропускаются и возвращаются в отчёте.
+func ReadAccounts(path string, handle func(account *types.Account) bool) (*ImportReport, error) {
+	report := &ImportReport{}
+	err := readDump(path, entityAccounts, report, func(reader dumpReader, line string) error {
+		account, err := reader.account(line)
+		if err != nil {
+			return err
+		}
+		if !handle(account) {
+			return errStopReading
+		}
+		return nil
+	})
+	return report, err
+}
+
+// ReadPayments работает как ReadAccounts, но читает платежи.
+func ReadPayments(path string, handle func(payment *types.Payment) bool) (*ImportReport, error) {
+	report := &ImportReport{}
+	err := readDump(path, entityPayments, report, func(reader dumpReader, line string) error {
+		payment, err := reader.payment(line)
+		if err != nil {
+			return err
+		}
+		if !handle(payment) {
+			return errStopReading
+		}
+		return nil
+	})
+	return report, err
+}
+
+// ReadFavorites работает как ReadAccounts, но читает избранное.
+func ReadFavorites(path string, handle func(favorite *types.Favorite) bool) (*ImportReport, error) {
+	report := &ImportReport{}
+	err := readDump(path, entityFavorites, report, func(reader dumpReader, line string) error {
+		favorite, err := reader.favorite(line)
+		if err != nil {
+			return err
+		}
+		if !handle(favorite) {
+			return errStopReading
+		}
+		return nil
+	})
+	return report, err
+}
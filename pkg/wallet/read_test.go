@@ -0,0 +1,45 @@
+package wallet
+
+import (
+	"github.com/akhrorov/wallet/pkg/types"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadPayments(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"payments.dump":  formatDumpHeader(entityPayments) + "p1;100;auto;1;OK;;0;0;TJS\nbroken\n",
+		"payments1.csv":  "id,amount,category,accountID,status,linkedID,createdAt,updatedAt,currency\np2,200,food,1,OK,,,,TJS\np3,300,food,1,OK,,,,TJS\n",
+		"payments.index": "",
+	})
+
+	files, err := DumpFiles(dir, entityPayments)
+	want := []string{filepath.Join(dir, "payments.dump"), filepath.Join(dir, "payments1.csv")}
+	if err != nil || !reflect.DeepEqual(files, want) {
+		t.Fatalf("DumpFiles(): want %v, result %v, %v", want, files, err)
+	}
+
+	ids := []string{}
+	report, err := ReadPayments(files[0], func(payment *types.Payment) bool {
+		ids = append(ids, payment.ID)
+		return true
+	})
+	if err != nil || len(report.Skipped) != 1 || report.Skipped[0].Line != 3 {
+		t.Errorf("ReadPayments(): must skip broken record, result %v, %v", report, err)
+	}
+	// handle, вернувший false, прекращает чтение
+	_, err = ReadPayments(files[1], func(payment *types.Payment) bool {
+		ids = append(ids, payment.ID)
+		return false
+	})
+	if err != nil || !reflect.DeepEqual(ids, []string{"p1", "p2"}) {
+		t.Errorf("ReadPayments(): want p1, p2, result %v, %v", ids, err)
+	}
+
+	_, err = DumpFiles(dir, "unknown")
+	if err == nil {
+		t.Error("DumpFiles(): must return error for unknown entity")
+	}
+}
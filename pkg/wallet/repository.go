@@ -0,0 +1,414 @@
+package wallet
+
+import (
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/types"
+)
+
+// Repository описывает хранилище счетов, платежей, избранного, проводок, ключей идемпотентности и расписаний,
+// поверх которого работает Service.
+// Service вызывает методы Repository под своей блокировкой, поэтому реализациям
+// не нужно самим заботиться о синхронизации.
+// Save* добавляют новую запись или обновляют существующую с тем же ID.
+// Записи, которые возвращает Repository, не должны изменяться после возврата: Service отдаёт их вызывающим,
+// которые могут читать их без блокировки. Поэтому Save* сохраняют копию записи, а не изменяют прежнюю.
+type Repository interface {
+	Accounts() ([]*types.Account, error)
+	AccountByID(accountID int64) (*types.Account, error)
+	AccountByPhone(phone types.Phone) (*types.Account, error)
+	SaveAccount(account *types.Account) error
+
+	Payments() ([]*types.Payment, error)
+	PaymentByID(paymentID string) (*types.Payment, error)
+	PaymentsByAccount(accountID int64) ([]*types.Payment, error)
+	SavePayment(payment *types.Payment) error
+
+	Favorites() ([]*types.Favorite, error)
+	FavoriteByID(favoriteID string) (*types.Favorite, error)
+	FavoritesByAccount(accountID int64) ([]*types.Favorite, error)
+	SaveFavorite(favorite *types.Favorite) error
+	// DeleteFavorite удаляет элемент "Избранное", для несуществующего ничего не делает.
+	DeleteFavorite(favoriteID string) error
+
+	Postings() ([]*types.Posting, error)
+	SavePosting(posting *types.Posting) error
+
+	IdempotencyKeys() ([]*types.IdempotencyKey, error)
+	IdempotencyKey(key string) (*types.IdempotencyKey, error)
+	SaveIdempotencyKey(record *types.IdempotencyKey) error
+	// DeleteIdempotencyKey удаляет ключ идемпотентности, для несуществующего ничего не делает.
+	DeleteIdempotencyKey(key string) error
+
+	Schedules() ([]*types.Schedule, error)
+	ScheduleByID(scheduleID string) (*types.Schedule, error)
+	SaveSchedule(schedule *types.Schedule) error
+	// DeleteSchedule удаляет расписание, для несуществующего ничего не делает.
+	DeleteSchedule(scheduleID string) error
+}
+
+// entryRepository - хранилище, которое сохраняет все записи одного изменения (journalEntry) атомарно:
+// после сбоя в нём остаются либо все записи изменения, либо ни одной.
+type entryRepository interface {
+	saveEntry(entry journalEntry) error
+}
+
+// saveEntry сохраняет записи entry в repository по одной и удаляет удалённые им записи.
+func saveEntry(repository Repository, entry journalEntry) error {
+	for _, account := range entry.accounts {
+		err := repository.SaveAccount(account)
+		if err != nil {
+			return err
+		}
+	}
+	for _, payment := range entry.payments {
+		err := repository.SavePayment(payment)
+		if err != nil {
+			return err
+		}
+	}
+	for _, favorite := range entry.favorites {
+		err := repository.SaveFavorite(favorite)
+		if err != nil {
+			return err
+		}
+	}
+	for _, posting := range entry.postings {
+		err := repository.SavePosting(posting)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range entry.keys {
+		err := repository.SaveIdempotencyKey(key)
+		if err != nil {
+			return err
+		}
+	}
+	for _, schedule := range entry.schedules {
+		err := repository.SaveSchedule(schedule)
+		if err != nil {
+			return err
+		}
+	}
+	for _, record := range entry.deleted {
+		err := deleteRecord(repository, record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteRecord(repository Repository, record deletedRecord) error {
+	switch record.kind {
+	case kindFavorite:
+		return repository.DeleteFavorite(record.id)
+	case kindSchedule:
+		return repository.DeleteSchedule(record.id)
+	case kindIdempotencyKey:
+		return repository.DeleteIdempotencyKey(record.id)
+	default:
+		return fmt.Errorf("%w: unknown record kind %q", ErrJournalCorrupted, record.kind)
+	}
+}
+
+// MemoryRepository хранит все данные в памяти.
+type MemoryRepository struct {
+	accounts  []*types.Account
+	payments  []*types.Payment
+	favorites []*types.Favorite
+	postings  []*types.Posting
+	keys      []*types.IdempotencyKey
+	schedules []*types.Schedule
+
+	// индексы для поиска за O(1), обновляются вместе со срезами
+	accountsByID      map[int64]*types.Account
+	accountPositions  map[int64]int
+	accountsByPhone   map[types.Phone]*types.Account
+	paymentsByID      map[string]*types.Payment
+	paymentsByAccount map[int64][]*types.Payment
+	// paymentPositions - номер платежа в payments и в paymentsByAccount его счёта
+	paymentPositions   map[string][2]int
+	favoritesByID      map[string]*types.Favorite
+	favoritesByAccount map[int64][]*types.Favorite
+	postingsByID       map[string]*types.Posting
+	keysByKey          map[string]*types.IdempotencyKey
+	keyPositions       map[string]int
+	schedulesByID      map[string]*types.Schedule
+}
+
+// NewMemoryRepository создаёт пустое хранилище в памяти.
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		accountsByID:       make(map[int64]*types.Account),
+		accountPositions:   make(map[int64]int),
+		accountsByPhone:    make(map[types.Phone]*types.Account),
+		paymentsByID:       make(map[string]*types.Payment),
+		paymentsByAccount:  make(map[int64][]*types.Payment),
+		paymentPositions:   make(map[string][2]int),
+		favoritesByID:      make(map[string]*types.Favorite),
+		favoritesByAccount: make(map[int64][]*types.Favorite),
+		postingsByID:       make(map[string]*types.Posting),
+		keysByKey:          make(map[string]*types.IdempotencyKey),
+		keyPositions:       make(map[string]int),
+		schedulesByID:      make(map[string]*types.Schedule),
+	}
+}
+
+func (r *MemoryRepository) Accounts() ([]*types.Account, error) {
+	accounts := make([]*types.Account, len(r.accounts))
+	copy(accounts, r.accounts)
+	return accounts, nil
+}
+
+func (r *MemoryRepository) AccountByID(accountID int64) (*types.Account, error) {
+	account, ok := r.accountsByID[accountID]
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+
+	return account, nil
+}
+
+func (r *MemoryRepository) AccountByPhone(phone types.Phone) (*types.Account, error) {
+	account, ok := r.accountsByPhone[phone]
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+
+	return account, nil
+}
+
+func (r *MemoryRepository) SaveAccount(account *types.Account) error {
+	saved := *account
+	existing, ok := r.accountsByID[account.ID]
+	if !ok {
+		r.accountPositions[account.ID] = len(r.accounts)
+		r.accounts = append(r.accounts, &saved)
+	} else {
+		if existing.Phone != account.Phone {
+			delete(r.accountsByPhone, existing.Phone)
+		}
+		r.accounts[r.accountPositions[account.ID]] = &saved
+	}
+	r.accountsByID[account.ID] = &saved
+	r.accountsByPhone[account.Phone] = &saved
+	return nil
+}
+
+func (r *MemoryRepository) Payments() ([]*types.Payment, error) {
+	payments := make([]*types.Payment, len(r.payments))
+	copy(payments, r.payments)
+	return payments, nil
+}
+
+func (r *MemoryRepository) PaymentByID(paymentID string) (*types.Payment, error) {
+	payment, ok := r.paymentsByID[paymentID]
+	if !ok {
+		return nil, ErrPaymentNotFound
+	}
+
+	return payment, nil
+}
+
+func (r *MemoryRepository) PaymentsByAccount(accountID int64) ([]*types.Payment, error) {
+	payments := make([]*types.Payment, len(r.paymentsByAccount[accountID]))
+	copy(payments, r.paymentsByAccount[accountID])
+	return payments, nil
+}
+
+func (r *MemoryRepository) SavePayment(payment *types.Payment) error {
+	saved := *payment
+	existing, ok := r.paymentsByID[payment.ID]
+	if !ok {
+		r.paymentPositions[payment.ID] = [2]int{len(r.payments), len(r.paymentsByAccount[payment.AccountID])}
+		r.payments = append(r.payments, &saved)
+		r.paymentsByAccount[payment.AccountID] = append(r.paymentsByAccount[payment.AccountID], &saved)
+	} else {
+		// платёж не переходит на другой счёт, поэтому остаётся в списке прежнего
+		saved.AccountID = existing.AccountID
+		positions := r.paymentPositions[payment.ID]
+		r.payments[positions[0]] = &saved
+		r.paymentsByAccount[saved.AccountID][positions[1]] = &saved
+	}
+	r.paymentsByID[payment.ID] = &saved
+	return nil
+}
+
+func (r *MemoryRepository) Favorites() ([]*types.Favorite, error) {
+	favorites := make([]*types.Favorite, len(r.favorites))
+	copy(favorites, r.favorites)
+	return favorites, nil
+}
+
+func (r *MemoryRepository) FavoriteByID(favoriteID string) (*types.Favorite, error) {
+	favorite, ok := r.favoritesByID[favoriteID]
+	if !ok {
+		return nil, ErrFavoriteNotFound
+	}
+
+	return favorite, nil
+}
+
+func (r *MemoryRepository) FavoritesByAccount(accountID int64) ([]*types.Favorite, error) {
+	favorites := make([]*types.Favorite, len(r.favoritesByAccount[accountID]))
+	copy(favorites, r.favoritesByAccount[accountID])
+	return favorites, nil
+}
+
+func (r *MemoryRepository) SaveFavorite(favorite *types.Favorite) error {
+	saved := *favorite
+	existing, ok := r.favoritesByID[favorite.ID]
+	if !ok {
+		r.favorites = append(r.favorites, &saved)
+		r.favoritesByAccount[favorite.AccountID] = append(r.favoritesByAccount[favorite.AccountID], &saved)
+	} else {
+		// избранного немного и его можно удалять, поэтому место в срезах ищется перебором
+		saved.AccountID = existing.AccountID
+		replaceFavorite(r.favorites, &saved)
+		replaceFavorite(r.favoritesByAccount[saved.AccountID], &saved)
+	}
+	r.favoritesByID[favorite.ID] = &saved
+	return nil
+}
+
+func replaceFavorite(favorites []*types.Favorite, favorite *types.Favorite) {
+	for i := range favorites {
+		if favorites[i].ID == favorite.ID {
+			favorites[i] = favorite
+			return
+		}
+	}
+}
+
+func (r *MemoryRepository) DeleteFavorite(favoriteID string) error {
+	favorite, ok := r.favoritesByID[favoriteID]
+	if !ok {
+		return nil
+	}
+
+	delete(r.favoritesByID, favoriteID)
+	r.favorites = removeFavorite(r.favorites, favoriteID)
+	r.favoritesByAccount[favorite.AccountID] = removeFavorite(r.favoritesByAccount[favorite.AccountID], favoriteID)
+	if len(r.favoritesByAccount[favorite.AccountID]) == 0 {
+		delete(r.favoritesByAccount, favorite.AccountID)
+	}
+	return nil
+}
+
+func removeFavorite(favorites []*types.Favorite, favoriteID string) []*types.Favorite {
+	for i, favorite := range favorites {
+		if favorite.ID == favoriteID {
+			return append(favorites[:i], favorites[i+1:]...)
+		}
+	}
+	return favorites
+}
+
+func (r *MemoryRepository) Postings() ([]*types.Posting, error) {
+	postings := make([]*types.Posting, len(r.postings))
+	copy(postings, r.postings)
+	return postings, nil
+}
+
+// SavePosting добавляет проводку. Проводки не изменяются, поэтому повторное сохранение игнорируется.
+func (r *MemoryRepository) SavePosting(posting *types.Posting) error {
+	if _, ok := r.postingsByID[posting.ID]; ok {
+		return nil
+	}
+
+	saved := *posting
+	r.postings = append(r.postings, &saved)
+	r.postingsByID[posting.ID] = &saved
+	return nil
+}
+
+func (r *MemoryRepository) IdempotencyKeys() ([]*types.IdempotencyKey, error) {
+	keys := make([]*types.IdempotencyKey, len(r.keys))
+	copy(keys, r.keys)
+	return keys, nil
+}
+
+func (r *MemoryRepository) IdempotencyKey(key string) (*types.IdempotencyKey, error) {
+	record, ok := r.keysByKey[key]
+	if !ok {
+		return nil, ErrIdempotencyKeyNotFound
+	}
+
+	return record, nil
+}
+
+func (r *MemoryRepository) SaveIdempotencyKey(record *types.IdempotencyKey) error {
+	saved := *record
+	position, ok := r.keyPositions[record.Key]
+	if !ok {
+		r.keyPositions[record.Key] = len(r.keys)
+		r.keys = append(r.keys, &saved)
+	} else {
+		r.keys[position] = &saved
+	}
+	r.keysByKey[record.Key] = &saved
+	return nil
+}
+
+func (r *MemoryRepository) DeleteIdempotencyKey(key string) error {
+	position, ok := r.keyPositions[key]
+	if !ok {
+		return nil
+	}
+
+	delete(r.keysByKey, key)
+	delete(r.keyPositions, key)
+	r.keys = append(r.keys[:position], r.keys[position+1:]...)
+	for i := position; i < len(r.keys); i++ {
+		r.keyPositions[r.keys[i].Key] = i
+	}
+	return nil
+}
+
+func (r *MemoryRepository) Schedules() ([]*types.Schedule, error) {
+	schedules := make([]*types.Schedule, len(r.schedules))
+	copy(schedules, r.schedules)
+	return schedules, nil
+}
+
+func (r *MemoryRepository) ScheduleByID(scheduleID string) (*types.Schedule, error) {
+	schedule, ok := r.schedulesByID[scheduleID]
+	if !ok {
+		return nil, ErrScheduleNotFound
+	}
+
+	return schedule, nil
+}
+
+func (r *MemoryRepository) SaveSchedule(schedule *types.Schedule) error {
+	saved := *schedule
+	if _, ok := r.schedulesByID[schedule.ID]; !ok {
+		r.schedules = append(r.schedules, &saved)
+	} else {
+		// расписания можно удалять, поэтому место в срезе ищется перебором, как в DeleteSchedule
+		for i := range r.schedules {
+			if r.schedules[i].ID == schedule.ID {
+				r.schedules[i] = &saved
+				break
+			}
+		}
+	}
+	r.schedulesByID[schedule.ID] = &saved
+	return nil
+}
+
+func (r *MemoryRepository) DeleteSchedule(scheduleID string) error {
+	if _, ok := r.schedulesByID[scheduleID]; !ok {
+		return nil
+	}
+
+	delete(r.schedulesByID, scheduleID)
+	for i, schedule := range r.schedules {
+		if schedule.ID == scheduleID {
+			r.schedules = append(r.schedules[:i], r.schedules[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
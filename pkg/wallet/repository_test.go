@@ -0,0 +1,208 @@
+package wallet
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewService_memoryRepository(t *testing.T) {
+	service := NewService(NewMemoryRepository())
+
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+
+	got, err := service.FindPaymentByID(payments[0].ID)
+	if err != nil {
+		t.Fatalf("FindPaymentByID(): can't find payment, %v", err)
+	}
+	if got.AccountID != account.ID {
+		t.Errorf("FindPaymentByID(): wrong payment returned, %v", got)
+	}
+}
+
+func TestFileRepository_reload(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileRepository(dir)
+	if err != nil {
+		t.Fatalf("NewFileRepository(): can't create repository, %v", err)
+	}
+	service := NewService(repo)
+
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+	err = service.Reject(payments[0].ID)
+	if err != nil {
+		t.Fatalf("Reject(): can't reject payment, %v", err)
+	}
+	account = currentAccount(t, service, account.ID)
+	payments[0] = currentPayment(t, service, payments[0].ID)
+
+	reopened, err := NewFileRepository(dir)
+	if err != nil {
+		t.Fatalf("NewFileRepository(): can't reopen repository, %v", err)
+	}
+	defer reopened.Close()
+	restored := NewService(reopened)
+
+	gotAccount, err := restored.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatalf("FindAccountByID(): can't find account, %v", err)
+	}
+	if !reflect.DeepEqual(account, gotAccount) {
+		t.Errorf("FindAccountByID(): want %v, result %v", account, gotAccount)
+	}
+
+	gotPayment, err := restored.FindPaymentByID(payments[0].ID)
+	if err != nil {
+		t.Fatalf("FindPaymentByID(): can't find payment, %v", err)
+	}
+	if !reflect.DeepEqual(payments[0], gotPayment) {
+		t.Errorf("FindPaymentByID(): want %v, result %v", payments[0], gotPayment)
+	}
+
+	gotFavorite, err := restored.FindFavoriteByID(favorite.ID)
+	if err != nil {
+		t.Fatalf("FindFavoriteByID(): can't find favorite, %v", err)
+	}
+	if !reflect.DeepEqual(favorite, gotFavorite) {
+		t.Errorf("FindFavoriteByID(): want %v, result %v", favorite, gotFavorite)
+	}
+
+	_, err = restored.RegisterAccount("+992900000002")
+	if err != nil {
+		t.Errorf("RegisterAccount(): can't register account after reload, %v", err)
+	}
+	_, err = restored.RegisterAccount(defaultExampleTestAccount.phone)
+	if err != ErrPhoneRegistered {
+		t.Errorf("RegisterAccount(): must return ErrPhoneRegistered, returned %v", err)
+	}
+}
+
+func TestFileRepository_atomicSave(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileRepository(dir)
+	if err != nil {
+		t.Fatalf("NewFileRepository(): can't create repository, %v", err)
+	}
+	service := NewService(repo)
+	account, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	// изменения только дописываются в журнал, снимок не переписывается
+	if _, err := os.Stat(filepath.Join(dir, accountsDump)); !os.IsNotExist(err) {
+		t.Errorf("SaveAccount(): snapshot must not be rewritten on every change, %v", err)
+	}
+
+	// изменение, которое не удалось записать, не применяется и в памяти
+	_ = repo.log.file.Close()
+	_, err = service.RegisterAccount("+992900000003")
+	if err == nil {
+		t.Error("RegisterAccount(): must return error when the change can't be written")
+	}
+	if _, err := repo.AccountByPhone("+992900000003"); err != ErrAccountNotFound {
+		t.Errorf("AccountByPhone(): failed change must not be applied, returned %v", err)
+	}
+
+	reopened, err := NewFileRepository(dir)
+	if err != nil {
+		t.Fatalf("NewFileRepository(): can't reopen repository, %v", err)
+	}
+	err = reopened.Close()
+	if err != nil {
+		t.Fatalf("Close(): can't close repository, %v", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(matches) != 0 {
+		t.Errorf("Close(): temporary files must be renamed, found %v", matches)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, repositoryLog))
+	if err != nil || string(content) != formatDumpHeader(entityJournal) {
+		t.Errorf("Close(): journal must be compacted into snapshot, content %q, %v", content, err)
+	}
+
+	path := filepath.Join(dir, accountsDump)
+	before, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(): can't read accounts, %v", err)
+	}
+	failed := errors.New("disk full")
+	err = replaceFile(path, func(tmp string) error {
+		err := ioutil.WriteFile(tmp, []byte("1;"), 0666)
+		if err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed {
+		t.Errorf("replaceFile(): must return write error, returned %v", err)
+	}
+	after, err := ioutil.ReadFile(path)
+	if err != nil || !reflect.DeepEqual(before, after) {
+		t.Errorf("replaceFile(): failed write must keep the previous file, result %q, %v", after, err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("replaceFile(): temporary file of failed write must be removed, %v", err)
+	}
+
+	reopened, err = NewFileRepository(dir)
+	if err != nil {
+		t.Fatalf("NewFileRepository(): can't reopen repository, %v", err)
+	}
+	defer reopened.Close()
+	got, err := reopened.AccountByID(account.ID)
+	if err != nil || !reflect.DeepEqual(got, currentAccount(t, service, account.ID)) {
+		t.Errorf("AccountByID(): want account from snapshot, result %v, %v", got, err)
+	}
+	if _, err := reopened.AccountByPhone("+992900000003"); err != ErrAccountNotFound {
+		t.Errorf("AccountByPhone(): failed change must not be loaded, returned %v", err)
+	}
+}
+
+func TestFileRepository_compact(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileRepository(dir)
+	if err != nil {
+		t.Fatalf("NewFileRepository(): can't create repository, %v", err)
+	}
+	defer repo.Close()
+	service := NewService(repo)
+	account, err := service.RegisterAccount("+992900000001")
+	if err != nil {
+		t.Fatalf("RegisterAccount(): can't register account, %v", err)
+	}
+	for i := 0; i < compactLogEntries; i++ {
+		err = service.Deposit(account.ID, 1)
+		if err != nil {
+			t.Fatalf("Deposit(): can't deposit, %v", err)
+		}
+	}
+	if repo.logged >= compactLogEntries {
+		t.Errorf("Deposit(): journal must be compacted, %d entries", repo.logged)
+	}
+	got, err := readAccounts(filepath.Join(dir, accountsDump), nil)
+	if err != nil || len(got) != 1 {
+		t.Errorf("Deposit(): snapshot must be written, result %v, %v", got, err)
+	}
+}
+
+func TestNewFileRepository_corrupted(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{repositoryLog: formatDumpHeader(entityJournal) + "00000000 SAVE\n1\n"})
+	_, err := NewFileRepository(dir)
+	if !errors.Is(err, ErrJournalCorrupted) {
+		t.Errorf("NewFileRepository(): must return ErrJournalCorrupted, returned %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/types"
+	"time"
+)
+
+// ErrLimitExceeded оборачивают все ошибки встроенных ограничений расходов.
+var ErrLimitExceeded = errors.New("limit exceeded")
+var ErrSinglePaymentLimitExceeded = fmt.Errorf("single payment %w", ErrLimitExceeded)
+var ErrDailyLimitExceeded = fmt.Errorf("daily %w", ErrLimitExceeded)
+var ErrMonthlyLimitExceeded = fmt.Errorf("monthly %w", ErrLimitExceeded)
+var ErrVelocityLimitExceeded = fmt.Errorf("payments per hour %w", ErrLimitExceeded)
+
+// PaymentRequest описывает списание, которое проверяют правила: платёж (Pay, Repeat, PayFromFavorite)
+// или перевод (тогда Category - transfer-out).
+type PaymentRequest struct {
+	Account  types.Account
+	Amount   types.Money
+	Category types.PaymentCategory
+	Time     time.Time
+}
+
+// PaymentRule проверяет списание до его проведения. Если правило возвращает ошибку,
+// списание отклоняется с этой ошибкой.
+// history - списания счёта, которые ещё учитываются в расходах (в обработке или подтверждённые);
+// изменять их нельзя. Правила вызываются под блокировкой сервиса, поэтому вызывать методы Service из них нельзя.
+type PaymentRule interface {
+	Check(request PaymentRequest, history []*types.Payment) error
+}
+
+// PaymentRuleFunc позволяет использовать обычную функцию как PaymentRule.
+type PaymentRuleFunc func(request PaymentRequest, history []*types.Payment) error
+
+func (f PaymentRuleFunc) Check(request PaymentRequest, history []*types.Payment) error {
+	return f(request, history)
+}
+
+// Limits - ограничения расходов. Нулевое значение поля означает, что ограничения нет.
+// Суммы указываются в валюте счёта, сутки и месяц считаются по календарю в UTC.
+type Limits struct {
+	// MaxPayment - максимальная сумма одного списания.
+	MaxPayment types.Money
+	// Daily и Monthly - максимальная сумма списаний за сутки и за месяц, включая новое.
+	Daily   types.Money
+	Monthly types.Money
+	// PaymentsPerHour - максимальное количество списаний за последний час, включая новое.
+	PaymentsPerHour int
+}
+
+// limitScope - к чему относятся ограничения: ко всем списаниям счёта (пустая категория) или к одной категории.
+type limitScope struct {
+	accountID int64
+	category  types.PaymentCategory
+}
+
+// limitsRule - встроенное правило, которое проверяет Limits, заданные через SetLimits и SetCategoryLimits.
+type limitsRule map[limitScope]Limits
+
+// SetLimits задаёт ограничения для всех списаний счёта accountID. Нулевое значение Limits снимает ограничения.
+func (s *Service) SetLimits(accountID int64, limits Limits) error {
+	return s.SetCategoryLimits(accountID, "", limits)
+}
+
+// SetCategoryLimits задаёт ограничения для списаний счёта accountID в категории category.
+// Они проверяются вместе с ограничениями, заданными через SetLimits.
+func (s *Service) SetCategoryLimits(accountID int64, category types.PaymentCategory, limits Limits) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	scope := limitScope{accountID: accountID, category: category}
+	if limits == (Limits{}) {
+		delete(s.limits, scope)
+		return nil
+	}
+	if s.limits == nil {
+		s.limits = make(limitsRule)
+	}
+	s.limits[scope] = limits
+	return nil
+}
+
+// AddPaymentRule добавляет правило, которое проверяется после встроенных ограничений при каждом списании.
+func (s *Service) AddPaymentRule(rule PaymentRule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rules = append(s.rules, rule)
+}
+
+// checkRules проверяет списание amount в категории category со счёта account всеми правилами.
+func (s *Service) checkRules(account *types.Account, amount types.Money, category types.PaymentCategory) error {
+	if len(s.limits) == 0 && len(s.rules) == 0 {
+		return nil
+	}
+
+	payments, err := s.repository().PaymentsByAccount(account.ID)
+	if err != nil {
+		return err
+	}
+	history := []*types.Payment{}
+	for _, payment := range payments {
+		if payment.Category == types.PaymentCategoryTransferIn {
+			continue
+		}
+		if payment.Status == types.PaymentStatusFail || payment.Status == types.PaymentStatusExpired {
+			continue
+		}
+		history = append(history, payment)
+	}
+
+	request := PaymentRequest{Account: *account, Amount: amount, Category: category, Time: s.currentTime()}
+	err = s.limits.Check(request, history)
+	if err != nil {
+		return err
+	}
+	for _, rule := range s.rules {
+		err = rule.Check(request, history)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r limitsRule) Check(request PaymentRequest, history []*types.Payment) error {
+	for _, category := range []types.PaymentCategory{"", request.Category} {
+		limits, ok := r[limitScope{accountID: request.Account.ID, category: category}]
+		if !ok {
+			continue
+		}
+		err := limits.check(request, history, category)
+		if err != nil {
+			if category == "" {
+				return fmt.Errorf("%w: account %d", err, request.Account.ID)
+			}
+			return fmt.Errorf("%w: account %d, category %s", err, request.Account.ID, category)
+		}
+	}
+	return nil
+}
+
+// check проверяет ограничения для списаний категории category (для пустой - для всех списаний).
+func (l Limits) check(request PaymentRequest, history []*types.Payment, category types.PaymentCategory) error {
+	if l.MaxPayment > 0 && request.Amount > l.MaxPayment {
+		return fmt.Errorf("%w: %d > %d", ErrSinglePaymentLimitExceeded, request.Amount, l.MaxPayment)
+	}
+
+	now := request.Time.UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	hourAgo := now.Add(-time.Hour)
+
+	daily, monthly, lastHour := request.Amount, request.Amount, 1
+	for _, payment := range history {
+		if category != "" && payment.Category != category {
+			continue
+		}
+		// платежи без времени (загруженные из старых файлов) не попадают ни в один период
+		if payment.CreatedAt.IsZero() {
+			continue
+		}
+		if !payment.CreatedAt.Before(dayStart) {
+			daily += payment.Amount
+		}
+		if !payment.CreatedAt.Before(monthStart) {
+			monthly += payment.Amount
+		}
+		if payment.CreatedAt.After(hourAgo) {
+			lastHour++
+		}
+	}
+
+	if l.Daily > 0 && daily > l.Daily {
+		return fmt.Errorf("%w: %d > %d", ErrDailyLimitExceeded, daily, l.Daily)
+	}
+	if l.Monthly > 0 && monthly > l.Monthly {
+		return fmt.Errorf("%w: %d > %d", ErrMonthlyLimitExceeded, monthly, l.Monthly)
+	}
+	if l.PaymentsPerHour > 0 && lastHour > l.PaymentsPerHour {
+		return fmt.Errorf("%w: %d > %d", ErrVelocityLimitExceeded, lastHour, l.PaymentsPerHour)
+	}
+	return nil
+}
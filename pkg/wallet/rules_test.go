@@ -0,0 +1,167 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/akhrorov/wallet/pkg/types"
+	"testing"
+	"time"
+)
+
+func TestService_SetLimits(t *testing.T) {
+	now := time.Date(2021, 3, 31, 23, 0, 0, 0, time.UTC)
+	service := &Service{}
+	service.SetClock(func() time.Time { return now })
+	account, _, err := service.addAccount(testExampleAccount{phone: "+992000000001", balance: 10_000_00})
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	err = service.SetLimits(account.ID, Limits{MaxPayment: 500_00, Daily: 800_00, Monthly: 1_000_00, PaymentsPerHour: 3})
+	if err != nil {
+		t.Fatalf("SetLimits(): can't set limits, %v", err)
+	}
+
+	_, err = service.Pay(account.ID, 600_00, "auto")
+	if !errors.Is(err, ErrSinglePaymentLimitExceeded) || !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Pay(): must return ErrSinglePaymentLimitExceeded, returned %v", err)
+	}
+
+	_, err = service.Pay(account.ID, 500_00, "auto")
+	if err != nil {
+		t.Fatalf("Pay(): can't pay, %v", err)
+	}
+	_, err = service.Pay(account.ID, 400_00, "auto")
+	if !errors.Is(err, ErrDailyLimitExceeded) {
+		t.Errorf("Pay(): must return ErrDailyLimitExceeded, returned %v", err)
+	}
+
+	// новые сутки, но тот же месяц
+	now = now.Add(30 * time.Minute)
+	_, err = service.Pay(account.ID, 300_00, "auto")
+	if err != nil {
+		t.Fatalf("Pay(): can't pay next day, %v", err)
+	}
+	_, err = service.Pay(account.ID, 300_00, "auto")
+	if !errors.Is(err, ErrDailyLimitExceeded) {
+		t.Errorf("Pay(): must return ErrDailyLimitExceeded, returned %v", err)
+	}
+}
+
+func TestService_SetLimits_monthlyAndVelocity(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	service := &Service{}
+	service.SetClock(func() time.Time { return now })
+	account, _, err := service.addAccount(testExampleAccount{phone: "+992000000001", balance: 10_000_00})
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	err = service.SetLimits(account.ID, Limits{Monthly: 300_00, PaymentsPerHour: 2})
+	if err != nil {
+		t.Fatalf("SetLimits(): can't set limits, %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = service.Pay(account.ID, 100_00, "auto")
+		if err != nil {
+			t.Fatalf("Pay(): can't pay, %v", err)
+		}
+	}
+	_, err = service.Pay(account.ID, 1_00, "auto")
+	if !errors.Is(err, ErrVelocityLimitExceeded) {
+		t.Errorf("Pay(): must return ErrVelocityLimitExceeded, returned %v", err)
+	}
+
+	now = now.Add(24 * time.Hour)
+	payment, err := service.Pay(account.ID, 100_00, "auto")
+	if err != nil {
+		t.Fatalf("Pay(): can't pay, %v", err)
+	}
+	_, err = service.Pay(account.ID, 1_00, "auto")
+	if !errors.Is(err, ErrMonthlyLimitExceeded) {
+		t.Errorf("Pay(): must return ErrMonthlyLimitExceeded, returned %v", err)
+	}
+
+	// отклонённый платёж больше не учитывается в расходах
+	err = service.Reject(payment.ID)
+	if err != nil {
+		t.Fatalf("Reject(): can't reject payment, %v", err)
+	}
+	_, err = service.Pay(account.ID, 1_00, "auto")
+	if err != nil {
+		t.Errorf("Pay(): rejected payment must not count towards limits, %v", err)
+	}
+}
+
+func TestService_SetCategoryLimits(t *testing.T) {
+	service := &Service{}
+	account, _, err := service.addAccount(testExampleAccount{phone: "+992000000001", balance: 10_000_00})
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	err = service.SetCategoryLimits(account.ID, "restaurant", Limits{Daily: 100_00})
+	if err != nil {
+		t.Fatalf("SetCategoryLimits(): can't set limits, %v", err)
+	}
+
+	_, err = service.Pay(account.ID, 100_00, "restaurant")
+	if err != nil {
+		t.Fatalf("Pay(): can't pay, %v", err)
+	}
+	_, err = service.Pay(account.ID, 1_00, "restaurant")
+	if !errors.Is(err, ErrDailyLimitExceeded) {
+		t.Errorf("Pay(): must return ErrDailyLimitExceeded, returned %v", err)
+	}
+	_, err = service.Pay(account.ID, 1_000_00, "auto")
+	if err != nil {
+		t.Errorf("Pay(): category limits must not apply to other categories, %v", err)
+	}
+
+	err = service.SetCategoryLimits(account.ID, "restaurant", Limits{})
+	if err != nil {
+		t.Fatalf("SetCategoryLimits(): can't remove limits, %v", err)
+	}
+	_, err = service.Pay(account.ID, 1_00, "restaurant")
+	if err != nil {
+		t.Errorf("Pay(): limits must be removed, %v", err)
+	}
+
+	err = service.SetLimits(100, Limits{Daily: 1})
+	if err != ErrAccountNotFound {
+		t.Errorf("SetLimits(): must return ErrAccountNotFound, returned %v", err)
+	}
+}
+
+func TestService_AddPaymentRule(t *testing.T) {
+	errBlocked := errors.New("category blocked")
+	service := &Service{}
+	from, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	to, _, err := service.addAccount(defaultExampleTestAccount2)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	service.AddPaymentRule(PaymentRuleFunc(func(request PaymentRequest, history []*types.Payment) error {
+		if request.Category == "casino" || request.Category == types.PaymentCategoryTransferOut {
+			return errBlocked
+		}
+		return nil
+	}))
+	balance := from.Balance
+
+	_, err = service.Pay(from.ID, 1_00, "casino")
+	if err != errBlocked {
+		t.Errorf("Pay(): must return rule error, returned %v", err)
+	}
+	_, err = service.Transfer(from.ID, to.ID, 1_00)
+	if err != errBlocked {
+		t.Errorf("Transfer(): must return rule error, returned %v", err)
+	}
+	if from.Balance != balance {
+		t.Errorf("Pay(): rejected payment must not change balance, %v", from.Balance)
+	}
+	_, err = service.Pay(from.ID, 1_00, "auto")
+	if err != nil {
+		t.Errorf("Pay(): can't pay, %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/cron"
+	"github.com/akhrorov/wallet/pkg/types"
+	"github.com/google/uuid"
+	"log"
+	"sort"
+	"time"
+)
+
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// Повторные попытки неудавшегося платежа по расписанию: через scheduleRetryDelay, затем каждый раз
+// вдвое дольше, но не больше scheduleMaxRetries раз и не позже следующего срабатывания расписания.
+const (
+	scheduleRetryDelay = time.Minute
+	scheduleMaxRetries = 5
+)
+
+// ScheduleRun - результат одной попытки платежа по расписанию.
+type ScheduleRun struct {
+	Schedule *types.Schedule
+	// Payment - созданный платёж, если попытка удалась, иначе Err - причина неудачи (например, ErrNotEnoughBalance).
+	Payment *types.Payment
+	Err     error
+}
+
+// SchedulePayment создаёт расписание регулярного платежа по элементу "Избранное" favoriteID.
+// spec - расписание в формате cron либо daily, weekly, monthly (см. пакет cron), время считается в UTC.
+// Платежи проводит RunDueSchedules.
+func (s *Service) SchedulePayment(favoriteID string, spec string) (*types.Schedule, error) {
+	parsed, err := cron.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.findFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, ErrFavoriteNotFound
+	}
+
+	now := s.currentTime()
+	nextRun := parsed.Next(now)
+	if nextRun.IsZero() {
+		return nil, fmt.Errorf("%w: %q never runs", cron.ErrInvalidSpec, spec)
+	}
+
+	schedule := &types.Schedule{
+		ID:         uuid.New().String(),
+		FavoriteID: favoriteID,
+		Spec:       spec,
+		NextRun:    nextRun,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	err = s.apply(journalEntry{op: opSchedule, schedules: []*types.Schedule{schedule}})
+	if err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (s *Service) FindScheduleByID(scheduleID string) (*types.Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.repository().ScheduleByID(scheduleID)
+}
+
+// CancelSchedule удаляет расписание. Уже проведённые по нему платежи не меняются.
+func (s *Service) CancelSchedule(scheduleID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.repository().ScheduleByID(scheduleID)
+	if err != nil {
+		return err
+	}
+
+	return s.apply(journalEntry{op: opUnschedule, deleted: []deletedRecord{{kind: kindSchedule, id: scheduleID}}})
+}
+
+// RunDueSchedules проводит платежи по всем расписаниям, время которых наступило по часам сервиса (см. SetClock).
+// Пропущенные срабатывания не догоняются: после платежа следующее время считается от текущего.
+// Неудавшийся платёж повторяется с увеличивающейся задержкой, ошибка сохраняется в LastError.
+// Возвращает результаты попыток; ошибка возвращается, только если не удалось сохранить расписание.
+func (s *Service) RunDueSchedules() ([]ScheduleRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedules, err := s.repository().Schedules()
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.currentTime()
+	due := []*types.Schedule{}
+	for _, schedule := range schedules {
+		if !schedule.NextRun.IsZero() && !schedule.NextRun.After(now) {
+			due = append(due, schedule)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextRun.Before(due[j].NextRun)
+	})
+
+	runs := []ScheduleRun{}
+	for _, schedule := range due {
+		run, err := s.runSchedule(schedule, now)
+		if err != nil {
+			return runs, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+func (s *Service) runSchedule(schedule *types.Schedule, now time.Time) (ScheduleRun, error) {
+	parsed, err := cron.Parse(schedule.Spec)
+	if err != nil {
+		return ScheduleRun{}, err
+	}
+
+	// ключ идемпотентности не даёт провести платёж дважды, если процесс упал
+	// между платежом и сохранением расписания
+	key := fmt.Sprintf("schedule:%s:%d", schedule.ID, schedule.NextRun.UnixNano())
+	payment, err := s.payFromSchedule(key, schedule.FavoriteID)
+
+	updated := *schedule
+	updated.UpdatedAt = now
+	next := parsed.Next(now)
+	if err == nil {
+		updated.NextRun = next
+		updated.Attempts = 0
+		updated.LastError = ""
+		updated.LastPaymentID = payment.ID
+	} else {
+		updated.Attempts++
+		updated.LastError = err.Error()
+		retry := now.Add(scheduleRetryDelay << uint(updated.Attempts-1))
+		if updated.Attempts > scheduleMaxRetries || (!next.IsZero() && !retry.Before(next)) {
+			// попытки для этого срабатывания исчерпаны, ждём следующего
+			updated.NextRun = next
+			updated.Attempts = 0
+		} else {
+			updated.NextRun = retry
+		}
+	}
+
+	saveErr := s.apply(journalEntry{op: opScheduleRun, schedules: []*types.Schedule{&updated}})
+	if saveErr != nil {
+		return ScheduleRun{}, saveErr
+	}
+	return ScheduleRun{Schedule: schedule, Payment: payment, Err: err}, nil
+}
+
+func (s *Service) payFromSchedule(key string, favoriteID string) (*types.Payment, error) {
+	record, replay, err := s.checkIdempotencyKey(key, keyOpPayFromFavorite, favoriteID)
+	if err != nil {
+		return nil, err
+	}
+	if replay {
+		return s.replayPayment(record)
+	}
+	return s.payFromFavorite(favoriteID, record)
+}
+
+// RunScheduler вызывает RunDueSchedules каждые interval, пока не будет отменён ctx.
+// Ошибки сохранения и неудавшиеся платежи записываются в лог.
+func (s *Service) RunScheduler(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			runs, err := s.RunDueSchedules()
+			if err != nil {
+				log.Print(err)
+			}
+			for _, run := range runs {
+				if run.Err != nil {
+					log.Printf("schedule %s: %v", run.Schedule.ID, run.Err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/akhrorov/wallet/pkg/cron"
+	"github.com/akhrorov/wallet/pkg/types"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// currentSchedule возвращает сохранённое состояние расписания scheduleID.
+func currentSchedule(t *testing.T, service *Service, scheduleID string) *types.Schedule {
+	t.Helper()
+	schedule, err := service.FindScheduleByID(scheduleID)
+	if err != nil {
+		t.Fatalf("FindScheduleByID(): can't find schedule, %v", err)
+	}
+	return schedule
+}
+
+func TestService_RunDueSchedules(t *testing.T) {
+	now := time.Date(2021, 3, 3, 10, 30, 0, 0, time.UTC)
+	service := &Service{}
+	service.SetClock(func() time.Time { return now })
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+
+	schedule, err := service.SchedulePayment(favorite.ID, "daily")
+	if err != nil {
+		t.Fatalf("SchedulePayment(): can't schedule, %v", err)
+	}
+	if want := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC); !schedule.NextRun.Equal(want) {
+		t.Errorf("SchedulePayment(): want next run %v, result %v", want, schedule.NextRun)
+	}
+
+	runs, err := service.RunDueSchedules()
+	if err != nil || len(runs) != 0 {
+		t.Errorf("RunDueSchedules(): nothing is due yet, result %v, %v", runs, err)
+	}
+
+	balance := account.Balance
+	now = time.Date(2021, 3, 4, 0, 0, 30, 0, time.UTC)
+	runs, err = service.RunDueSchedules()
+	if err != nil || len(runs) != 1 || runs[0].Err != nil {
+		t.Fatalf("RunDueSchedules(): want one successful run, result %v, %v", runs, err)
+	}
+	account = currentAccount(t, service, account.ID)
+	schedule = currentSchedule(t, service, schedule.ID)
+	if account.Balance != balance-favorite.Amount || schedule.LastPaymentID != runs[0].Payment.ID {
+		t.Errorf("RunDueSchedules(): wrong result, account %v, schedule %v", account, schedule)
+	}
+	if want := time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC); !schedule.NextRun.Equal(want) {
+		t.Errorf("RunDueSchedules(): want next run %v, result %v", want, schedule.NextRun)
+	}
+
+	// повторный вызов в ту же минуту не проводит платёж ещё раз
+	runs, err = service.RunDueSchedules()
+	if err != nil || len(runs) != 0 {
+		t.Errorf("RunDueSchedules(): nothing is due, result %v, %v", runs, err)
+	}
+}
+
+func TestService_RunDueSchedules_retry(t *testing.T) {
+	now := time.Date(2021, 3, 3, 10, 30, 0, 0, time.UTC)
+	service := &Service{}
+	service.SetClock(func() time.Time { return now })
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+	schedule, err := service.SchedulePayment(favorite.ID, "0 * * * *")
+	if err != nil {
+		t.Fatalf("SchedulePayment(): can't schedule, %v", err)
+	}
+	// оставляем на счёте меньше, чем нужно для платежа
+	account = currentAccount(t, service, account.ID)
+	_, err = service.Pay(account.ID, account.Balance-1, "auto")
+	if err != nil {
+		t.Fatalf("Pay(): can't pay, %v", err)
+	}
+
+	now = time.Date(2021, 3, 3, 11, 0, 0, 0, time.UTC)
+	for attempt, delay := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute} {
+		runs, err := service.RunDueSchedules()
+		if err != nil || len(runs) != 1 || !errors.Is(runs[0].Err, ErrNotEnoughBalance) {
+			t.Fatalf("RunDueSchedules(): want ErrNotEnoughBalance, result %v, %v", runs, err)
+		}
+		schedule = currentSchedule(t, service, schedule.ID)
+		if schedule.Attempts != attempt+1 || schedule.LastError != ErrNotEnoughBalance.Error() || !schedule.NextRun.Equal(now.Add(delay)) {
+			t.Errorf("RunDueSchedules(): wrong retry state after attempt %d, %v", attempt+1, schedule)
+		}
+		now = schedule.NextRun
+	}
+
+	err = service.Deposit(account.ID, favorite.Amount)
+	if err != nil {
+		t.Fatalf("Deposit(): can't deposit, %v", err)
+	}
+	runs, err := service.RunDueSchedules()
+	if err != nil || len(runs) != 1 || runs[0].Err != nil {
+		t.Fatalf("RunDueSchedules(): want successful retry, result %v, %v", runs, err)
+	}
+	schedule = currentSchedule(t, service, schedule.ID)
+	if schedule.Attempts != 0 || schedule.LastError != "" || !schedule.NextRun.Equal(time.Date(2021, 3, 3, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("RunDueSchedules(): retry state must be reset, %v", schedule)
+	}
+}
+
+func TestService_RunDueSchedules_retryUntilNextRun(t *testing.T) {
+	now := time.Date(2021, 3, 3, 10, 30, 0, 0, time.UTC)
+	service := &Service{}
+	service.SetClock(func() time.Time { return now })
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+	// каждые 5 минут: попытки в 10:35, 10:36 и 10:38, четвёртая (10:42) уже позже следующего срабатывания
+	schedule, err := service.SchedulePayment(favorite.ID, "*/5 * * * *")
+	if err != nil {
+		t.Fatalf("SchedulePayment(): can't schedule, %v", err)
+	}
+	_, err = service.Pay(account.ID, currentAccount(t, service, account.ID).Balance, "auto")
+	if err != nil {
+		t.Fatalf("Pay(): can't pay, %v", err)
+	}
+
+	now = schedule.NextRun
+	for i := 0; i < 3; i++ {
+		_, err = service.RunDueSchedules()
+		if err != nil {
+			t.Fatalf("RunDueSchedules(): can't run, %v", err)
+		}
+		schedule = currentSchedule(t, service, schedule.ID)
+		now = schedule.NextRun
+	}
+	if schedule.Attempts != 0 || !schedule.NextRun.Equal(time.Date(2021, 3, 3, 10, 40, 0, 0, time.UTC)) || schedule.LastError == "" {
+		t.Errorf("RunDueSchedules(): must give up until next run, %v", schedule)
+	}
+}
+
+func TestService_SchedulePayment_fail(t *testing.T) {
+	service := &Service{}
+	_, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+
+	_, err = service.SchedulePayment(favorite.ID, "sometimes")
+	if !errors.Is(err, cron.ErrInvalidSpec) {
+		t.Errorf("SchedulePayment(): must return ErrInvalidSpec, returned %v", err)
+	}
+	_, err = service.SchedulePayment("unknown", "daily")
+	if err != ErrFavoriteNotFound {
+		t.Errorf("SchedulePayment(): must return ErrFavoriteNotFound, returned %v", err)
+	}
+}
+
+func TestService_CancelSchedule(t *testing.T) {
+	now := time.Date(2021, 3, 3, 10, 30, 0, 0, time.UTC)
+	service := &Service{}
+	service.SetClock(func() time.Time { return now })
+	_, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+	schedule, err := service.SchedulePayment(favorite.ID, "daily")
+	if err != nil {
+		t.Fatalf("SchedulePayment(): can't schedule, %v", err)
+	}
+
+	err = service.CancelSchedule(schedule.ID)
+	if err != nil {
+		t.Fatalf("CancelSchedule(): can't cancel, %v", err)
+	}
+	_, err = service.FindScheduleByID(schedule.ID)
+	if err != ErrScheduleNotFound {
+		t.Errorf("FindScheduleByID(): must return ErrScheduleNotFound, returned %v", err)
+	}
+	now = now.Add(48 * time.Hour)
+	runs, err := service.RunDueSchedules()
+	if err != nil || len(runs) != 0 {
+		t.Errorf("RunDueSchedules(): cancelled schedule must not run, %v, %v", runs, err)
+	}
+	err = service.CancelSchedule(schedule.ID)
+	if err != ErrScheduleNotFound {
+		t.Errorf("CancelSchedule(): must return ErrScheduleNotFound, returned %v", err)
+	}
+}
+
+func TestService_Schedule_persistence(t *testing.T) {
+	dir := t.TempDir()
+	service := &Service{}
+	service.SetClock(func() time.Time { return time.Date(2021, 3, 3, 10, 30, 0, 0, time.UTC) })
+	err := service.OpenJournal(filepath.Join(dir, "wallet.journal"))
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't open journal, %v", err)
+	}
+	_, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+	kept, err := service.SchedulePayment(favorite.ID, "weekly")
+	if err != nil {
+		t.Fatalf("SchedulePayment(): can't schedule, %v", err)
+	}
+	cancelled, err := service.SchedulePayment(favorite.ID, "monthly")
+	if err != nil {
+		t.Fatalf("SchedulePayment(): can't schedule, %v", err)
+	}
+	err = service.CancelSchedule(cancelled.ID)
+	if err != nil {
+		t.Fatalf("CancelSchedule(): can't cancel, %v", err)
+	}
+	err = service.CloseJournal()
+	if err != nil {
+		t.Fatalf("CloseJournal(): can't close journal, %v", err)
+	}
+
+	restored := &Service{}
+	err = restored.OpenJournal(filepath.Join(dir, "wallet.journal"))
+	if err != nil {
+		t.Fatalf("OpenJournal(): can't replay journal, %v", err)
+	}
+	defer restored.CloseJournal()
+	got, err := restored.FindScheduleByID(kept.ID)
+	if err != nil || !reflect.DeepEqual(kept, got) {
+		t.Errorf("OpenJournal(): want schedule %v, result %v, %v", kept, got, err)
+	}
+	_, err = restored.FindScheduleByID(cancelled.ID)
+	if err != ErrScheduleNotFound {
+		t.Errorf("OpenJournal(): cancelled schedule must be deleted, %v", err)
+	}
+
+	exportDir := t.TempDir()
+	err = restored.Export(exportDir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+	imported := &Service{}
+	err = imported.Import(exportDir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	got, err = imported.FindScheduleByID(kept.ID)
+	if err != nil || !reflect.DeepEqual(kept, got) {
+		t.Errorf("Import(): want schedule %v, result %v, %v", kept, got, err)
+	}
+}
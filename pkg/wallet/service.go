@@ -4,14 +4,17 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"github.com/akhrorov/wallet/pkg/exchange"
+	"github.com/akhrorov/wallet/pkg/record"
 	"github.com/akhrorov/wallet/pkg/types"
 	"github.com/google/uuid"
-	"io"
 	"log"
 	"os"
-	"strconv"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 var ErrPhoneRegistered = errors.New("phone already registered")
@@ -21,130 +24,294 @@ var ErrNotEnoughBalance = errors.New("not enough balance")
 var ErrPaymentNotFound = errors.New("payment not found")
 var ErrFavoriteNotFound = errors.New("favorite not found")
 
+// Service безопасен для одновременного использования из нескольких горутин.
+// Возвращаемые методами указатели ссылаются на внутреннее состояние сервиса,
+// поэтому изменять их напрямую нельзя.
+// Нулевое значение Service готово к работе и хранит данные в памяти.
 type Service struct {
+	mu            sync.RWMutex
+	initOnce      sync.Once
+	repo          Repository
+	journal       *journal
 	nextAccountID int64
-	accounts      []*types.Account
-	payments      []*types.Payment
-	favorites     []*types.Favorite
+	// idempotencyRetention - срок хранения ключей идемпотентности, по умолчанию defaultIdempotencyRetention.
+	idempotencyRetention time.Duration
+	// keysPurgedAt - когда purgeIdempotencyKeys последний раз удалял ключи с истёкшим сроком хранения.
+	keysPurgedAt time.Time
+	// now возвращает текущее время, по умолчанию time.Now. Задаётся через SetClock.
+	now func() time.Time
+	// rates пересчитывает суммы между валютами. Задаётся через SetConverter.
+	rates exchange.Converter
+	// limits и rules проверяют каждое списание, см. SetLimits и AddPaymentRule.
+	limits limitsRule
+	rules  []PaymentRule
+	// compression - сжатие записываемых файлов выгрузки. Задаётся через SetCompression.
+	compression Compression
 }
 
-type testExampleAccount struct {
-	phone    types.Phone
-	balance  types.Money
-	payments []struct {
-		amount   types.Money
-		category types.PaymentCategory
-	}
+// NewService создаёт сервис поверх хранилища repo.
+func NewService(repo Repository) *Service {
+	return &Service{repo: repo}
 }
 
-var defaultExampleTestAccount = testExampleAccount{
-	phone:   "+992900000001",
-	balance: 10_000_00,
-	payments: []struct {
-		amount   types.Money
-		category types.PaymentCategory
-	}{
-		{amount: 1_000_00, category: "auto"},
-	},
+// SetClock задаёт источник текущего времени, по которому проставляются CreatedAt и UpdatedAt.
+// По умолчанию используется time.Now, nil возвращает его.
+func (s *Service) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.now = now
 }
 
-var defaultExampleTestAccount2 = testExampleAccount{
-	phone:   "+992900000002",
-	balance: 10_000_00,
-	payments: []struct {
-		amount   types.Money
-		category types.PaymentCategory
-	}{
-		{amount: 1_000_00, category: "auto"},
-	},
+// currentTime возвращает текущее время в UTC без показаний монотонных часов,
+// чтобы записи не менялись после сохранения в файл и чтения обратно.
+func (s *Service) currentTime() time.Time {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	return now().UTC().Round(0)
 }
 
-func (s *Service) addAccount(data testExampleAccount) (*types.Account, []*types.Payment, error) {
-	account, err := s.RegisterAccount(data.phone)
+// repository возвращает хранилище сервиса, при необходимости создавая хранилище в памяти.
+func (s *Service) repository() Repository {
+	s.initOnce.Do(func() {
+		if s.repo == nil {
+			s.repo = NewMemoryRepository()
+		}
+	})
+	return s.repo
+}
+
+// apply записывает entry в журнал (если он открыт) и только после этого сохраняет изменённые записи в хранилище.
+// Если сохранить не удалось, запись удаляется из журнала, чтобы он не содержал неприменённых изменений.
+func (s *Service) apply(entry journalEntry) error {
+	if s.journal == nil {
+		return s.save(entry)
+	}
+	offset, err := s.journal.append(entry)
 	if err != nil {
-		return nil, nil, fmt.Errorf("can't register account, error = %v", err)
+		return err
 	}
-
-	err = s.Deposit(account.ID, data.balance)
+	err = s.save(entry)
 	if err != nil {
-		return nil, nil, fmt.Errorf("can't deposit account, error = %v", err)
+		if terr := s.journal.truncate(offset); terr != nil {
+			log.Print(terr)
+		}
+		return err
 	}
+	return nil
+}
 
-	payments := make([]*types.Payment, len(data.payments))
-	for i, payment := range data.payments {
-		payments[i], err = s.Pay(account.ID, payment.amount, payment.category)
+// save сохраняет записи entry в хранилище: целиком, если хранилище это умеет (см. entryRepository), иначе по одной.
+func (s *Service) save(entry journalEntry) error {
+	if repository, ok := s.repository().(entryRepository); ok {
+		return repository.saveEntry(entry)
+	}
+	return saveEntry(s.repository(), entry)
+}
+
+// OpenJournal восстанавливает состояние из журнала path и начинает записывать в него
+// все последующие изменения (RegisterAccount, Deposit, Pay, Reject, FavoritePayment, импорт).
+// Если есть снимок, сделанный CompactJournal, его нужно загрузить через Import до вызова OpenJournal.
+func (s *Service) OpenJournal(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal != nil {
+		return ErrJournalOpened
+	}
+
+	journal, entries, err := openJournal(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = s.save(entry)
 		if err != nil {
-			return nil, nil, fmt.Errorf("can't make payment, error = %v", err)
+			if cerr := journal.close(); cerr != nil {
+				log.Print(cerr)
+			}
+			return err
 		}
 	}
 
-	return account, payments, nil
+	s.journal = journal
+	return nil
+}
+
+// CloseJournal закрывает журнал, после чего изменения в него больше не записываются.
+func (s *Service) CloseJournal() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal == nil {
+		return nil
+	}
+	err := s.journal.close()
+	s.journal = nil
+	return err
+}
+
+// CompactJournal сохраняет текущее состояние в каталог dir в формате Export и очищает журнал.
+func (s *Service) CompactJournal(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.export(dir, FormatDump)
+	if err != nil && err != ErrNothingToExport {
+		return err
+	}
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal.reset()
 }
 
+// RegisterAccount регистрирует счёт в валюте по умолчанию (types.DefaultCurrency).
+// Номер phone сохраняется в формате E.164, поэтому "+992 900-00-00-01" и "+992900000001" - один и тот же номер.
 func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
-	for _, account := range s.accounts {
-		if account.Phone == phone {
-			return nil, ErrPhoneRegistered
+	return s.RegisterAccountWithCurrency(phone, types.DefaultCurrency)
+}
+
+// RegisterAccountWithCurrency регистрирует счёт в валюте currency.
+func (s *Service) RegisterAccountWithCurrency(phone types.Phone, currency types.Currency) (*types.Account, error) {
+	if !currency.Valid() {
+		return nil, ErrInvalidCurrency
+	}
+	phone, err := normalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.repository().AccountByPhone(phone)
+	if err == nil {
+		return nil, ErrPhoneRegistered
+	}
+	if err != ErrAccountNotFound {
+		return nil, err
+	}
+
+	// пропускаем ID, уже занятые импортированными счетами
+	for {
+		s.nextAccountID++
+		_, err = s.findAccountByID(s.nextAccountID)
+		if err == ErrAccountNotFound {
+			break
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	s.nextAccountID++
+	now := s.currentTime()
 	account := &types.Account{
-		ID:      s.nextAccountID,
-		Phone:   phone,
-		Balance: 0,
+		ID:        s.nextAccountID,
+		Phone:     phone,
+		Balance:   0,
+		Currency:  currency,
+		Status:    types.AccountStatusActive,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err = s.apply(journalEntry{op: opRegisterAccount, accounts: []*types.Account{account}})
+	if err != nil {
+		return nil, err
 	}
-	s.accounts = append(s.accounts, account)
 
 	return account, nil
 }
 
 func (s *Service) FindAccountByID(accountID int64) (*types.Account, error) {
-	for _, account := range s.accounts {
-		if account.ID == accountID {
-			return account, nil
-		}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.findAccountByID(accountID)
+}
+
+func (s *Service) findAccountByID(accountID int64) (*types.Account, error) {
+	return s.repository().AccountByID(accountID)
+}
+
+// FindAccountByPhone ищет счёт по номеру phone в любом допустимом написании (см. RegisterAccount).
+func (s *Service) FindAccountByPhone(phone types.Phone) (*types.Account, error) {
+	phone, err := normalizePhone(phone)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, ErrAccountNotFound
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.repository().AccountByPhone(phone)
 }
 
+// Deposit зачисляет amount в валюте по умолчанию types.DefaultCurrency. Для счёта в другой валюте
+// возвращает ErrCurrencyMismatch: такие счета пополняются через DepositAmount.
 func (s *Service) Deposit(accountID int64, amount types.Money) error {
-	if amount <= 0 {
-		return ErrAmountMustBePositive
-	}
+	return s.DepositWithKey("", accountID, amount)
+}
 
-	account, err := s.FindAccountByID(accountID)
+// deposit зачисляет amount на счёт. Если key не nil, он сохраняется вместе с зачислением.
+func (s *Service) deposit(accountID int64, amount types.Money, key *types.IdempotencyKey) error {
+	account, err := s.findAccountByID(accountID)
 	if err != nil {
 		return ErrAccountNotFound
 	}
+	err = checkActive(account)
+	if err != nil {
+		return err
+	}
 
-	// зачисление средств пока не рассматриваем как платёж
-	account.Balance += amount
-	return nil
+	// зачисление средств не считаем платежом, но отражаем проводкой
+	updated := *account
+	updated.Balance += amount
+	updated.UpdatedAt = s.currentTime()
+	return s.apply(journalEntry{
+		op:       opDeposit,
+		accounts: []*types.Account{&updated},
+		postings: []*types.Posting{s.newPosting(types.PostingReasonDeposit, "", ledgerExternal, ledgerAccount(accountID), types.Amount{Value: amount, Currency: account.Currency})},
+		keys:     idempotencyKeys(key, ""),
+	})
 }
 
+// Pay списывает amount в валюте по умолчанию types.DefaultCurrency. Для счёта в другой валюте
+// возвращает ErrCurrencyMismatch: с таких счетов платят через PayAmount.
 func (s *Service) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	return s.PayWithKey("", accountID, amount, category)
+}
+
+// pay списывает amount со счёта и создаёт платёж. Если key не nil, он сохраняется вместе с платежом.
+func (s *Service) pay(accountID int64, amount types.Money, category types.PaymentCategory, key *types.IdempotencyKey) (*types.Payment, error) {
 	if amount <= 0 {
 		return nil, ErrAmountMustBePositive
 	}
 
-	var account *types.Account
-	for _, acc := range s.accounts {
-		if acc.ID == accountID {
-			account = acc
-			break
-		}
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
 	}
-	if account == nil {
-		return nil, ErrAccountNotFound
+	err = checkActive(account)
+	if err != nil {
+		return nil, err
 	}
 
 	if account.Balance < amount {
 		return nil, ErrNotEnoughBalance
 	}
+	err = s.checkRules(account, amount, category)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.currentTime()
+	updated := *account
+	updated.Balance -= amount
+	updated.UpdatedAt = now
 
-	account.Balance -= amount
 	paymentID := uuid.New().String()
 	payment := &types.Payment{
 		ID:        paymentID,
@@ -152,84 +319,199 @@ func (s *Service) Pay(accountID int64, amount types.Money, category types.Paymen
 		Amount:    amount,
 		Category:  category,
 		Status:    types.PaymentStatusInProgress,
+		Currency:  account.Currency,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err = s.apply(journalEntry{
+		op:       opPay,
+		accounts: []*types.Account{&updated},
+		payments: []*types.Payment{payment},
+		postings: []*types.Posting{s.newPosting(types.PostingReasonPayment, paymentID, ledgerAccount(accountID), ledgerCategory(category), types.Amount{Value: amount, Currency: account.Currency})},
+		keys:     idempotencyKeys(key, paymentID),
+	})
+	if err != nil {
+		return nil, err
 	}
-	s.payments = append(s.payments, payment)
 	return payment, nil
 }
 
 func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
-	for _, payment := range s.payments {
-		if payment.ID == paymentID {
-			return payment, nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.findPaymentByID(paymentID)
+}
+
+func (s *Service) findPaymentByID(paymentID string) (*types.Payment, error) {
+	return s.repository().PaymentByID(paymentID)
+}
+
+// Confirm подтверждает платёж, находящийся в обработке. Для перевода подтверждаются обе стороны.
+func (s *Service) Confirm(paymentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payment, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return ErrPaymentNotFound
+	}
+	err = payment.Status.Transition(types.PaymentStatusOk)
+	if err != nil {
+		return err
+	}
+
+	confirmed := []*types.Payment{}
+	legs := []*types.Payment{payment}
+	if payment.LinkedID != "" {
+		linked, err := s.findPaymentByID(payment.LinkedID)
+		if err != nil {
+			return err
 		}
+		err = linked.Status.Transition(types.PaymentStatusOk)
+		if err != nil {
+			return err
+		}
+		legs = append(legs, linked)
+	}
+	now := s.currentTime()
+	for _, leg := range legs {
+		updated := *leg
+		updated.Status = types.PaymentStatusOk
+		updated.UpdatedAt = now
+		confirmed = append(confirmed, &updated)
 	}
 
-	return nil, ErrPaymentNotFound
+	return s.apply(journalEntry{op: opConfirm, payments: confirmed})
 }
 
+// Reject отклоняет платёж, находящийся в обработке, и возвращает средства на счёт.
 func (s *Service) Reject(paymentID string) error {
-	payment, err := s.FindPaymentByID(paymentID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cancel(paymentID, types.PaymentStatusFail, opReject)
+}
+
+// Expire помечает платёж, находящийся в обработке, просроченным и возвращает средства на счёт.
+func (s *Service) Expire(paymentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cancel(paymentID, types.PaymentStatusExpired, opExpire)
+}
+
+// cancel переводит платёж в конечный статус status и возвращает средства.
+func (s *Service) cancel(paymentID string, status types.PaymentStatus, op string) error {
+	payment, err := s.findPaymentByID(paymentID)
 	if err != nil {
 		return ErrPaymentNotFound
 	}
-	account, err := s.FindAccountByID(payment.AccountID)
+	err = payment.Status.Transition(status)
+	if err != nil {
+		return err
+	}
+	if payment.LinkedID != "" {
+		return s.cancelTransfer(payment, status, op)
+	}
+	account, err := s.findAccountByID(payment.AccountID)
 	if err != nil {
 		return ErrAccountNotFound
 	}
 
-	payment.Status = types.PaymentStatusFail
-	account.Balance += payment.Amount
-	return nil
+	now := s.currentTime()
+	updatedPayment := *payment
+	updatedPayment.Status = status
+	updatedPayment.UpdatedAt = now
+	updatedAccount := *account
+	updatedAccount.Balance += payment.Amount
+	updatedAccount.UpdatedAt = now
+
+	return s.apply(journalEntry{
+		op:       op,
+		accounts: []*types.Account{&updatedAccount},
+		payments: []*types.Payment{&updatedPayment},
+		postings: []*types.Posting{s.newPosting(types.PostingReasonRefund, payment.ID, ledgerCategory(payment.Category), ledgerAccount(account.ID), types.Amount{Value: payment.Amount, Currency: payment.Currency})},
+	})
 }
 
 func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
-	payment, err := s.FindPaymentByID(paymentID)
+	return s.RepeatWithKey("", paymentID)
+}
+
+func (s *Service) repeat(paymentID string, key *types.IdempotencyKey) (*types.Payment, error) {
+	payment, err := s.findPaymentByID(paymentID)
 	if err != nil {
 		return nil, err
 	}
+	if payment.LinkedID != "" {
+		return s.repeatTransfer(payment, key)
+	}
 
-	return s.Pay(payment.AccountID, payment.Amount, payment.Category)
+	return s.pay(payment.AccountID, payment.Amount, payment.Category, key)
 }
 
 func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorite, error) {
-	payment, err := s.FindPaymentByID(paymentID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payment, err := s.findPaymentByID(paymentID)
 	if err != nil {
 		return nil, err
 	}
 
+	now := s.currentTime()
 	favorite := &types.Favorite{
 		ID:        uuid.New().String(),
 		AccountID: payment.AccountID,
 		Amount:    payment.Amount,
 		Name:      name,
 		Category:  payment.Category,
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
 
-	s.favorites = append(s.favorites, favorite)
+	err = s.apply(journalEntry{op: opFavoritePayment, favorites: []*types.Favorite{favorite}})
+	if err != nil {
+		return nil, err
+	}
 	return favorite, nil
 }
 
 func (s *Service) FindFavoriteByID(favoriteID string) (*types.Favorite, error) {
-	for _, favorite := range s.favorites {
-		if favorite.ID == favoriteID {
-			return favorite, nil
-		}
-	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	return nil, ErrFavoriteNotFound
+	return s.findFavoriteByID(favoriteID)
+}
+
+func (s *Service) findFavoriteByID(favoriteID string) (*types.Favorite, error) {
+	return s.repository().FavoriteByID(favoriteID)
 }
 
 func (s *Service) PayFromFavorite(favoriteID string) (*types.Payment, error) {
-	favorite, err := s.FindFavoriteByID(favoriteID)
+	return s.PayFromFavoriteWithKey("", favoriteID)
+}
+
+func (s *Service) payFromFavorite(favoriteID string, key *types.IdempotencyKey) (*types.Payment, error) {
+	favorite, err := s.findFavoriteByID(favoriteID)
 	if err != nil {
 		return nil, ErrFavoriteNotFound
 	}
 
-	return s.Pay(favorite.AccountID, favorite.Amount, favorite.Category)
+	return s.pay(favorite.AccountID, favorite.Amount, favorite.Category, key)
 }
 
+// ExportToFile дописывает счета в файл path. Если задано сжатие (см. SetCompression), записи сжимаются,
+// но в непустой файл они дописываются так же, как записано его начало.
 func (s *Service) ExportToFile(path string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
+	compression, err := appendCompression(path, s.currentCompression())
+	if err != nil {
+		return err
+	}
 	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		return err
@@ -240,21 +522,71 @@ func (s *Service) ExportToFile(path string) error {
 		}
 	}()
 
-	var str string
-	for _, v := range s.accounts {
-		str += fmt.Sprint(v.ID) + ";" + string(v.Phone) + ";" + fmt.Sprint(v.Balance) + "|"
+	accounts, err := s.repository().Accounts()
+	if err != nil {
+		return err
 	}
-	_, err = file.WriteString(str)
 
+	// заголовок пишется перед каждой порцией, потому что файл дописывается;
+	// сжатая порция - отдельный поток gzip или кадр zstd, распаковщики читают их подряд
+	c := newCompressor(file, compression)
+	w := bufio.NewWriter(c)
+	_, err = w.WriteString(strings.TrimSuffix(formatDumpHeader(entityAccounts), "\n") + "|")
+	for i := 0; i < len(accounts) && err == nil; i++ {
+		_, err = w.WriteString(strings.TrimSuffix(formatAccount(accounts[i]), "\n") + "|")
+	}
 	if err != nil {
 		return err
 	}
-
-	return nil
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	return c.close()
 }
 
+// ImportFromFile загружает счета из файла path, записанного ExportToFile, в том числе сжатого.
+// Номера телефонов приводятся к каноническому виду (см. normalizeStoredPhone), запись с неверным номером - ошибка разбора.
 func (s *Service) ImportFromFile(path string) error {
-	file, err := os.Open(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.importFromFile(path, nil)
+}
+
+// ImportFromFileLenient работает как ImportFromFile, но записи, которые не удалось разобрать,
+// пропускает и возвращает в отчёте. Line в ParseError - номер записи в файле.
+func (s *Service) ImportFromFileLenient(path string) (*ImportReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := &ImportReport{}
+	err := s.importFromFile(path, report)
+	return report, err
+}
+
+// importFromFile импортирует счета из файла path, записанного ExportToFile. Счета сохраняются по мере чтения,
+// поэтому сначала файл только разбирается: импорт с ошибкой в записи не должен сохранить часть файла.
+func (s *Service) importFromFile(path string, report *ImportReport) error {
+	err := scanAccountsFile(path, checkReport(report), func(account *types.Account) error { return nil })
+	if err != nil {
+		return err
+	}
+	im, err := s.newImporter()
+	if err != nil {
+		return err
+	}
+	err = scanAccountsFile(path, report, im.account)
+	if err != nil {
+		return err
+	}
+	return im.flush()
+}
+
+// scanAccountsFile вызывает handle для каждого счёта файла path, записанного ExportToFile.
+// Записи с ошибками разбора пропускаются в report (см. ImportReport.skip), ошибка handle прерывает чтение.
+func scanAccountsFile(path string, report *ImportReport, handle func(account *types.Account) error) error {
+	file, err := openDump(path)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -264,316 +596,262 @@ func (s *Service) ImportFromFile(path string) error {
 			log.Print(cerr)
 		}
 	}()
-	buf := make([]byte, 1)
-	content := make([]byte, 0)
-	for {
-		read, err := file.Read(buf)
-
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-		content = append(content, buf[:read]...)
-	}
-	data := strings.Split(string(content), "|")
-	var row []string
-	for _, v := range data {
-		row = append(row, strings.ReplaceAll(v, ";", " "))
+	reader, err := dumpReaderFor(1)
+	if err != nil {
+		return err
 	}
-	for _, acc := range row {
+	name := filepath.Base(path)
+	scanner := bufio.NewScanner(file)
+	scanner.Split(record.ScanRecords('|'))
+	for i := 1; scanner.Scan(); i++ {
+		acc := scanner.Text()
 		if len(acc) == 0 {
 			continue
 		}
+		// заголовок задаёт версию следующих за ним записей, записи без заголовка - версии 1
+		if strings.HasPrefix(acc, "#") {
+			reader, err = dumpReaderForHeader(acc, entityAccounts)
+			if err != nil {
+				return newParseError(name, i, err)
+			}
+			continue
+		}
 
-		accountSplit := strings.Split(acc, " ")
-
-		id, err := strconv.ParseInt(accountSplit[0], 10, 64)
+		account, err := reader.account(acc)
 		if err != nil {
-			return err
+			err = report.skip(newParseError(name, i, err))
+			if err != nil {
+				return err
+			}
+			continue
 		}
-		balance, err := strconv.ParseInt(accountSplit[2], 10, 64)
+		err = handle(account)
 		if err != nil {
 			return err
 		}
-		account := &types.Account{ID: id, Balance: types.Money(balance), Phone: types.Phone(accountSplit[1])}
-		s.accounts = append(s.accounts, account)
-
 	}
-	return nil
+	return scanner.Err()
 }
 
 func (s *Service) Export(dir string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	if len(s.accounts) > 0 {
-		var accountItem string
-		for _, account := range s.accounts {
-			accountItem += fmt.Sprint(account.ID) + ";" + string(account.Phone) + ";" + fmt.Sprint(account.Balance) + "\n"
-		}
+	return s.export(dir, FormatDump)
+}
 
-		file, err := os.Create(dir + "/accounts.dump")
-		if err != nil {
-			log.Print(err)
-			return err
-		}
-		defer func() {
-			if cerr := file.Close(); cerr != nil {
-				log.Print(cerr)
-			}
-		}()
+// ExportAs работает как Export, но записывает файлы в формате format, например accounts.csv.
+// Файлы прежнего поколения в других форматах и с другим сжатием удаляются.
+func (s *Service) ExportAs(dir string, format Format) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.export(dir, format)
+}
 
-		_, err = file.WriteString(accountItem)
+// export сохраняет состояние в каталог dir одним поколением файлов формата format с манифестом (см. exportGeneration).
+// Файлы сжимаются, если сжатие задано через SetCompression.
+// Если сохранять нечего, возвращает ErrNothingToExport, и прежняя выгрузка остаётся в каталоге.
+func (s *Service) export(dir string, format Format) error {
+	if !format.valid() {
+		return fmt.Errorf("%w: %q", ErrUnknownFormat, format)
 	}
-	if len(s.payments) > 0 {
-		var paymentItem string
-		for _, payment := range s.payments {
-			paymentItem += fmt.Sprint(payment.ID) + ";" + fmt.Sprint(payment.Amount) + ";" + fmt.Sprint(payment.Category) + ";" + fmt.Sprint(payment.AccountID) + ";" + fmt.Sprint(payment.Status) + "\n"
-		}
+	unlock, err := lockExportDir(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-		file, err := os.Create(dir + "/payments.dump")
-		if err != nil {
-			log.Print(err)
-			return err
-		}
-		defer func() {
-			if cerr := file.Close(); cerr != nil {
-				log.Print(cerr)
-			}
-		}()
+	err = recoverExport(dir)
+	if err != nil {
+		return err
+	}
+	compression := s.currentCompression()
+	generation := &exportGeneration{dir: dir}
+	defer generation.discard()
 
-		_, err = file.WriteString(paymentItem)
+	accounts, err := s.repository().Accounts()
+	if err != nil {
+		return err
+	}
+	err = generation.write(compressedName(dumpName(entityAccounts, format), compression), len(accounts), func(path string) error {
+		return writeAccounts(path, accounts)
+	})
+	if err != nil {
+		return err
 	}
-	if len(s.favorites) > 0 {
-		var favoriteItem string
-		for _, favorite := range s.favorites {
-			favoriteItem += fmt.Sprint(favorite.ID) + ";" + fmt.Sprint(favorite.Amount) + ";" + fmt.Sprint(favorite.Category) + ";" + fmt.Sprint(favorite.AccountID) + ";" + fmt.Sprint(favorite.Name) + "\n"
-		}
 
-		file, err := os.Create(dir + "/favorites.dump")
-		if err != nil {
-			log.Print(err)
-			return err
-		}
-		defer func() {
-			if cerr := file.Close(); cerr != nil {
-				log.Print(cerr)
-			}
-		}()
+	payments, err := s.repository().Payments()
+	if err != nil {
+		return err
+	}
+	err = generation.write(compressedName(dumpName(entityPayments, format), compression), len(payments), func(path string) error {
+		return writePayments(path, payments)
+	})
+	if err != nil {
+		return err
+	}
 
-		_, err = file.WriteString(favoriteItem)
+	favorites, err := s.repository().Favorites()
+	if err != nil {
+		return err
 	}
-	return nil
+	err = generation.write(compressedName(dumpName(entityFavorites, format), compression), len(favorites), func(path string) error {
+		return writeFavorites(path, favorites)
+	})
+	if err != nil {
+		return err
+	}
+
+	postings, err := s.repository().Postings()
+	if err != nil {
+		return err
+	}
+	err = generation.write(compressedName(dumpName(entityPostings, format), compression), len(postings), func(path string) error {
+		return writePostings(path, postings)
+	})
+	if err != nil {
+		return err
+	}
+
+	keys, err := s.repository().IdempotencyKeys()
+	if err != nil {
+		return err
+	}
+	keys = s.liveIdempotencyKeys(keys)
+	err = generation.write(compressedName(dumpName(entityIdempotency, format), compression), len(keys), func(path string) error {
+		return writeIdempotencyKeys(path, keys)
+	})
+	if err != nil {
+		return err
+	}
+
+	schedules, err := s.repository().Schedules()
+	if err != nil {
+		return err
+	}
+	err = generation.write(compressedName(dumpName(entitySchedules, format), compression), len(schedules), func(path string) error {
+		return writeSchedules(path, schedules)
+	})
+	if err != nil {
+		return err
+	}
+
+	// пустое поколение удалило бы все файлы прежней выгрузки
+	if len(generation.entries) == 0 {
+		return ErrNothingToExport
+	}
+	return generation.commit()
 }
 
+// Import загружает выгрузку Export из каталога dir: уже существующие записи пропускаются.
+// Номера телефонов приводятся к каноническому виду (см. normalizeStoredPhone), запись с неверным номером - ошибка разбора.
 func (s *Service) Import(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	_, err := os.Stat(dir + "/accounts.dump")
-	if err == nil {
-		file, err := os.Open(dir + "/accounts.dump")
-		if err != nil {
-			return err
-		}
-		defer func() {
-			if cerr := file.Close(); cerr != nil {
-				log.Print(cerr)
-			}
-		}()
-		reader := bufio.NewReader(file)
-		for {
-			line, err := reader.ReadString('\n')
+	return s.importDir(dir, nil)
+}
 
-			if err == io.EOF {
-				log.Print(line)
-				break
-			}
+// ImportLenient работает как Import, но записи, которые не удалось разобрать, пропускает
+// и возвращает в отчёте. Ошибки чтения файлов и заголовков по-прежнему прерывают импорт.
+func (s *Service) ImportLenient(dir string) (*ImportReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-			if err != nil {
-				log.Print(err)
-				return err
-			}
-			line = strings.ReplaceAll(line, "\n", "")
-			splitedItem := strings.Split(line, ";")
-			id, err := strconv.ParseInt(splitedItem[0], 10, 64)
-			if err != nil {
-				return err
-			}
-			balance, err := strconv.ParseInt(splitedItem[2], 10, 64)
-			if err != nil {
-				return err
-			}
-			account := &types.Account{ID: id, Phone: types.Phone(splitedItem[1]), Balance: types.Money(balance)}
-			_, err = s.FindAccountByID(account.ID)
-			if err == ErrAccountNotFound {
-				s.accounts = append(s.accounts, account)
-			}
-			s.nextAccountID = int64(len(s.accounts))
-		}
-	}
-	_, err = os.Stat(dir + "/payments.dump")
-	if err == nil {
-		file, err := os.Open(dir + "/payments.dump")
-		if err != nil {
-			return err
-		}
-		defer func() {
-			if cerr := file.Close(); cerr != nil {
-				log.Print(cerr)
-			}
-		}()
-		reader := bufio.NewReader(file)
-		for {
-			line, err := reader.ReadString('\n')
+	report := &ImportReport{}
+	err := s.importDir(dir, report)
+	return report, err
+}
 
-			if err == io.EOF {
-				log.Print(line)
-				break
-			}
+// importDir читает файлы выгрузки из каталога dir. Если report не nil, записи с ошибками пропускаются и попадают в report.
+// Если в каталоге есть манифест, читаются только перечисленные в нём файлы и только если они с ним совпадают.
+func (s *Service) importDir(dir string, report *ImportReport) error {
+	unlock, err := lockExportDir(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-			if err != nil {
-				log.Print(err)
-				return err
-			}
-			line = strings.ReplaceAll(line, "\n", "")
-			splitedItem := strings.Split(line, ";")
-			amount, err := strconv.ParseInt(splitedItem[1], 10, 64)
-			if err != nil {
-				return err
-			}
-			accountID, err := strconv.ParseInt(splitedItem[3], 10, 64)
-			if err != nil {
-				return err
-			}
-			payment := &types.Payment{ID: splitedItem[0], Amount: types.Money(amount), Category: types.PaymentCategory(splitedItem[2]), AccountID: accountID, Status: types.PaymentStatus(splitedItem[4])}
-			_, err = s.FindPaymentByID(payment.ID)
-			if err == ErrPaymentNotFound {
-				s.payments = append(s.payments, payment)
-			}
-		}
+	files, err := generationDumps(dir)
+	if err != nil {
+		return err
 	}
-	_, err = os.Stat(dir + "/favorites.dump")
-	if err == nil {
-		file, err := os.Open(dir + "/favorites.dump")
-		if err != nil {
-			return err
-		}
-		defer func() {
-			if cerr := file.Close(); cerr != nil {
-				log.Print(cerr)
-			}
-		}()
-		reader := bufio.NewReader(file)
-		for {
-			line, err := reader.ReadString('\n')
 
-			if err == io.EOF {
-				log.Print(line)
-				break
-			}
+	sort.SliceStable(files, func(i, j int) bool { return importRank(files[i].name) < importRank(files[j].name) })
 
-			if err != nil {
-				log.Print(err)
-				return err
-			}
-			line = strings.ReplaceAll(line, "\n", "")
-			splitedItem := strings.Split(line, ";")
+	// записи сохраняются по мере чтения, поэтому сначала файлы только разбираются:
+	// импорт с ошибкой в записи или заголовке не должен сохранить часть выгрузки
+	check := &importer{check: true}
+	for _, file := range files {
+		err = check.readDump(file.path, file.name, checkReport(report))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
 
-			amount, err := strconv.ParseInt(splitedItem[1], 10, 64)
-			if err != nil {
-				return err
-			}
-			accountID, err := strconv.ParseInt(splitedItem[3], 10, 64)
-			if err != nil {
-				return err
-			}
-			favorite := &types.Favorite{ID: splitedItem[0], Amount: types.Money(amount), Category: types.PaymentCategory(splitedItem[2]), AccountID: accountID, Name: splitedItem[4]}
-			_, err = s.FindFavoriteByID(favorite.ID)
-			if err == ErrFavoriteNotFound {
-				s.favorites = append(s.favorites, favorite)
-			}
+	im, err := s.newImporter()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		err = im.readDump(file.path, file.name, report)
+		if err != nil && !os.IsNotExist(err) {
+			return err
 		}
 	}
-	return nil
+	return im.flush()
 }
 
 func (s *Service) ExportAccountHistory(accountID int64) ([]types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	account, err := s.FindAccountByID(accountID)
+	account, err := s.findAccountByID(accountID)
 	if err != nil {
 		return nil, ErrAccountNotFound
 	}
 
+	accountPayments, err := s.repository().PaymentsByAccount(account.ID)
+	if err != nil {
+		return nil, err
+	}
+
 	findedPayments := []types.Payment{}
 
-	for _, payment := range s.payments {
-		if payment.AccountID == account.ID {
-			findedPayments = append(findedPayments, types.Payment{
-				ID:        payment.ID,
-				AccountID: payment.AccountID,
-				Status:    payment.Status,
-				Category:  payment.Category,
-				Amount:    payment.Amount,
-			})
-		}
+	for _, payment := range accountPayments {
+		findedPayments = append(findedPayments, types.Payment{
+			ID:        payment.ID,
+			AccountID: payment.AccountID,
+			Status:    payment.Status,
+			Category:  payment.Category,
+			Amount:    payment.Amount,
+			Currency:  payment.Currency,
+			LinkedID:  payment.LinkedID,
+			CreatedAt: payment.CreatedAt,
+			UpdatedAt: payment.UpdatedAt,
+		})
 	}
 
 	return findedPayments, nil
 }
 
+// HistoryToFiles записывает payments в каталог dir шардами не больше чем по records платежей (см. HistoryToShards).
 func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records int) error {
-	if len(payments) > 0 {
-		if records >= len(payments) {
-			var paymentItem string
-
-			for _, payment := range payments {
-				paymentItem += fmt.Sprint(payment.ID) + ";" + fmt.Sprint(payment.Amount) + ";" + fmt.Sprint(payment.Category) + ";" + fmt.Sprint(payment.AccountID) + ";" + fmt.Sprint(payment.Status) + "\n"
-			}
-
-			file, err := os.Create(dir + "/payments.dump")
-			if err != nil {
-				log.Print(err)
-				return err
-			}
-
-			defer func() {
-				if cerr := file.Close(); cerr != nil {
-					log.Print(cerr)
-				}
-			}()
-
-			_, err = file.WriteString(paymentItem)
-		} else {
-			var paymentItem string
-			counter := 1
-			counterForPayments := 0
-			for _, payment := range payments {
-				paymentItem += fmt.Sprint(payment.ID) + ";" + fmt.Sprint(payment.Amount) + ";" + fmt.Sprint(payment.Category) + ";" + fmt.Sprint(payment.AccountID) + ";" + fmt.Sprint(payment.Status) + "\n"
+	return s.HistoryToShards(payments, dir, HistoryOptions{Records: records})
+}
 
-				file, err := os.Create(dir + "/payments" + fmt.Sprint(counter) + ".dump")
-				if err != nil {
-					log.Print(err)
-					return err
-				}
+// SumPayments складывает суммы платежей без учёта валют. Перевод учитывается один раз (см. inPaymentsSum).
+// Если есть счета в разных валютах, используйте SumPaymentsIn.
+func (s *Service) SumPayments(goroutines int) types.Money {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-				defer func() {
-					if cerr := file.Close(); cerr != nil {
-						log.Print(cerr)
-					}
-				}()
-
-				_, err = file.WriteString(paymentItem)
-				counterForPayments++
-				if counterForPayments >= records {
-					counter++
-					counterForPayments = 0
-					paymentItem = ""
-				}
-			}
-		}
+	payments, err := s.repository().Payments()
+	if err != nil {
+		log.Print(err)
+		return 0
 	}
-	return nil
-}
-func (s *Service) SumPayments(goroutines int) types.Money {
 
 	mu := sync.Mutex{}
 	sum := types.Money(0)
@@ -584,8 +862,10 @@ func (s *Service) SumPayments(goroutines int) types.Money {
 		go func() {
 			defer wg.Done()
 			val := types.Money(0)
-			for _, payment := range s.payments {
-				val += payment.Amount
+			for _, payment := range payments {
+				if inPaymentsSum(payment) {
+					val += payment.Amount
+				}
 			}
 			mu.Lock()
 			defer mu.Unlock()
@@ -596,7 +876,10 @@ func (s *Service) SumPayments(goroutines int) types.Money {
 	}
 	wg := sync.WaitGroup{}
 
-	for _, vp := range s.payments {
+	for _, vp := range payments {
+		if !inPaymentsSum(vp) {
+			continue
+		}
 		wg.Add(1)
 		go func(vp *types.Payment) {
 			defer wg.Done()
@@ -614,20 +897,34 @@ func (s *Service) SumPayments(goroutines int) types.Money {
 	return sum
 }
 
+// inPaymentsSum сообщает, входит ли payment в суммы платежей. Перевод состоит из двух платежей
+// на одну сумму, поэтому учитывается только его исходящая часть.
+func inPaymentsSum(payment *types.Payment) bool {
+	return payment.Category != types.PaymentCategoryTransferIn
+}
+
 func (s *Service) FilterPaymentsForGoroutines(goroutinesCount int, accountID int64) ([][]types.Payment, error) {
-	_, err := s.FindAccountByID(accountID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.filterPaymentsForGoroutines(goroutinesCount, accountID)
+}
+
+func (s *Service) filterPaymentsForGoroutines(goroutinesCount int, accountID int64) ([][]types.Payment, error) {
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	accountPayments, err := s.repository().PaymentsByAccount(accountID)
 	if err != nil {
 		return nil, err
 	}
 	pm := []types.Payment{}
 
-	for _, p := range s.payments {
-
-		if p.AccountID == accountID {
+	for _, p := range accountPayments {
 
-			pm = append(pm, *p)
+		pm = append(pm, *p)
 
-		}
 	}
 
 	grouped := [][]types.Payment{}
@@ -649,10 +946,16 @@ func (s *Service) FilterPaymentsForGoroutines(goroutinesCount int, accountID int
 	return grouped, nil
 }
 func (s *Service) FilterPaymentsForG(goroutinesCount int) ([][]types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
+	payments, err := s.repository().Payments()
+	if err != nil {
+		return nil, err
+	}
 	pm := []types.Payment{}
 
-	for _, p := range s.payments {
+	for _, p := range payments {
 
 		pm = append(pm, *p)
 
@@ -678,7 +981,15 @@ func (s *Service) FilterPaymentsForG(goroutinesCount int) ([][]types.Payment, er
 }
 
 func (s *Service) FilterPayments(accountID int64, goroutines int) ([]types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if goroutines == 0 {
+		accountPayments, err := s.repository().PaymentsByAccount(accountID)
+		if err != nil {
+			return nil, err
+		}
+
 		mu := sync.Mutex{}
 		payments := []types.Payment{}
 
@@ -688,10 +999,8 @@ func (s *Service) FilterPayments(accountID int64, goroutines int) ([]types.Payme
 		go func() {
 			defer wg.Done()
 			val := []types.Payment{}
-			for _, payment := range s.payments {
-				if accountID == payment.AccountID {
-					val = append(payments, *payment)
-				}
+			for _, payment := range accountPayments {
+				val = append(val, *payment)
 			}
 			mu.Lock()
 			defer mu.Unlock()
@@ -714,7 +1023,7 @@ func (s *Service) FilterPayments(accountID int64, goroutines int) ([]types.Payme
 	mu := sync.Mutex{}
 	payments := []types.Payment{}
 
-	filteredPayments, err := s.FilterPaymentsForGoroutines(goroutines, accountID)
+	filteredPayments, err := s.filterPaymentsForGoroutines(goroutines, accountID)
 	if err != nil {
 		return nil, err
 	}
@@ -741,6 +1050,14 @@ func (s *Service) FilterPayments(accountID int64, goroutines int) ([]types.Payme
 }
 
 func (s *Service) FilterPaymentsByFn(filter func(payment types.Payment) bool, goroutines int) ([]types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all, err := s.repository().Payments()
+	if err != nil {
+		return nil, err
+	}
+
 	if goroutines == 0 {
 		mu := sync.Mutex{}
 		payments := []types.Payment{}
@@ -749,9 +1066,9 @@ func (s *Service) FilterPaymentsByFn(filter func(payment types.Payment) bool, go
 		go func() {
 			defer wg.Done()
 			val := []types.Payment{}
-			for _, payment := range s.payments {
+			for _, payment := range all {
 				if filter(*payment) {
-					val = append(payments, *payment)
+					val = append(val, *payment)
 				}
 			}
 			mu.Lock()
@@ -770,7 +1087,7 @@ func (s *Service) FilterPaymentsByFn(filter func(payment types.Payment) bool, go
 	mu := sync.Mutex{}
 	payments := []types.Payment{}
 	pm := []types.Payment{}
-	for _, p := range s.payments {
+	for _, p := range all {
 		if filter(*p) {
 			pm = append(pm, *p)
 		}
@@ -806,9 +1123,22 @@ func (s *Service) FilterPaymentsByFn(filter func(payment types.Payment) bool, go
 //SumPaymentsWithProgress ...
 func (s *Service) SumPaymentsWithProgress() <-chan types.Progress {
 
+	// Берём снимок платежей, чтобы не держать блокировку, пока читают канал
+	s.mu.RLock()
+	payments, err := s.repository().Payments()
+	if err != nil {
+		log.Print(err)
+	}
+	snapshot := make([]*types.Payment, len(payments))
+	for i, payment := range payments {
+		copied := *payment
+		snapshot[i] = &copied
+	}
+	s.mu.RUnlock()
+
 	ch := make(chan types.Progress)
 	size := 100_000
-	parts := len(s.payments) / size
+	parts := len(snapshot) / size
 	wg := sync.WaitGroup{}
 	// Если части меньше чем ноль то части = 1
 	if parts < 1 {
@@ -819,14 +1149,14 @@ func (s *Service) SumPaymentsWithProgress() <-chan types.Progress {
 		wg.Add(1)
 		var payments []*types.Payment
 
-		if len(s.payments) < size {
+		if len(snapshot) < size {
 			// если если платежей меньше чем
 			// 100_000 то создаётся одна горутина и ей отдаём всё
-			payments = s.payments
+			payments = snapshot
 		} else {
 			//  если если платежей больше чем чем 100_000 то
 			//  отдаём платежи по равным частям, (последнему может достатся меньше)
-			payments = s.payments[i*size : (i+1)*size]
+			payments = snapshot[i*size : (i+1)*size]
 		}
 
 		go func(ch chan types.Progress, data []*types.Payment) {
@@ -834,7 +1164,9 @@ func (s *Service) SumPaymentsWithProgress() <-chan types.Progress {
 			val := types.Money(0)
 			//Сумируем то что передано по параметам в одну переменную
 			for _, v := range data {
-				val += v.Amount
+				if inPaymentsSum(v) {
+					val += v.Amount
+				}
 			}
 			// Записываем в канал
 			ch <- types.Progress{
@@ -845,14 +1177,16 @@ func (s *Service) SumPaymentsWithProgress() <-chan types.Progress {
 		}(ch, payments)
 	}
 	//Если платежей больше чем size
-	if len(s.payments) > size {
+	if len(snapshot) > size {
 		wg.Add(1)
-		payments := s.payments
+		payments := snapshot
 		go func(ch chan types.Progress, data []*types.Payment) {
 			defer wg.Done()
 			val := types.Money(0)
 			for _, v := range data {
-				val += v.Amount
+				if inPaymentsSum(v) {
+					val += v.Amount
+				}
 			}
 			ch <- types.Progress{
 				Part:   len(data),
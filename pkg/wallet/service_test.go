@@ -1,12 +1,232 @@
 package wallet
 
 import (
+	"errors"
+	"fmt"
 	"github.com/akhrorov/wallet/pkg/types"
 	"github.com/google/uuid"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
+type testExampleAccount struct {
+	phone    types.Phone
+	balance  types.Money
+	payments []struct {
+		amount   types.Money
+		category types.PaymentCategory
+	}
+}
+
+var defaultExampleTestAccount = testExampleAccount{
+	phone:   "+992900000001",
+	balance: 10_000_00,
+	payments: []struct {
+		amount   types.Money
+		category types.PaymentCategory
+	}{
+		{amount: 1_000_00, category: "auto"},
+	},
+}
+
+var defaultExampleTestAccount2 = testExampleAccount{
+	phone:   "+992900000002",
+	balance: 10_000_00,
+	payments: []struct {
+		amount   types.Money
+		category types.PaymentCategory
+	}{
+		{amount: 1_000_00, category: "auto"},
+	},
+}
+
+func (s *Service) addAccount(data testExampleAccount) (*types.Account, []*types.Payment, error) {
+	account, err := s.RegisterAccount(data.phone)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't register account, error = %v", err)
+	}
+
+	err = s.Deposit(account.ID, data.balance)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't deposit account, error = %v", err)
+	}
+
+	payments := make([]*types.Payment, len(data.payments))
+	for i, payment := range data.payments {
+		payments[i], err = s.Pay(account.ID, payment.amount, payment.category)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't make payment, error = %v", err)
+		}
+	}
+
+	// RegisterAccount вернул счёт до пополнения и платежей
+	account, err = s.FindAccountByID(account.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't find account, error = %v", err)
+	}
+	return account, payments, nil
+}
+
+// currentAccount возвращает сохранённое состояние счёта accountID.
+// Ранее возвращённые сервисом указатели после изменений не обновляются.
+func currentAccount(t *testing.T, service *Service, accountID int64) *types.Account {
+	t.Helper()
+	account, err := service.FindAccountByID(accountID)
+	if err != nil {
+		t.Fatalf("FindAccountByID(): can't find account %d, %v", accountID, err)
+	}
+	return account
+}
+
+// currentPayment возвращает сохранённое состояние платежа paymentID.
+func currentPayment(t *testing.T, service *Service, paymentID string) *types.Payment {
+	t.Helper()
+	payment, err := service.FindPaymentByID(paymentID)
+	if err != nil {
+		t.Fatalf("FindPaymentByID(): can't find payment %s, %v", paymentID, err)
+	}
+	return payment
+}
+
+func TestService_concurrent_RegisterAccount(t *testing.T) {
+	service := &Service{}
+	count := 100
+
+	wg := sync.WaitGroup{}
+	wg.Add(count)
+	for i := 0; i < count; i++ {
+		go func(i int) {
+			defer wg.Done()
+			_, err := service.RegisterAccount(types.Phone(fmt.Sprintf("+9929000%05d", i)))
+			if err != nil {
+				t.Errorf("RegisterAccount(): can't register account, %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	ids := map[int64]bool{}
+	for i := int64(1); i <= int64(count); i++ {
+		account, err := service.FindAccountByID(i)
+		if err != nil {
+			t.Fatalf("RegisterAccount(): can't find account %d, %v", i, err)
+		}
+		if ids[account.ID] {
+			t.Fatalf("RegisterAccount(): duplicate account id %d", account.ID)
+		}
+		ids[account.ID] = true
+	}
+}
+
+func TestService_concurrent_Pay_noDoubleSpend(t *testing.T) {
+	service := &Service{}
+	account, err := service.RegisterAccount("+992900000001")
+	if err != nil {
+		t.Fatalf("Pay(): can't register account, %v", err)
+	}
+	err = service.Deposit(account.ID, 100)
+	if err != nil {
+		t.Fatalf("Pay(): can't deposit account, %v", err)
+	}
+
+	mu := sync.Mutex{}
+	succeeded := 0
+	wg := sync.WaitGroup{}
+	wg.Add(200)
+	for i := 0; i < 200; i++ {
+		go func() {
+			defer wg.Done()
+			_, err := service.Pay(account.ID, 1, "food")
+			if err == ErrNotEnoughBalance {
+				return
+			}
+			if err != nil {
+				t.Errorf("Pay(): unexpected error, %v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			succeeded++
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 100 {
+		t.Errorf("Pay(): want 100 successful payments, result %v", succeeded)
+	}
+	saved, err := service.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatalf("Pay(): can't find account, %v", err)
+	}
+	if saved.Balance != 0 {
+		t.Errorf("Pay(): want balance 0, result %v", saved.Balance)
+	}
+	if sum := service.SumPayments(1); sum != 100 {
+		t.Errorf("Pay(): want payments sum 100, result %v", sum)
+	}
+}
+
+func TestService_concurrent_balanceNotLost(t *testing.T) {
+	service := &Service{}
+	account, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	before, err := service.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatalf("FindAccountByID(): can't find account, %v", err)
+	}
+	start := before.Balance
+
+	workers := 50
+	wg := sync.WaitGroup{}
+	wg.Add(workers * 4)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			if err := service.Deposit(account.ID, 10); err != nil {
+				t.Errorf("Deposit(): can't deposit, %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			payment, err := service.Pay(account.ID, 10, "food")
+			if err != nil {
+				t.Errorf("Pay(): can't pay, %v", err)
+				return
+			}
+			if err := service.Reject(payment.ID); err != nil {
+				t.Errorf("Reject(): can't reject, %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			service.SumPayments(3)
+			for range service.SumPaymentsWithProgress() {
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = service.FilterPayments(account.ID, 2)
+			_, _ = service.ExportAccountHistory(account.ID)
+		}()
+	}
+	wg.Wait()
+
+	after, err := service.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatalf("FindAccountByID(): can't find account, %v", err)
+	}
+	if want := start + types.Money(workers*10); after.Balance != want {
+		t.Errorf("concurrent operations: want balance %v, result %v", want, after.Balance)
+	}
+}
+
 func TestService_SumPaymentsWithProgress(t *testing.T) {
 	service := &Service{}
 	account, _, err := service.addAccount(defaultExampleTestAccount)
@@ -31,6 +251,38 @@ func TestService_SumPaymentsWithProgress(t *testing.T) {
 		sum += int(value.Result)
 	}
 }
+func TestService_FilterPayments_allPayments(t *testing.T) {
+	service := &Service{}
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	_, _, err = service.addAccount(defaultExampleTestAccount2)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		payment, err := service.Pay(account.ID, 1_00, "food")
+		if err != nil {
+			t.Fatalf("Pay(): can't pay, %v", err)
+		}
+		payments = append(payments, payment)
+	}
+
+	for _, goroutines := range []int{0, 2} {
+		got, err := service.FilterPayments(account.ID, goroutines)
+		if err != nil || len(got) != len(payments) {
+			t.Errorf("FilterPayments(%d): want %d payments, result %v, %v", goroutines, len(payments), got, err)
+		}
+		got, err = service.FilterPaymentsByFn(func(payment types.Payment) bool {
+			return payment.AccountID == account.ID
+		}, goroutines)
+		if err != nil || len(got) != len(payments) {
+			t.Errorf("FilterPaymentsByFn(%d): want %d payments, result %v, %v", goroutines, len(payments), got, err)
+		}
+	}
+}
+
 //func TestService_FilterPaymentsFn_success(t *testing.T) {
 //	service := &Service{}
 //	account, _, err := service.addAccount(defaultExampleTestAccount)
@@ -119,6 +371,308 @@ func BenchmarkService_SumPayments(b *testing.B) {
 	}
 }
 
+// dumpBenchmarkSizes - число счетов (и платежей) в бенчмарках выгрузки: время на запись должно оставаться постоянным.
+var dumpBenchmarkSizes = []int{1_000, 10_000, 100_000}
+
+// dumpTestService возвращает сервис с count счетами, у каждого из которых один платёж.
+func dumpTestService(b *testing.B, count int) *Service {
+	b.Helper()
+	service := &Service{}
+	for i := 0; i < count; i++ {
+		account, err := service.RegisterAccount(types.Phone(fmt.Sprintf("+992%09d", i)))
+		if err != nil {
+			b.Fatalf("can't register account, %v", err)
+		}
+		err = service.Deposit(account.ID, 1_000)
+		if err != nil {
+			b.Fatalf("can't deposit account, %v", err)
+		}
+		_, err = service.Pay(account.ID, 1_000, "food")
+		if err != nil {
+			b.Fatalf("can't pay, %v", err)
+		}
+	}
+	return service
+}
+
+// dirSize возвращает суммарный размер файлов каталога dir.
+func dirSize(b *testing.B, dir string) int64 {
+	b.Helper()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		b.Fatalf("can't read dir, %v", err)
+	}
+	size := int64(0)
+	for _, file := range files {
+		size += file.Size()
+	}
+	return size
+}
+
+func BenchmarkService_Export(b *testing.B) {
+	for _, count := range dumpBenchmarkSizes {
+		b.Run(fmt.Sprint(count), func(b *testing.B) {
+			b.ReportAllocs()
+			service := dumpTestService(b, count)
+			dir := b.TempDir()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := service.Export(dir)
+				if err != nil {
+					b.Fatalf("Export(): can't export, %v", err)
+				}
+			}
+			b.SetBytes(dirSize(b, dir))
+		})
+	}
+}
+
+func BenchmarkService_Import(b *testing.B) {
+	for _, count := range dumpBenchmarkSizes {
+		b.Run(fmt.Sprint(count), func(b *testing.B) {
+			b.ReportAllocs()
+			dir := b.TempDir()
+			err := dumpTestService(b, count).Export(dir)
+			if err != nil {
+				b.Fatalf("Export(): can't export, %v", err)
+			}
+			b.SetBytes(dirSize(b, dir))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := (&Service{}).Import(dir)
+				if err != nil {
+					b.Fatalf("Import(): can't import, %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkService_Import_journal - импорт с открытым журналом: запись в журнал должна оставаться линейной.
+func BenchmarkService_Import_journal(b *testing.B) {
+	for _, count := range dumpBenchmarkSizes {
+		b.Run(fmt.Sprint(count), func(b *testing.B) {
+			b.ReportAllocs()
+			dir := b.TempDir()
+			err := dumpTestService(b, count).Export(dir)
+			if err != nil {
+				b.Fatalf("Export(): can't export, %v", err)
+			}
+			b.SetBytes(dirSize(b, dir))
+			journals := b.TempDir()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				service := &Service{}
+				err := service.OpenJournal(filepath.Join(journals, fmt.Sprintf("wallet%d.journal", i)))
+				if err != nil {
+					b.Fatalf("OpenJournal(): can't open journal, %v", err)
+				}
+				err = service.Import(dir)
+				if err != nil {
+					b.Fatalf("Import(): can't import, %v", err)
+				}
+				err = service.CloseJournal()
+				if err != nil {
+					b.Fatalf("CloseJournal(): can't close journal, %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkService_ExportSnapshot(b *testing.B) {
+	for _, count := range dumpBenchmarkSizes {
+		b.Run(fmt.Sprint(count), func(b *testing.B) {
+			b.ReportAllocs()
+			service := dumpTestService(b, count)
+			dir := b.TempDir()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := service.ExportSnapshot(filepath.Join(dir, "wallet.snapshot"))
+				if err != nil {
+					b.Fatalf("ExportSnapshot(): can't export, %v", err)
+				}
+			}
+			b.SetBytes(dirSize(b, dir))
+		})
+	}
+}
+
+func BenchmarkService_ImportSnapshot(b *testing.B) {
+	for _, count := range dumpBenchmarkSizes {
+		b.Run(fmt.Sprint(count), func(b *testing.B) {
+			b.ReportAllocs()
+			dir := b.TempDir()
+			err := dumpTestService(b, count).ExportSnapshot(filepath.Join(dir, "wallet.snapshot"))
+			if err != nil {
+				b.Fatalf("ExportSnapshot(): can't export, %v", err)
+			}
+			b.SetBytes(dirSize(b, dir))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := (&Service{}).ImportSnapshot(filepath.Join(dir, "wallet.snapshot"))
+				if err != nil {
+					b.Fatalf("ImportSnapshot(): can't import, %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkService_ImportFromFile(b *testing.B) {
+	for _, count := range dumpBenchmarkSizes {
+		b.Run(fmt.Sprint(count), func(b *testing.B) {
+			b.ReportAllocs()
+			dir := b.TempDir()
+			err := dumpTestService(b, count).ExportToFile(filepath.Join(dir, "accounts.txt"))
+			if err != nil {
+				b.Fatalf("ExportToFile(): can't export, %v", err)
+			}
+			b.SetBytes(dirSize(b, dir))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := (&Service{}).ImportFromFile(filepath.Join(dir, "accounts.txt"))
+				if err != nil {
+					b.Fatalf("ImportFromFile(): can't import, %v", err)
+				}
+			}
+		})
+	}
+}
+
+var (
+	largeServiceOnce sync.Once
+	largeService     *Service
+	largePaymentIDs  []string
+)
+
+// largeTestService возвращает сервис с 1_000_000 счетов и 1_000_000 платежей.
+func largeTestService(b *testing.B) (*Service, []string) {
+	largeServiceOnce.Do(func() {
+		service := &Service{}
+		count := 1_000_000
+		ids := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			account, err := service.RegisterAccount(types.Phone(fmt.Sprintf("+992%09d", i)))
+			if err != nil {
+				b.Fatalf("can't register account, %v", err)
+			}
+			err = service.Deposit(account.ID, 1_000)
+			if err != nil {
+				b.Fatalf("can't deposit account, %v", err)
+			}
+			payment, err := service.Pay(account.ID, 1_000, "food")
+			if err != nil {
+				b.Fatalf("can't pay, %v", err)
+			}
+			ids = append(ids, payment.ID)
+		}
+		largeService = service
+		largePaymentIDs = ids
+	})
+	return largeService, largePaymentIDs
+}
+
+func BenchmarkService_FindAccountByID(b *testing.B) {
+	service, _ := largeTestService(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := service.FindAccountByID(int64(i%1_000_000) + 1)
+		if err != nil {
+			b.Fatalf("FindAccountByID(): can't find account, %v", err)
+		}
+	}
+}
+
+func BenchmarkService_FindPaymentByID(b *testing.B) {
+	service, ids := largeTestService(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := service.FindPaymentByID(ids[i%len(ids)])
+		if err != nil {
+			b.Fatalf("FindPaymentByID(): can't find payment, %v", err)
+		}
+	}
+}
+
+func BenchmarkService_RegisterAccount_duplicate(b *testing.B) {
+	service, _ := largeTestService(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := service.RegisterAccount(types.Phone(fmt.Sprintf("+992%09d", i%1_000_000)))
+		if err != ErrPhoneRegistered {
+			b.Fatalf("RegisterAccount(): must return ErrPhoneRegistered, returned %v", err)
+		}
+	}
+}
+
+func BenchmarkService_ExportAccountHistory(b *testing.B) {
+	service, _ := largeTestService(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		payments, err := service.ExportAccountHistory(int64(i%1_000_000) + 1)
+		if err != nil || len(payments) != 1 {
+			b.Fatalf("ExportAccountHistory(): want 1 payment, result %v, %v", payments, err)
+		}
+	}
+}
+
+func TestService_FindFavoriteByID_success(t *testing.T) {
+	service := &Service{}
+
+	_, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Errorf("addAccount(): can't add account, error = %v", err)
+		return
+	}
+
+	favorite, err := service.FavoritePayment(payments[0].ID, "something")
+	if err != nil {
+		t.Errorf("FavoritePayment(): can't create favorite, error = %v", err)
+		return
+	}
+
+	got, err := service.FindFavoriteByID(favorite.ID)
+	if err != nil {
+		t.Errorf("FindFavoriteByID(): can't find favorite, error = %v", err)
+		return
+	}
+	if !reflect.DeepEqual(favorite, got) {
+		t.Errorf("FindFavoriteByID(): wrong favorite returned, %v", got)
+	}
+}
+
+func TestService_ImportFromFile_skipsDuplicates(t *testing.T) {
+	service := &Service{}
+	_, err := service.RegisterAccount("+992001010522")
+	if err != nil {
+		t.Fatalf("ImportFromFile(): can't register account, %v", err)
+	}
+
+	path := t.TempDir() + "/accounts.txt"
+	err = service.ExportToFile(path)
+	if err != nil {
+		t.Fatalf("ImportFromFile(): can't export accounts, %v", err)
+	}
+	err = service.ExportToFile(path)
+	if err != nil {
+		t.Fatalf("ImportFromFile(): can't export accounts, %v", err)
+	}
+
+	imported := &Service{}
+	err = imported.ImportFromFile(path)
+	if err != nil {
+		t.Fatalf("ImportFromFile(): can't import file, %v", err)
+	}
+	accounts, err := imported.repository().Accounts()
+	if err != nil {
+		t.Fatalf("ImportFromFile(): can't get accounts, %v", err)
+	}
+	if len(accounts) != 1 {
+		t.Errorf("ImportFromFile(): want 1 account, result %v", len(accounts))
+	}
+}
+
 func TestService_SumPayments(t *testing.T) {
 	service := &Service{}
 	account, _, err := service.addAccount(defaultExampleTestAccount)
@@ -168,10 +722,18 @@ func TestService_Import(t *testing.T) {
 		t.Errorf("Import(): can't Export Account History, %v", err)
 	}
 
-	err = service.HistoryToFiles(paymentss, "data", 4)
+	dir := t.TempDir()
+	err = service.HistoryToFiles(paymentss, dir, 4)
 	if err != nil {
 		t.Errorf("Import(): can't Export Account History, %v", err)
 	}
+	// история из одного шарда тоже пишется в payments1.dump: payments.dump - файл Export
+	if _, err := os.Stat(filepath.Join(dir, "payments1.dump")); err != nil {
+		t.Errorf("HistoryToFiles(): payments1.dump must be written, %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, paymentsDump)); !os.IsNotExist(err) {
+		t.Errorf("HistoryToFiles(): %s must not be written, %v", paymentsDump, err)
+	}
 
 	//err = service.Import("accounts.dump")
 	//if err != nil {
@@ -188,7 +750,7 @@ func TestService_Export(t *testing.T) {
 		return
 	}
 
-	err = service.Export("data")
+	err = service.Export(t.TempDir())
 	if err != nil {
 		t.Errorf("Export(): can't Export, %v", err)
 	}
@@ -204,12 +766,13 @@ func TestService_ImportFromFile(t *testing.T) {
 		t.Errorf("ImportFromFile(): can't register account, %v", err)
 	}
 
-	err = service.ExportToFile("accounts.txt")
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+	err = service.ExportToFile(path)
 	if err != nil {
 		t.Errorf("ImportFromFile(): can't export accounts, %v", err)
 	}
 
-	err = service.ImportFromFile("accounts.txt")
+	err = service.ImportFromFile(path)
 	if err != nil {
 		t.Errorf("ImportFromFile(): can't import file")
 	}
@@ -225,7 +788,7 @@ func TestService_ExportToFile(t *testing.T) {
 		t.Errorf("ExportToFile(): can't register account, %v", err)
 	}
 
-	err = service.ExportToFile("accounts.txt")
+	err = service.ExportToFile(filepath.Join(t.TempDir(), "accounts.txt"))
 	if err != nil {
 		t.Errorf("ExportToFile(): can't export accounts, %v", err)
 	}
@@ -423,7 +986,7 @@ func TestService_Reject_success(t *testing.T) {
 		return
 	}
 
-	if savedAccount.Balance != account.Balance {
+	if savedAccount.Balance != account.Balance+payment.Amount {
 		t.Errorf("Reject(): balance didn't changed, account = %v", savedAccount)
 		return
 	}
@@ -612,3 +1175,295 @@ func TestService_PayFromFavorite_fail(t *testing.T) {
 	}
 
 }
+
+func TestService_Confirm_success(t *testing.T) {
+	service := &Service{}
+
+	_, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Errorf("Confirm(): can't register account = %v", err)
+		return
+	}
+
+	err = service.Confirm(payments[0].ID)
+	if err != nil {
+		t.Errorf("Confirm(): can't confirm payment, error = %v", err)
+		return
+	}
+	payment, err := service.FindPaymentByID(payments[0].ID)
+	if err != nil || payment.Status != types.PaymentStatusOk {
+		t.Errorf("Confirm(): status didn't changed, payment = %v", payment)
+	}
+}
+
+func TestService_Confirm_fail(t *testing.T) {
+	service := &Service{}
+
+	_, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Errorf("Confirm(): can't register account = %v", err)
+		return
+	}
+
+	err = service.Confirm(uuid.New().String())
+	if err != ErrPaymentNotFound {
+		t.Errorf("Confirm(): must return ErrPaymentNotFound, returned = %v", err)
+	}
+
+	payment := payments[0]
+	err = service.Reject(payment.ID)
+	if err != nil {
+		t.Errorf("Confirm(): can't reject payment, error = %v", err)
+		return
+	}
+	err = service.Confirm(payment.ID)
+	if !errors.Is(err, types.ErrInvalidStatusTransition) {
+		t.Errorf("Confirm(): must return ErrInvalidStatusTransition, returned = %v", err)
+	}
+}
+
+func TestService_Reject_twice(t *testing.T) {
+	service := &Service{}
+
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Errorf("Reject(): can't register account = %v", err)
+		return
+	}
+
+	payment := payments[0]
+	err = service.Reject(payment.ID)
+	if err != nil {
+		t.Errorf("Reject(): can't reject payment, error = %v", err)
+		return
+	}
+	balance := account.Balance
+
+	err = service.Reject(payment.ID)
+	if !errors.Is(err, types.ErrInvalidStatusTransition) {
+		t.Errorf("Reject(): must return ErrInvalidStatusTransition, returned = %v", err)
+	}
+	if account.Balance != balance {
+		t.Errorf("Reject(): payment refunded twice, account = %v", account)
+	}
+}
+
+func TestService_Reject_confirmed(t *testing.T) {
+	service := &Service{}
+
+	_, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Errorf("Reject(): can't register account = %v", err)
+		return
+	}
+
+	payment := payments[0]
+	err = service.Confirm(payment.ID)
+	if err != nil {
+		t.Errorf("Reject(): can't confirm payment, error = %v", err)
+		return
+	}
+	err = service.Reject(payment.ID)
+	if !errors.Is(err, types.ErrInvalidStatusTransition) {
+		t.Errorf("Reject(): must return ErrInvalidStatusTransition, returned = %v", err)
+	}
+	err = service.Expire(payment.ID)
+	if !errors.Is(err, types.ErrInvalidStatusTransition) {
+		t.Errorf("Expire(): must return ErrInvalidStatusTransition, returned = %v", err)
+	}
+}
+
+func TestService_Expire_success(t *testing.T) {
+	service := &Service{}
+
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Errorf("Expire(): can't register account = %v", err)
+		return
+	}
+	balance := account.Balance
+
+	err = service.Expire(payments[0].ID)
+	if err != nil {
+		t.Errorf("Expire(): can't expire payment, error = %v", err)
+		return
+	}
+	payment, err := service.FindPaymentByID(payments[0].ID)
+	if err != nil || payment.Status != types.PaymentStatusExpired {
+		t.Errorf("Expire(): status didn't changed, payment = %v", payment)
+	}
+	account, err = service.FindAccountByID(account.ID)
+	if err != nil || account.Balance != balance+payment.Amount {
+		t.Errorf("Expire(): money didn't returned, account = %v", account)
+	}
+}
+
+func TestService_Confirm_transfer(t *testing.T) {
+	service := &Service{}
+	from, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	to, _, err := service.addAccount(defaultExampleTestAccount2)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+
+	outgoing, err := service.Transfer(from.ID, to.ID, 100)
+	if err != nil {
+		t.Fatalf("Transfer(): can't transfer, %v", err)
+	}
+	err = service.Confirm(outgoing.ID)
+	if err != nil {
+		t.Fatalf("Confirm(): can't confirm transfer, %v", err)
+	}
+	incoming, err := service.FindPaymentByID(outgoing.LinkedID)
+	if err != nil {
+		t.Fatalf("FindPaymentByID(): can't find linked payment, %v", err)
+	}
+	if incoming.Status != types.PaymentStatusOk {
+		t.Errorf("Confirm(): both legs must be confirmed, incoming = %v", incoming)
+	}
+	err = service.Reject(incoming.ID)
+	if !errors.Is(err, types.ErrInvalidStatusTransition) {
+		t.Errorf("Reject(): must return ErrInvalidStatusTransition, returned = %v", err)
+	}
+}
+
+func TestService_SetClock(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	service := &Service{}
+	service.SetClock(func() time.Time { return now })
+
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	if !account.CreatedAt.Equal(now) || !payments[0].CreatedAt.Equal(now) {
+		t.Errorf("SetClock(): want created at %v, account %v, payment %v", now, account.CreatedAt, payments[0].CreatedAt)
+	}
+
+	now = now.Add(time.Hour)
+	err = service.Confirm(payments[0].ID)
+	if err != nil {
+		t.Fatalf("Confirm(): can't confirm payment, %v", err)
+	}
+	payment, err := service.FindPaymentByID(payments[0].ID)
+	if err != nil || !payment.UpdatedAt.Equal(now) || payment.CreatedAt.Equal(now) {
+		t.Errorf("Confirm(): want updated at %v, payment %v", now, payment)
+	}
+
+	err = service.Deposit(account.ID, 1_00)
+	if err != nil {
+		t.Fatalf("Deposit(): can't deposit, %v", err)
+	}
+	account, err = service.FindAccountByID(account.ID)
+	if err != nil || !account.UpdatedAt.Equal(now) {
+		t.Errorf("Deposit(): want updated at %v, account %v", now, account)
+	}
+	postings, err := service.repository().Postings()
+	if err != nil {
+		t.Fatalf("Postings(): can't get postings, %v", err)
+	}
+	if last := postings[len(postings)-1]; last.Reason != types.PostingReasonDeposit || !last.CreatedAt.Equal(now) {
+		t.Errorf("Deposit(): want deposit posting at %v, result %v", now, last)
+	}
+}
+
+func TestService_Export_timestamps(t *testing.T) {
+	dir := t.TempDir()
+	service := &Service{}
+	service.SetClock(func() time.Time { return time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC) })
+	account, payments, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	favorite, err := service.FavoritePayment(payments[0].ID, "my car")
+	if err != nil {
+		t.Fatalf("FavoritePayment(): can't create favorite, %v", err)
+	}
+
+	err = service.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): can't export, %v", err)
+	}
+	imported := &Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	gotAccount, err := imported.FindAccountByID(account.ID)
+	if err != nil || !reflect.DeepEqual(account, gotAccount) {
+		t.Errorf("Import(): want account %v, result %v, %v", account, gotAccount, err)
+	}
+	gotPayment, err := imported.FindPaymentByID(payments[0].ID)
+	if err != nil || !reflect.DeepEqual(payments[0], gotPayment) {
+		t.Errorf("Import(): want payment %v, result %v, %v", payments[0], gotPayment, err)
+	}
+	gotFavorite, err := imported.FindFavoriteByID(favorite.ID)
+	if err != nil || !reflect.DeepEqual(favorite, gotFavorite) {
+		t.Errorf("Import(): want favorite %v, result %v, %v", favorite, gotFavorite, err)
+	}
+
+	path := filepath.Join(dir, "accounts.txt")
+	err = service.ExportToFile(path)
+	if err != nil {
+		t.Fatalf("ExportToFile(): can't export, %v", err)
+	}
+	fromFile := &Service{}
+	err = fromFile.ImportFromFile(path)
+	if err != nil {
+		t.Fatalf("ImportFromFile(): can't import, %v", err)
+	}
+	gotAccount, err = fromFile.FindAccountByID(account.ID)
+	if err != nil || !gotAccount.CreatedAt.Equal(account.CreatedAt) || !gotAccount.UpdatedAt.Equal(account.UpdatedAt) {
+		t.Errorf("ImportFromFile(): want account %v, result %v, %v", account, gotAccount, err)
+	}
+
+	history, err := service.ExportAccountHistory(account.ID)
+	if err != nil {
+		t.Fatalf("ExportAccountHistory(): can't export history, %v", err)
+	}
+	historyDir := t.TempDir()
+	err = service.HistoryToFiles(history, historyDir, len(history))
+	if err != nil {
+		t.Fatalf("HistoryToFiles(): can't write history, %v", err)
+	}
+	got, err := ReadHistory(historyDir)
+	if err != nil || !reflect.DeepEqual(got, history) {
+		t.Errorf("HistoryToFiles(): want %v, result %v, %v", history, got, err)
+	}
+}
+
+func TestService_Import_withoutTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		accountsDump:  "1;+992000000001;900\n",
+		paymentsDump:  "p1;100;auto;1;INPROGRESS\np2;50;transfer-out;1;OK;p3\n",
+		favoritesDump: "f1;100;auto;1;my;car\n",
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666)
+		if err != nil {
+			t.Fatalf("WriteFile(): can't write %s, %v", name, err)
+		}
+	}
+
+	service := &Service{}
+	err := service.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import dumps without timestamps, %v", err)
+	}
+	account, err := service.FindAccountByID(1)
+	if err != nil || account.Balance != 900 || !account.CreatedAt.IsZero() {
+		t.Errorf("Import(): wrong account %v, %v", account, err)
+	}
+	payment, err := service.FindPaymentByID("p2")
+	if err != nil || payment.LinkedID != "p3" || !payment.UpdatedAt.IsZero() {
+		t.Errorf("Import(): wrong payment %v, %v", payment, err)
+	}
+	favorite, err := service.FindFavoriteByID("f1")
+	if err != nil || favorite.Name != "my;car" || !favorite.CreatedAt.IsZero() {
+		t.Errorf("Import(): wrong favorite %v, %v", favorite, err)
+	}
+}
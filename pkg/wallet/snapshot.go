@@ -0,0 +1,666 @@
+package wallet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/akhrorov/wallet/pkg/types"
+	"github.com/google/uuid"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotMagic - первые байты файла снимка.
+const snapshotMagic = "WALLETSN"
+
+// snapshotVersion - версия двоичного формата снимка.
+const snapshotVersion = 1
+
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+var ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+
+// Снимок - двоичный файл с полным состоянием сервиса:
+//
+//	magic "WALLETSN", версия (uvarint)
+//	словарь: число строк, строки - категории, валюты, статусы, причины проводок и операции ключей
+//	счета, платежи, избранное, проводки, ключи идемпотентности, расписания: число записей, записи
+//	CRC-32 (IEEE) всех предыдущих байт, 4 байта big-endian
+//
+// Суммы, ID счетов и время (UnixNano, 0 - нулевое время) записываются как varint, строки - длиной (uvarint) и байтами,
+// строки из словаря - номером в словаре. ID в каноническом виде UUID записываются 16 байтами после 0,
+// остальные - как строка, длина которой увеличена на 1.
+
+// ExportSnapshot сохраняет состояние сервиса в двоичный снимок path.
+// Снимок записывается во временный файл и переименовывается, поэтому прежний снимок не повреждается при сбое.
+// Снимок с расширением .gz или .zst сжимается.
+func (s *Service) ExportSnapshot(path string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts, err := s.repository().Accounts()
+	if err != nil {
+		return err
+	}
+	payments, err := s.repository().Payments()
+	if err != nil {
+		return err
+	}
+	favorites, err := s.repository().Favorites()
+	if err != nil {
+		return err
+	}
+	postings, err := s.repository().Postings()
+	if err != nil {
+		return err
+	}
+	keys, err := s.repository().IdempotencyKeys()
+	if err != nil {
+		return err
+	}
+	keys = s.liveIdempotencyKeys(keys)
+	schedules, err := s.repository().Schedules()
+	if err != nil {
+		return err
+	}
+
+	encoder := newSnapshotEncoder()
+	for _, account := range accounts {
+		encoder.add(string(account.Currency), string(account.Status))
+	}
+	for _, payment := range payments {
+		encoder.add(string(payment.Category), string(payment.Status), string(payment.Currency))
+	}
+	for _, favorite := range favorites {
+		encoder.add(string(favorite.Category))
+	}
+	for _, posting := range postings {
+		encoder.add(string(posting.Reason), string(posting.Currency))
+	}
+	for _, key := range keys {
+		encoder.add(key.Operation)
+	}
+
+	tmp := path + ".tmp"
+	err = writeDump(tmp, func(w *bufio.Writer) error {
+		encoder.w = w
+		encoder.crc = crc32.NewIEEE()
+		encoder.header()
+		encoder.uvarint(uint64(len(accounts)))
+		for _, account := range accounts {
+			encoder.account(account)
+		}
+		encoder.uvarint(uint64(len(payments)))
+		for _, payment := range payments {
+			encoder.payment(payment)
+		}
+		encoder.uvarint(uint64(len(favorites)))
+		for _, favorite := range favorites {
+			encoder.favorite(favorite)
+		}
+		encoder.uvarint(uint64(len(postings)))
+		for _, posting := range postings {
+			encoder.posting(posting)
+		}
+		encoder.uvarint(uint64(len(keys)))
+		for _, key := range keys {
+			encoder.key(key)
+		}
+		encoder.uvarint(uint64(len(schedules)))
+		for _, schedule := range schedules {
+			encoder.schedule(schedule)
+		}
+		return encoder.close()
+	})
+	if err != nil {
+		if rerr := removeIfExists(tmp); rerr != nil {
+			log.Print(rerr)
+		}
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ImportSnapshot загружает состояние из двоичного снимка path, как Import: уже существующие записи пропускаются.
+// Снимок с неверной контрольной суммой не загружается. Сжатый снимок распаковывается (см. openDump).
+// Номера телефонов приводятся к каноническому виду, счёт с неверным номером - ошибка *ParseError.
+func (s *Service) ImportSnapshot(path string) error {
+	// снимок читается потоком, а записи сохраняются по мере чтения, поэтому он читается несколько раз:
+	// сначала проверяются контрольная сумма и номера телефонов, затем импортируются проводки и только потом
+	// остальные записи, чтобы проводки начального остатка учитывали проводки снимка (см. importOrder)
+	accounts := 0
+	err := readSnapshot(path, snapshotVisitor{account: func(account *types.Account) error {
+		accounts++
+		_, err := normalizeStoredPhone(account.Phone)
+		if err != nil {
+			return &ParseError{File: filepath.Base(path), Line: accounts, Field: "phone", Err: err}
+		}
+		return nil
+	}})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	im, err := s.newImporter()
+	if err != nil {
+		return err
+	}
+	err = readSnapshot(path, snapshotVisitor{posting: im.posting})
+	if err != nil {
+		return err
+	}
+	err = readSnapshot(path, snapshotVisitor{
+		account: func(account *types.Account) error {
+			// номера приводятся к виду, в котором их сохраняет RegisterAccount, иначе поиск по номеру их не найдёт
+			account.Phone, _ = normalizeStoredPhone(account.Phone)
+			return im.account(account)
+		},
+		payment:  im.payment,
+		favorite: im.favorite,
+		key:      im.key,
+		schedule: im.schedule,
+	})
+	if err != nil {
+		return err
+	}
+	return im.flush()
+}
+
+// snapshotEncoder записывает снимок. Запись собирается в buf и целиком пишется в w и в crc.
+type snapshotEncoder struct {
+	w          *bufio.Writer
+	crc        hash.Hash32
+	buf        []byte
+	scratch    [binary.MaxVarintLen64]byte
+	dictionary map[string]uint64
+	words      []string
+	err        error
+}
+
+func newSnapshotEncoder() *snapshotEncoder {
+	return &snapshotEncoder{dictionary: map[string]uint64{}}
+}
+
+// add добавляет строки в словарь.
+func (e *snapshotEncoder) add(words ...string) {
+	for _, word := range words {
+		if _, ok := e.dictionary[word]; !ok {
+			e.dictionary[word] = uint64(len(e.words))
+			e.words = append(e.words, word)
+		}
+	}
+}
+
+func (e *snapshotEncoder) header() {
+	e.buf = append(e.buf, snapshotMagic...)
+	e.uvarint(snapshotVersion)
+	e.uvarint(uint64(len(e.words)))
+	for _, word := range e.words {
+		e.string(word)
+	}
+	e.flush()
+}
+
+func (e *snapshotEncoder) account(account *types.Account) {
+	e.varint(account.ID)
+	e.string(string(account.Phone))
+	e.varint(int64(account.Balance))
+	e.word(string(account.Currency))
+	e.word(string(account.Status))
+	e.time(account.CreatedAt)
+	e.time(account.UpdatedAt)
+	e.flush()
+}
+
+func (e *snapshotEncoder) payment(payment *types.Payment) {
+	e.id(payment.ID)
+	e.varint(payment.AccountID)
+	e.varint(int64(payment.Amount))
+	e.word(string(payment.Category))
+	e.word(string(payment.Status))
+	e.word(string(payment.Currency))
+	e.id(payment.LinkedID)
+	e.time(payment.CreatedAt)
+	e.time(payment.UpdatedAt)
+	e.flush()
+}
+
+func (e *snapshotEncoder) favorite(favorite *types.Favorite) {
+	e.id(favorite.ID)
+	e.varint(favorite.AccountID)
+	e.varint(int64(favorite.Amount))
+	e.string(favorite.Name)
+	e.word(string(favorite.Category))
+	e.time(favorite.CreatedAt)
+	e.time(favorite.UpdatedAt)
+	e.flush()
+}
+
+func (e *snapshotEncoder) posting(posting *types.Posting) {
+	e.id(posting.ID)
+	e.word(string(posting.Reason))
+	e.id(posting.PaymentID)
+	e.string(posting.From)
+	e.string(posting.To)
+	e.varint(int64(posting.Amount))
+	e.word(string(posting.Currency))
+	e.time(posting.CreatedAt)
+	e.flush()
+}
+
+func (e *snapshotEncoder) key(key *types.IdempotencyKey) {
+	e.string(key.Key)
+	e.word(key.Operation)
+	e.string(key.Request)
+	e.id(key.PaymentID)
+	e.time(key.CreatedAt)
+	e.flush()
+}
+
+func (e *snapshotEncoder) schedule(schedule *types.Schedule) {
+	e.id(schedule.ID)
+	e.id(schedule.FavoriteID)
+	e.string(schedule.Spec)
+	e.time(schedule.NextRun)
+	e.varint(int64(schedule.Attempts))
+	e.string(schedule.LastError)
+	e.id(schedule.LastPaymentID)
+	e.time(schedule.CreatedAt)
+	e.time(schedule.UpdatedAt)
+	e.flush()
+}
+
+func (e *snapshotEncoder) uvarint(value uint64) {
+	n := binary.PutUvarint(e.scratch[:], value)
+	e.buf = append(e.buf, e.scratch[:n]...)
+}
+
+func (e *snapshotEncoder) varint(value int64) {
+	n := binary.PutVarint(e.scratch[:], value)
+	e.buf = append(e.buf, e.scratch[:n]...)
+}
+
+func (e *snapshotEncoder) string(value string) {
+	e.uvarint(uint64(len(value)))
+	e.buf = append(e.buf, value...)
+}
+
+func (e *snapshotEncoder) word(value string) {
+	e.uvarint(e.dictionary[value])
+}
+
+func (e *snapshotEncoder) id(value string) {
+	parsed, err := uuid.Parse(value)
+	if err == nil && parsed.String() == value {
+		e.uvarint(0)
+		e.buf = append(e.buf, parsed[:]...)
+		return
+	}
+	e.uvarint(uint64(len(value)) + 1)
+	e.buf = append(e.buf, value...)
+}
+
+func (e *snapshotEncoder) time(value time.Time) {
+	if value.IsZero() {
+		e.varint(0)
+		return
+	}
+	e.varint(value.UnixNano())
+}
+
+// flush пишет собранную запись в файл.
+func (e *snapshotEncoder) flush() {
+	if e.err == nil {
+		_, e.err = e.w.Write(e.buf)
+		e.crc.Write(e.buf)
+	}
+	e.buf = e.buf[:0]
+}
+
+// close дописывает несброшенные байты и контрольную сумму.
+func (e *snapshotEncoder) close() error {
+	e.flush()
+	if e.err != nil {
+		return e.err
+	}
+	var checksum [4]byte
+	binary.BigEndian.PutUint32(checksum[:], e.crc.Sum32())
+	_, err := e.w.Write(checksum[:])
+	return err
+}
+
+// snapshotVisitor получает записи снимка по мере разбора. Записи вида, для которого функция nil, только проверяются.
+type snapshotVisitor struct {
+	account  func(account *types.Account) error
+	payment  func(payment *types.Payment) error
+	favorite func(favorite *types.Favorite) error
+	posting  func(posting *types.Posting) error
+	key      func(key *types.IdempotencyKey) error
+	schedule func(schedule *types.Schedule) error
+}
+
+// readSnapshot разбирает снимок path (см. decodeSnapshot), в том числе сжатый.
+func readSnapshot(path string, visitor snapshotVisitor) error {
+	file, err := openDump(path)
+	if err != nil {
+		return err
+	}
+	err = decodeSnapshot(file, visitor)
+	if cerr := file.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// decodeSnapshot разбирает снимок из r, передавая записи visitor, и проверяет контрольную сумму.
+// Снимок читается потоком, поэтому контрольная сумма проверяется после всех записей: пока она не проверена,
+// записи нельзя сохранять. Если снимок не разбирается, но его контрольная сумма неверна, возвращается ErrSnapshotChecksum.
+func decodeSnapshot(r io.Reader, visitor snapshotVisitor) error {
+	d := &snapshotDecoder{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+	if string(d.bytes(uint64(len(snapshotMagic)))) != snapshotMagic {
+		return fmt.Errorf("%w: bad magic", ErrInvalidSnapshot)
+	}
+	version := d.uvarint()
+	if d.err == nil && version != snapshotVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedDumpVersion, version)
+	}
+	d.records(func() error {
+		d.words = append(d.words, d.string())
+		return nil
+	})
+
+	d.records(func() error {
+		account := d.account()
+		if d.err != nil || visitor.account == nil {
+			return nil
+		}
+		return visitor.account(account)
+	})
+	d.records(func() error {
+		payment := d.payment()
+		if d.err != nil || visitor.payment == nil {
+			return nil
+		}
+		return visitor.payment(payment)
+	})
+	d.records(func() error {
+		favorite := d.favorite()
+		if d.err != nil || visitor.favorite == nil {
+			return nil
+		}
+		return visitor.favorite(favorite)
+	})
+	d.records(func() error {
+		posting := d.posting()
+		if d.err != nil || visitor.posting == nil {
+			return nil
+		}
+		return visitor.posting(posting)
+	})
+	d.records(func() error {
+		key := d.key()
+		if d.err != nil || visitor.key == nil {
+			return nil
+		}
+		return visitor.key(key)
+	})
+	d.records(func() error {
+		schedule := d.schedule()
+		if d.err != nil || visitor.schedule == nil {
+			return nil
+		}
+		return visitor.schedule(schedule)
+	})
+	if d.visitErr != nil {
+		return d.visitErr
+	}
+	if d.err != nil {
+		if d.checksumMismatch() {
+			return ErrSnapshotChecksum
+		}
+		return d.err
+	}
+
+	sum := d.crc.Sum32()
+	var checksum [4]byte
+	if _, err := io.ReadFull(d.r, checksum[:]); err != nil {
+		return fmt.Errorf("%w: unexpected end", ErrInvalidSnapshot)
+	}
+	if binary.BigEndian.Uint32(checksum[:]) != sum {
+		return ErrSnapshotChecksum
+	}
+	if _, err := d.r.ReadByte(); err != io.EOF {
+		return fmt.Errorf("%w: trailing data", ErrInvalidSnapshot)
+	}
+	return nil
+}
+
+// snapshotDecoder разбирает снимок из r и считает контрольную сумму прочитанных байт. После первой ошибки остальные значения нулевые.
+type snapshotDecoder struct {
+	r     *bufio.Reader
+	crc   hash.Hash32
+	one   [1]byte
+	words []string
+	err   error
+	// visitErr - ошибка snapshotVisitor, она прерывает разбор
+	visitErr error
+}
+
+func (d *snapshotDecoder) fail(reason string) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrInvalidSnapshot, reason)
+	}
+}
+
+// records читает число записей и вызывает visit для каждой, пока нет ошибки.
+func (d *snapshotDecoder) records(visit func() error) {
+	count := d.uvarint()
+	for i := uint64(0); i < count && d.err == nil && d.visitErr == nil; i++ {
+		d.visitErr = visit()
+	}
+}
+
+// checksumMismatch дочитывает снимок после ошибки разбора и сообщает, что его контрольная сумма неверна.
+// Последние 4 байта файла - контрольная сумма, поэтому они не входят в сумму.
+func (d *snapshotDecoder) checksumMismatch() bool {
+	tail := []byte{}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := d.r.Read(buf)
+		tail = append(tail, buf[:n]...)
+		if len(tail) > 4 {
+			d.crc.Write(tail[:len(tail)-4])
+			tail = append(tail[:0], tail[len(tail)-4:]...)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return len(tail) < 4 || binary.BigEndian.Uint32(tail) != d.crc.Sum32()
+}
+
+func (d *snapshotDecoder) account() *types.Account {
+	return &types.Account{
+		ID:        d.varint(),
+		Phone:     types.Phone(d.string()),
+		Balance:   types.Money(d.varint()),
+		Currency:  types.Currency(d.word()),
+		Status:    types.AccountStatus(d.word()),
+		CreatedAt: d.time(),
+		UpdatedAt: d.time(),
+	}
+}
+
+func (d *snapshotDecoder) payment() *types.Payment {
+	return &types.Payment{
+		ID:        d.id(),
+		AccountID: d.varint(),
+		Amount:    types.Money(d.varint()),
+		Category:  types.PaymentCategory(d.word()),
+		Status:    types.PaymentStatus(d.word()),
+		Currency:  types.Currency(d.word()),
+		LinkedID:  d.id(),
+		CreatedAt: d.time(),
+		UpdatedAt: d.time(),
+	}
+}
+
+func (d *snapshotDecoder) favorite() *types.Favorite {
+	return &types.Favorite{
+		ID:        d.id(),
+		AccountID: d.varint(),
+		Amount:    types.Money(d.varint()),
+		Name:      d.string(),
+		Category:  types.PaymentCategory(d.word()),
+		CreatedAt: d.time(),
+		UpdatedAt: d.time(),
+	}
+}
+
+func (d *snapshotDecoder) posting() *types.Posting {
+	return &types.Posting{
+		ID:        d.id(),
+		Reason:    types.PostingReason(d.word()),
+		PaymentID: d.id(),
+		From:      d.string(),
+		To:        d.string(),
+		Amount:    types.Money(d.varint()),
+		Currency:  types.Currency(d.word()),
+		CreatedAt: d.time(),
+	}
+}
+
+func (d *snapshotDecoder) key() *types.IdempotencyKey {
+	return &types.IdempotencyKey{
+		Key:       d.string(),
+		Operation: d.word(),
+		Request:   d.string(),
+		PaymentID: d.id(),
+		CreatedAt: d.time(),
+	}
+}
+
+func (d *snapshotDecoder) schedule() *types.Schedule {
+	return &types.Schedule{
+		ID:            d.id(),
+		FavoriteID:    d.id(),
+		Spec:          d.string(),
+		NextRun:       d.time(),
+		Attempts:      int(d.varint()),
+		LastError:     d.string(),
+		LastPaymentID: d.id(),
+		CreatedAt:     d.time(),
+		UpdatedAt:     d.time(),
+	}
+}
+
+// ReadByte читает байт для binary.ReadUvarint и binary.ReadVarint.
+func (d *snapshotDecoder) ReadByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err == nil {
+		d.one[0] = b
+		d.crc.Write(d.one[:])
+	}
+	return b, err
+}
+
+func (d *snapshotDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	value, err := binary.ReadUvarint(d)
+	if err != nil {
+		d.fail("bad varint")
+		return 0
+	}
+	return value
+}
+
+func (d *snapshotDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	value, err := binary.ReadVarint(d)
+	if err != nil {
+		d.fail("bad varint")
+		return 0
+	}
+	return value
+}
+
+// bytes читает n байт. Длина из повреждённого снимка может быть любой, поэтому большие значения
+// читаются по мере поступления данных, а не выделяются заранее.
+func (d *snapshotDecoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	var value []byte
+	if n <= 4096 {
+		value = make([]byte, n)
+		_, err := io.ReadFull(d.r, value)
+		if err != nil {
+			d.fail("unexpected end")
+			return nil
+		}
+	} else {
+		var buf bytes.Buffer
+		copied, _ := io.CopyN(&buf, d.r, int64(n&math.MaxInt32))
+		if n > math.MaxInt32 || uint64(copied) != n {
+			d.fail("unexpected end")
+			return nil
+		}
+		value = buf.Bytes()
+	}
+	d.crc.Write(value)
+	return value
+}
+
+func (d *snapshotDecoder) string() string {
+	return string(d.bytes(d.uvarint()))
+}
+
+func (d *snapshotDecoder) word() string {
+	index := d.uvarint()
+	if d.err == nil && index >= uint64(len(d.words)) {
+		d.fail("bad dictionary index")
+		return ""
+	}
+	if d.err != nil {
+		return ""
+	}
+	return d.words[index]
+}
+
+func (d *snapshotDecoder) id() string {
+	size := d.uvarint()
+	if size == 0 {
+		var id uuid.UUID
+		copy(id[:], d.bytes(uint64(len(id))))
+		if d.err != nil {
+			return ""
+		}
+		return id.String()
+	}
+	return string(d.bytes(size - 1))
+}
+
+func (d *snapshotDecoder) time() time.Time {
+	nanos := d.varint()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos).UTC()
+}
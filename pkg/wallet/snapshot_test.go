@@ -0,0 +1,119 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestService_ExportSnapshot_roundTrip(t *testing.T) {
+	service, favorite, schedule := formatTestService(t)
+	accounts, _ := service.repository().Accounts()
+	payments, _ := service.repository().Payments()
+	postings, _ := service.repository().Postings()
+	path := filepath.Join(t.TempDir(), "wallet.snapshot")
+	err := service.ExportSnapshot(path)
+	if err != nil {
+		t.Fatalf("ExportSnapshot(): can't export, %v", err)
+	}
+
+	imported := &Service{}
+	err = imported.ImportSnapshot(path)
+	if err != nil {
+		t.Fatalf("ImportSnapshot(): can't import, %v", err)
+	}
+	for _, account := range accounts {
+		got, err := imported.FindAccountByID(account.ID)
+		if err != nil || !reflect.DeepEqual(got, account) {
+			t.Errorf("ImportSnapshot(): want account %v, result %v, %v", account, got, err)
+		}
+	}
+	for _, payment := range payments {
+		got, err := imported.FindPaymentByID(payment.ID)
+		if err != nil || !reflect.DeepEqual(got, payment) {
+			t.Errorf("ImportSnapshot(): want payment %v, result %v, %v", payment, got, err)
+		}
+	}
+	gotPostings, _ := imported.repository().Postings()
+	if !reflect.DeepEqual(gotPostings, postings) {
+		t.Errorf("ImportSnapshot(): want postings %v, result %v", postings, gotPostings)
+	}
+	gotFavorite, err := imported.FindFavoriteByID(favorite.ID)
+	if err != nil || !reflect.DeepEqual(gotFavorite, favorite) {
+		t.Errorf("ImportSnapshot(): want favorite %v, result %v, %v", favorite, gotFavorite, err)
+	}
+	gotSchedule, err := imported.repository().ScheduleByID(schedule.ID)
+	if err != nil || !reflect.DeepEqual(gotSchedule, schedule) {
+		t.Errorf("ImportSnapshot(): want schedule %v, result %v, %v", schedule, gotSchedule, err)
+	}
+}
+
+func TestService_ImportSnapshot_plainIDs(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"accounts.dump": formatDumpHeader(entityAccounts) + "1;+992000000001;100;0;0;TJS;ACTIVE\n",
+		"payments.dump": formatDumpHeader(entityPayments) + "p1;100;auto;1;OK;;0;0;TJS\n",
+	})
+	service := &Service{}
+	err := service.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): can't import, %v", err)
+	}
+	payment, _ := service.FindPaymentByID("p1")
+	path := filepath.Join(dir, "wallet.snapshot")
+	err = service.ExportSnapshot(path)
+	if err != nil {
+		t.Fatalf("ExportSnapshot(): can't export, %v", err)
+	}
+
+	imported := &Service{}
+	err = imported.ImportSnapshot(path)
+	if err != nil {
+		t.Fatalf("ImportSnapshot(): can't import, %v", err)
+	}
+	got, err := imported.FindPaymentByID("p1")
+	if err != nil || !reflect.DeepEqual(got, payment) {
+		t.Errorf("ImportSnapshot(): want payment %v, result %v, %v", payment, got, err)
+	}
+}
+
+func TestService_ImportSnapshot_corrupted(t *testing.T) {
+	service, _, _ := formatTestService(t)
+	path := filepath.Join(t.TempDir(), "wallet.snapshot")
+	err := service.ExportSnapshot(path)
+	if err != nil {
+		t.Fatalf("ExportSnapshot(): can't export, %v", err)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(): can't read snapshot, %v", err)
+	}
+
+	corrupted := append([]byte{}, content...)
+	corrupted[len(snapshotMagic)+5] ^= 0xff
+	err = ioutil.WriteFile(path, corrupted, 0666)
+	if err != nil {
+		t.Fatalf("WriteFile(): can't write snapshot, %v", err)
+	}
+	err = (&Service{}).ImportSnapshot(path)
+	if !errors.Is(err, ErrSnapshotChecksum) {
+		t.Errorf("ImportSnapshot(): must return ErrSnapshotChecksum, returned %v", err)
+	}
+
+	err = ioutil.WriteFile(path, []byte(formatDumpHeader(entityAccounts)), 0666)
+	if err != nil {
+		t.Fatalf("WriteFile(): can't write snapshot, %v", err)
+	}
+	err = (&Service{}).ImportSnapshot(path)
+	if !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("ImportSnapshot(): must return ErrInvalidSnapshot, returned %v", err)
+	}
+
+	err = decodeSnapshot(bytes.NewReader(content[:len(content)-1]), snapshotVisitor{})
+	if err == nil {
+		t.Error("decodeSnapshot(): truncated snapshot must return error")
+	}
+}
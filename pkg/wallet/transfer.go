@@ -0,0 +1,180 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/akhrorov/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrTransferToSameAccount = errors.New("can't transfer to the same account")
+
+// Transfer переводит amount со счёта fromID на счёт toID.
+// Создаются два связанных платежа: списание (transfer-out) у отправителя и зачисление (transfer-in) у получателя.
+// amount указывается в валюте отправителя. Если валюта получателя другая, сумма зачисления
+// пересчитывается конвертером, заданным через SetConverter.
+// Возвращает платёж отправителя.
+func (s *Service) Transfer(fromID int64, toID int64, amount types.Money) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.transfer(fromID, toID, amount, nil)
+}
+
+func (s *Service) transfer(fromID int64, toID int64, amount types.Money, key *types.IdempotencyKey) (*types.Payment, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+	if fromID == toID {
+		return nil, ErrTransferToSameAccount
+	}
+
+	from, err := s.findAccountByID(fromID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.findAccountByID(toID)
+	if err != nil {
+		return nil, err
+	}
+	err = checkActive(from)
+	if err != nil {
+		return nil, err
+	}
+	err = checkActive(to)
+	if err != nil {
+		return nil, err
+	}
+
+	if from.Balance < amount {
+		return nil, ErrNotEnoughBalance
+	}
+	err = s.checkRules(from, amount, types.PaymentCategoryTransferOut)
+	if err != nil {
+		return nil, err
+	}
+
+	debit := types.Amount{Value: amount, Currency: from.Currency}
+	credit, err := s.converter().Convert(debit, to.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if credit.Value <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+
+	now := s.currentTime()
+	updatedFrom := *from
+	updatedFrom.Balance -= debit.Value
+	updatedFrom.UpdatedAt = now
+	updatedTo := *to
+	updatedTo.Balance += credit.Value
+	updatedTo.UpdatedAt = now
+
+	outgoing := &types.Payment{
+		ID:        uuid.New().String(),
+		AccountID: fromID,
+		Amount:    debit.Value,
+		Category:  types.PaymentCategoryTransferOut,
+		Status:    types.PaymentStatusInProgress,
+		Currency:  debit.Currency,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	incoming := &types.Payment{
+		ID:        uuid.New().String(),
+		AccountID: toID,
+		Amount:    credit.Value,
+		Category:  types.PaymentCategoryTransferIn,
+		Status:    types.PaymentStatusInProgress,
+		Currency:  credit.Currency,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	outgoing.LinkedID = incoming.ID
+	incoming.LinkedID = outgoing.ID
+
+	err = s.apply(journalEntry{
+		op:       opTransfer,
+		accounts: []*types.Account{&updatedFrom, &updatedTo},
+		payments: []*types.Payment{outgoing, incoming},
+		postings: s.exchangePostings(types.PostingReasonTransfer, outgoing.ID, ledgerAccount(fromID), debit, ledgerAccount(toID), credit),
+		keys:     idempotencyKeys(key, outgoing.ID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return outgoing, nil
+}
+
+// transferLegs возвращает платежи отправителя и получателя для любой из сторон перевода.
+func (s *Service) transferLegs(payment *types.Payment) (*types.Payment, *types.Payment, error) {
+	linked, err := s.findPaymentByID(payment.LinkedID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if payment.Category == types.PaymentCategoryTransferIn {
+		return linked, payment, nil
+	}
+	return payment, linked, nil
+}
+
+// cancelTransfer отменяет обе стороны перевода: получатель возвращает сумму отправителю.
+// Суммы возвращаются в том виде, в каком были проведены, без пересчёта по текущему курсу.
+func (s *Service) cancelTransfer(payment *types.Payment, status types.PaymentStatus, op string) error {
+	outgoing, incoming, err := s.transferLegs(payment)
+	if err != nil {
+		return err
+	}
+	for _, leg := range []*types.Payment{outgoing, incoming} {
+		err = leg.Status.Transition(status)
+		if err != nil {
+			return err
+		}
+	}
+	from, err := s.findAccountByID(outgoing.AccountID)
+	if err != nil {
+		return ErrAccountNotFound
+	}
+	to, err := s.findAccountByID(incoming.AccountID)
+	if err != nil {
+		return ErrAccountNotFound
+	}
+
+	if to.Balance < incoming.Amount {
+		return ErrNotEnoughBalance
+	}
+
+	now := s.currentTime()
+	updatedFrom := *from
+	updatedFrom.Balance += outgoing.Amount
+	updatedFrom.UpdatedAt = now
+	updatedTo := *to
+	updatedTo.Balance -= incoming.Amount
+	updatedTo.UpdatedAt = now
+	updatedOutgoing := *outgoing
+	updatedOutgoing.Status = status
+	updatedOutgoing.UpdatedAt = now
+	updatedIncoming := *incoming
+	updatedIncoming.Status = status
+	updatedIncoming.UpdatedAt = now
+
+	return s.apply(journalEntry{
+		op:       op,
+		accounts: []*types.Account{&updatedFrom, &updatedTo},
+		payments: []*types.Payment{&updatedOutgoing, &updatedIncoming},
+		postings: s.exchangePostings(types.PostingReasonRefund, outgoing.ID,
+			ledgerAccount(to.ID), types.Amount{Value: incoming.Amount, Currency: incoming.Currency},
+			ledgerAccount(from.ID), types.Amount{Value: outgoing.Amount, Currency: outgoing.Currency}),
+	})
+}
+
+// repeatTransfer повторяет перевод с теми же отправителем, получателем и суммой списания.
+// Сумма зачисления пересчитывается по текущему курсу.
+func (s *Service) repeatTransfer(payment *types.Payment, key *types.IdempotencyKey) (*types.Payment, error) {
+	outgoing, incoming, err := s.transferLegs(payment)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.transfer(outgoing.AccountID, incoming.AccountID, outgoing.Amount, key)
+}
@@ -0,0 +1,145 @@
+package wallet
+
+import (
+	"github.com/akhrorov/wallet/pkg/types"
+	"testing"
+)
+
+func TestService_Transfer_success(t *testing.T) {
+	service := &Service{}
+	from, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	to, _, err := service.addAccount(defaultExampleTestAccount2)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	fromBalance, toBalance := from.Balance, to.Balance
+
+	outgoing, err := service.Transfer(from.ID, to.ID, 500_00)
+	if err != nil {
+		t.Fatalf("Transfer(): can't transfer, %v", err)
+	}
+	from, to = currentAccount(t, service, from.ID), currentAccount(t, service, to.ID)
+	if from.Balance != fromBalance-500_00 || to.Balance != toBalance+500_00 {
+		t.Errorf("Transfer(): wrong balances, from = %v, to = %v", from, to)
+	}
+
+	incoming, err := service.FindPaymentByID(outgoing.LinkedID)
+	if err != nil {
+		t.Fatalf("Transfer(): can't find linked payment, %v", err)
+	}
+	if incoming.LinkedID != outgoing.ID || incoming.AccountID != to.ID || incoming.Category != types.PaymentCategoryTransferIn {
+		t.Errorf("Transfer(): wrong linked payment, %v", incoming)
+	}
+
+	err = service.Audit()
+	if err != nil {
+		t.Errorf("Audit(): ledger must match balances, %v", err)
+	}
+}
+
+func TestService_Transfer_fail(t *testing.T) {
+	service := &Service{}
+	from, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	to, _, err := service.addAccount(defaultExampleTestAccount2)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+
+	_, err = service.Transfer(from.ID, to.ID, from.Balance+1)
+	if err != ErrNotEnoughBalance {
+		t.Errorf("Transfer(): must return ErrNotEnoughBalance, returned %v", err)
+	}
+	_, err = service.Transfer(from.ID, 100, 1)
+	if err != ErrAccountNotFound {
+		t.Errorf("Transfer(): must return ErrAccountNotFound, returned %v", err)
+	}
+	_, err = service.Transfer(from.ID, from.ID, 1)
+	if err != ErrTransferToSameAccount {
+		t.Errorf("Transfer(): must return ErrTransferToSameAccount, returned %v", err)
+	}
+}
+
+func TestService_Reject_transfer(t *testing.T) {
+	service := &Service{}
+	from, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	to, _, err := service.addAccount(defaultExampleTestAccount2)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	fromBalance, toBalance := from.Balance, to.Balance
+
+	outgoing, err := service.Transfer(from.ID, to.ID, 500_00)
+	if err != nil {
+		t.Fatalf("Transfer(): can't transfer, %v", err)
+	}
+
+	// отменять можно по любой из сторон перевода
+	err = service.Reject(outgoing.LinkedID)
+	if err != nil {
+		t.Fatalf("Reject(): can't reject transfer, %v", err)
+	}
+	from, to = currentAccount(t, service, from.ID), currentAccount(t, service, to.ID)
+	outgoing = currentPayment(t, service, outgoing.ID)
+	if from.Balance != fromBalance || to.Balance != toBalance {
+		t.Errorf("Reject(): balances didn't restored, from = %v, to = %v", from, to)
+	}
+
+	incoming, err := service.FindPaymentByID(outgoing.LinkedID)
+	if err != nil {
+		t.Fatalf("FindPaymentByID(): can't find linked payment, %v", err)
+	}
+	if outgoing.Status != types.PaymentStatusFail || incoming.Status != types.PaymentStatusFail {
+		t.Errorf("Reject(): both legs must fail, outgoing = %v, incoming = %v", outgoing, incoming)
+	}
+
+	err = service.Audit()
+	if err != nil {
+		t.Errorf("Audit(): ledger must match balances, %v", err)
+	}
+}
+
+func TestService_SumPayments_transfer(t *testing.T) {
+	service := &Service{}
+	from, _, err := service.addAccount(defaultExampleTestAccount)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	to, _, err := service.addAccount(defaultExampleTestAccount2)
+	if err != nil {
+		t.Fatalf("addAccount(): can't add account, %v", err)
+	}
+	before := service.SumPayments(1)
+
+	_, err = service.Transfer(from.ID, to.ID, 500_00)
+	if err != nil {
+		t.Fatalf("Transfer(): can't transfer, %v", err)
+	}
+
+	// перевод - два платежа, но в сумму входит один раз
+	want := before + 500_00
+	for _, goroutines := range []int{1, 3} {
+		if sum := service.SumPayments(goroutines); sum != want {
+			t.Errorf("SumPayments(%d): want %v, result %v", goroutines, want, sum)
+		}
+	}
+	sum, err := service.SumPaymentsIn(types.CurrencyTJS)
+	if err != nil || sum.Value != want {
+		t.Errorf("SumPaymentsIn(): want %v, result %v, %v", want, sum, err)
+	}
+	progress := types.Money(0)
+	for value := range service.SumPaymentsWithProgress() {
+		progress += value.Result
+	}
+	if progress != want {
+		t.Errorf("SumPaymentsWithProgress(): want %v, result %v", want, progress)
+	}
+}
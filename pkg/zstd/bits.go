@@ -0,0 +1,107 @@
+package zstd
+
+import (
+	"math/bits"
+)
+
+// backwardReader читает поток битов с конца. Последний байт потока содержит метку конца - старший единичный бит,
+// биты перед ней читаются от старших к младшим, то есть в порядке, обратном записи bitWriter.
+type backwardReader struct {
+	data []byte
+	// pos - число непрочитанных битов. Отрицательное значение значит, что прочитано больше, чем есть в потоке.
+	pos int
+}
+
+func newBackwardReader(data []byte) (*backwardReader, error) {
+	if len(data) == 0 || data[len(data)-1] == 0 {
+		return nil, ErrCorrupted
+	}
+	return &backwardReader{data: data, pos: (len(data)-1)*8 + bits.Len8(data[len(data)-1]) - 1}, nil
+}
+
+// peek возвращает следующие n ≤ 56 битов, не читая их. Недостающие биты в начале потока считаются нулями.
+func (r *backwardReader) peek(n int) uint64 {
+	if n == 0 || r.pos <= 0 {
+		return 0
+	}
+	start := r.pos - n
+	if start < 0 {
+		return r.peek(r.pos) << uint(-start)
+	}
+	var value uint64
+	for i := (r.pos - 1) >> 3; i >= start>>3; i-- {
+		value = value<<8 | uint64(r.data[i])
+	}
+	return value >> uint(start&7) & (1<<uint(n) - 1)
+}
+
+// read читает n ≤ 56 битов.
+func (r *backwardReader) read(n int) uint64 {
+	value := r.peek(n)
+	r.pos -= n
+	return value
+}
+
+// forwardReader читает поток битов с начала, от младших битов каждого байта к старшим.
+type forwardReader struct {
+	data []byte
+	pos  int
+}
+
+// peek возвращает следующие n ≤ 56 битов, не читая их. Биты после конца данных считаются нулями.
+func (r *forwardReader) peek(n int) uint64 {
+	var value uint64
+	for i := 0; i < n; {
+		index := (r.pos + i) >> 3
+		if index >= len(r.data) {
+			break
+		}
+		shift := (r.pos + i) & 7
+		take := 8 - shift
+		if take > n-i {
+			take = n - i
+		}
+		value |= uint64(r.data[index]>>uint(shift)&(1<<uint(take)-1)) << uint(i)
+		i += take
+	}
+	return value
+}
+
+func (r *forwardReader) read(n int) uint64 {
+	value := r.peek(n)
+	r.pos += n
+	return value
+}
+
+// overrun сообщает, что прочитано больше битов, чем есть в данных.
+func (r *forwardReader) overrun() bool {
+	return r.pos > len(r.data)*8
+}
+
+// bitWriter записывает поток битов, который читает backwardReader.
+type bitWriter struct {
+	buf  []byte
+	acc  uint64
+	bits uint
+}
+
+// add записывает n ≤ 56 младших битов value.
+func (w *bitWriter) add(value uint64, n uint) {
+	w.acc |= (value & (1<<n - 1)) << w.bits
+	w.bits += n
+	for w.bits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.bits -= 8
+	}
+}
+
+// close записывает метку конца и дополняет поток до целого байта.
+func (w *bitWriter) close() []byte {
+	w.add(1, 1)
+	if w.bits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.bits = 0, 0
+	}
+	return w.buf
+}
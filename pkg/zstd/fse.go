@@ -0,0 +1,271 @@
+package zstd
+
+import (
+	"math/bits"
+)
+
+// fseEntry - состояние таблицы FSE: символ и как перейти к следующему состоянию.
+type fseEntry struct {
+	symbol uint8
+	bits   uint8
+	base   uint16
+}
+
+// fseTable - таблица распаковки FSE.
+type fseTable struct {
+	log     int
+	entries []fseEntry
+}
+
+// next возвращает состояние, следующее за state, читая его биты из r.
+func (t *fseTable) next(state uint64, r *backwardReader) uint64 {
+	entry := t.entries[state]
+	return uint64(entry.base) + r.read(int(entry.bits))
+}
+
+// spreadSymbols раскладывает символы распределения norm по 1<<log состояниям так же, как эталонный кодек.
+// Символы с вероятностью "меньше 1" (-1) занимают последние состояния.
+func spreadSymbols(norm []int16, log int) ([]uint8, error) {
+	size := 1 << log
+	total := 0
+	for _, count := range norm {
+		if count < -1 {
+			return nil, ErrCorrupted
+		}
+		if count == -1 {
+			total++
+		} else {
+			total += int(count)
+		}
+	}
+	if total != size || len(norm) > 256 {
+		return nil, ErrCorrupted
+	}
+
+	symbols := make([]uint8, size)
+	high := size - 1
+	for symbol, count := range norm {
+		if count == -1 {
+			symbols[high] = uint8(symbol)
+			high--
+		}
+	}
+	position := 0
+	step := size>>1 + size>>3 + 3
+	mask := size - 1
+	for symbol, count := range norm {
+		for i := 0; i < int(count); i++ {
+			symbols[position] = uint8(symbol)
+			position = (position + step) & mask
+			for position > high {
+				position = (position + step) & mask
+			}
+		}
+	}
+	if position != 0 {
+		return nil, ErrCorrupted
+	}
+	return symbols, nil
+}
+
+// newFSETable строит таблицу распаковки для распределения norm с точностью log.
+func newFSETable(norm []int16, log int) (*fseTable, error) {
+	symbols, err := spreadSymbols(norm, log)
+	if err != nil {
+		return nil, err
+	}
+	size := 1 << log
+	next := make([]uint16, len(norm))
+	for symbol, count := range norm {
+		if count == -1 {
+			next[symbol] = 1
+		} else {
+			next[symbol] = uint16(count)
+		}
+	}
+
+	table := &fseTable{log: log, entries: make([]fseEntry, size)}
+	for state, symbol := range symbols {
+		value := next[symbol]
+		next[symbol]++
+		nbBits := log + 1 - bits.Len16(value)
+		table.entries[state] = fseEntry{
+			symbol: symbol,
+			bits:   uint8(nbBits),
+			base:   uint16(int(value)<<uint(nbBits) - size),
+		}
+	}
+	return table, nil
+}
+
+// mustFSETable строит таблицу предопределённого распределения.
+func mustFSETable(norm []int16, log int) *fseTable {
+	table, err := newFSETable(norm, log)
+	if err != nil {
+		panic(err)
+	}
+	return table
+}
+
+// rleFSETable - таблица из одного состояния, которое всегда даёт symbol.
+func rleFSETable(symbol uint8) *fseTable {
+	return &fseTable{entries: []fseEntry{{symbol: symbol}}}
+}
+
+// readDistribution читает описание распределения FSE в начале data и возвращает его, точность
+// и число прочитанных байтов. Распределение может содержать не больше maxSymbol+1 символов.
+func readDistribution(data []byte, maxSymbol int, maxLog int) ([]int16, int, int, error) {
+	r := forwardReader{data: data}
+	log := int(r.read(4)) + 5
+	if log > maxLog {
+		return nil, 0, 0, ErrCorrupted
+	}
+
+	norm := []int16{}
+	remaining := 1<<log + 1
+	threshold := 1 << log
+	nbBits := log + 1
+	for remaining > 1 && len(norm) <= maxSymbol {
+		max := 2*threshold - 1 - remaining
+		var count int
+		if low := int(r.peek(nbBits - 1)); low < max {
+			count = low
+			r.pos += nbBits - 1
+		} else {
+			count = int(r.read(nbBits))
+			if count >= threshold {
+				count -= max
+			}
+		}
+		// значения хранятся на единицу больше, чтобы записать вероятность "меньше 1" как 0
+		count--
+		if count < 0 {
+			remaining--
+		} else {
+			remaining -= count
+		}
+		norm = append(norm, int16(count))
+		if count == 0 {
+			for {
+				repeat := int(r.read(2))
+				for i := 0; i < repeat; i++ {
+					norm = append(norm, 0)
+				}
+				if repeat != 3 {
+					break
+				}
+			}
+		}
+		if remaining < 1 {
+			return nil, 0, 0, ErrCorrupted
+		}
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 || len(norm) > maxSymbol+1 || r.overrun() {
+		return nil, 0, 0, ErrCorrupted
+	}
+	return norm, log, (r.pos + 7) / 8, nil
+}
+
+// readFSETable читает описание распределения в начале data и строит по нему таблицу.
+func readFSETable(data []byte, maxSymbol int, maxLog int) (*fseTable, int, error) {
+	norm, log, n, err := readDistribution(data, maxSymbol, maxLog)
+	if err != nil {
+		return nil, 0, err
+	}
+	table, err := newFSETable(norm, log)
+	if err != nil {
+		return nil, 0, err
+	}
+	return table, n, nil
+}
+
+// fseTransform описывает, как закодировать символ из любого состояния.
+type fseTransform struct {
+	deltaBits  uint32
+	deltaState int32
+}
+
+// fseEncoder - таблица сжатия FSE, обратная fseTable того же распределения.
+type fseEncoder struct {
+	log        int
+	states     []uint16
+	transforms []fseTransform
+}
+
+func newFSEEncoder(norm []int16, log int) (*fseEncoder, error) {
+	symbols, err := spreadSymbols(norm, log)
+	if err != nil {
+		return nil, err
+	}
+	size := 1 << log
+
+	cumul := make([]int, len(norm)+1)
+	for symbol, count := range norm {
+		if count == -1 {
+			count = 1
+		}
+		cumul[symbol+1] = cumul[symbol] + int(count)
+	}
+	encoder := &fseEncoder{log: log, states: make([]uint16, size), transforms: make([]fseTransform, len(norm))}
+	for state, symbol := range symbols {
+		encoder.states[cumul[symbol]] = uint16(size + state)
+		cumul[symbol]++
+	}
+
+	total := 0
+	for symbol, count := range norm {
+		switch {
+		case count == 0:
+			encoder.transforms[symbol] = fseTransform{deltaBits: uint32((log+1)<<16 - size)}
+		case count == -1 || count == 1:
+			encoder.transforms[symbol] = fseTransform{deltaBits: uint32(log<<16 - size), deltaState: int32(total - 1)}
+			total++
+		default:
+			maxBits := log - (bits.Len16(uint16(count-1)) - 1)
+			minState := int(count) << uint(maxBits)
+			encoder.transforms[symbol] = fseTransform{deltaBits: uint32(maxBits<<16 - minState), deltaState: int32(total - int(count))}
+			total += int(count)
+		}
+	}
+	return encoder, nil
+}
+
+func mustFSEEncoder(norm []int16, log int) *fseEncoder {
+	encoder, err := newFSEEncoder(norm, log)
+	if err != nil {
+		panic(err)
+	}
+	return encoder
+}
+
+// fseState - состояние сжатия FSE. Символы сжимаются в обратном порядке: распаковщик прочитает их с конца.
+type fseState struct {
+	encoder *fseEncoder
+	value   uint32
+}
+
+// init начинает сжатие с символа symbol, не записывая битов.
+func (s *fseState) init(encoder *fseEncoder, symbol uint8) {
+	s.encoder = encoder
+	transform := encoder.transforms[symbol]
+	nbBits := (transform.deltaBits + 1<<15) >> 16
+	value := nbBits<<16 - transform.deltaBits
+	s.value = uint32(encoder.states[int32(value>>nbBits)+transform.deltaState])
+}
+
+// encode записывает в w биты перехода к состоянию, которое даёт symbol.
+func (s *fseState) encode(w *bitWriter, symbol uint8) {
+	transform := s.encoder.transforms[symbol]
+	nbBits := (s.value + transform.deltaBits) >> 16
+	w.add(uint64(s.value), uint(nbBits))
+	s.value = uint32(s.encoder.states[int32(s.value>>nbBits)+transform.deltaState])
+}
+
+// flush записывает начальное состояние распаковщика.
+func (s *fseState) flush(w *bitWriter) {
+	w.add(uint64(s.value), uint(s.encoder.log))
+}
@@ -0,0 +1,287 @@
+package zstd
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// maxHuffmanBits - наибольшая длина кода Хаффмана литералов.
+const maxHuffmanBits = 11
+
+type huffmanEntry struct {
+	symbol uint8
+	bits   uint8
+}
+
+// huffmanTable - таблица распаковки литералов: индекс - следующие maxBits битов потока.
+type huffmanTable struct {
+	maxBits int
+	entries []huffmanEntry
+}
+
+// readHuffmanTable читает описание дерева Хаффмана в начале data и возвращает таблицу и число прочитанных байтов.
+func readHuffmanTable(data []byte) (*huffmanTable, int, error) {
+	if len(data) == 0 {
+		return nil, 0, ErrCorrupted
+	}
+	header := int(data[0])
+	if header < 128 {
+		if 1+header > len(data) {
+			return nil, 0, ErrCorrupted
+		}
+		weights, err := readHuffmanWeights(data[1 : 1+header])
+		if err != nil {
+			return nil, 0, err
+		}
+		table, err := newHuffmanTable(weights)
+		return table, 1 + header, err
+	}
+
+	// веса по 4 бита, первый - в старших битах байта
+	count := header - 127
+	size := (count + 1) / 2
+	if 1+size > len(data) {
+		return nil, 0, ErrCorrupted
+	}
+	weights := make([]uint8, count)
+	for i := range weights {
+		b := data[1+i/2]
+		if i%2 == 0 {
+			weights[i] = b >> 4
+		} else {
+			weights[i] = b & 0xf
+		}
+	}
+	table, err := newHuffmanTable(weights)
+	return table, 1 + size, err
+}
+
+// readHuffmanWeights распаковывает веса, сжатые FSE двумя чередующимися состояниями.
+func readHuffmanWeights(data []byte) ([]uint8, error) {
+	table, n, err := readFSETable(data, 255, 6)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newBackwardReader(data[n:])
+	if err != nil {
+		return nil, err
+	}
+	states := [2]uint64{r.read(table.log), r.read(table.log)}
+	weights := []uint8{}
+	for i := 0; ; i ^= 1 {
+		if len(weights) > 253 {
+			return nil, ErrCorrupted
+		}
+		weights = append(weights, table.entries[states[i]].symbol)
+		states[i] = table.next(states[i], r)
+		// поток закончился: последний вес - в другом состоянии
+		if r.pos < 0 {
+			weights = append(weights, table.entries[states[i^1]].symbol)
+			return weights, nil
+		}
+	}
+}
+
+// newHuffmanTable строит таблицу по весам символов. Вес последнего символа не хранится:
+// он дополняет сумму весов до степени двойки.
+func newHuffmanTable(weights []uint8) (*huffmanTable, error) {
+	total := 0
+	for _, weight := range weights {
+		if weight > maxHuffmanBits+1 {
+			return nil, ErrCorrupted
+		}
+		if weight > 0 {
+			total += 1 << (weight - 1)
+		}
+	}
+	if total == 0 {
+		return nil, ErrCorrupted
+	}
+	maxBits := bits.Len(uint(total))
+	rest := 1<<uint(maxBits) - total
+	if maxBits > maxHuffmanBits || rest&(rest-1) != 0 {
+		return nil, ErrCorrupted
+	}
+	weights = append(weights, uint8(bits.Len(uint(rest))))
+
+	// символы с меньшим весом (длинным кодом) занимают начало таблицы, внутри веса - по порядку символов
+	starts := make([]int, maxBits+2)
+	for _, weight := range weights {
+		if weight > 0 {
+			starts[weight] += 1 << (weight - 1)
+		}
+	}
+	position := 0
+	for weight := 1; weight <= maxBits+1; weight++ {
+		count := starts[weight]
+		starts[weight] = position
+		position += count
+	}
+
+	table := &huffmanTable{maxBits: maxBits, entries: make([]huffmanEntry, 1<<uint(maxBits))}
+	for symbol, weight := range weights {
+		if weight == 0 {
+			continue
+		}
+		length := 1 << (weight - 1)
+		entry := huffmanEntry{symbol: uint8(symbol), bits: uint8(maxBits + 1 - int(weight))}
+		for i := starts[weight]; i < starts[weight]+length; i++ {
+			table.entries[i] = entry
+		}
+		starts[weight] += length
+	}
+	return table, nil
+}
+
+// decode распаковывает из потока data count литералов и дописывает их в dst.
+func (t *huffmanTable) decode(dst []byte, data []byte, count int) ([]byte, error) {
+	r, err := newBackwardReader(data)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < count; i++ {
+		entry := t.entries[r.peek(t.maxBits)]
+		dst = append(dst, entry.symbol)
+		r.pos -= int(entry.bits)
+	}
+	if r.pos != 0 {
+		return nil, ErrCorrupted
+	}
+	return dst, nil
+}
+
+// huffmanCode - коды литералов для сжатия.
+type huffmanCode struct {
+	// weights - веса символов до последнего встречающегося символа включительно
+	weights []uint8
+	codes   [256]uint16
+	lengths [256]uint8
+}
+
+// newHuffmanCode строит код для литералов с частотами freq. Если литералов меньше двух разных
+// или код нельзя описать весами по 4 бита (символы больше 128), возвращает nil.
+func newHuffmanCode(freq *[256]int) *huffmanCode {
+	last := -1
+	distinct := 0
+	for symbol, count := range freq {
+		if count > 0 {
+			last = symbol
+			distinct++
+		}
+	}
+	if distinct < 2 || last > 128 {
+		return nil
+	}
+
+	counts := *freq
+	lengths := huffmanLengths(&counts)
+	for maxLength(&lengths) > maxHuffmanBits {
+		// сглаживаем частоты, пока коды не станут достаточно короткими
+		for symbol, count := range counts {
+			if count > 0 {
+				counts[symbol] = count/2 + 1
+			}
+		}
+		lengths = huffmanLengths(&counts)
+	}
+
+	maxBits := maxLength(&lengths)
+	code := &huffmanCode{weights: make([]uint8, last+1), lengths: lengths}
+	for symbol := 0; symbol <= last; symbol++ {
+		if lengths[symbol] > 0 {
+			code.weights[symbol] = uint8(maxBits + 1 - int(lengths[symbol]))
+		}
+	}
+
+	// коды назначаются так же, как их раскладывает newHuffmanTable
+	position := 0
+	for weight := 1; weight <= maxBits; weight++ {
+		for symbol := 0; symbol <= last; symbol++ {
+			if int(code.weights[symbol]) == weight {
+				code.codes[symbol] = uint16(position >> uint(weight-1))
+				position += 1 << uint(weight-1)
+			}
+		}
+	}
+	return code
+}
+
+func maxLength(lengths *[256]uint8) int {
+	max := 0
+	for _, length := range lengths {
+		if int(length) > max {
+			max = int(length)
+		}
+	}
+	return max
+}
+
+// huffmanLengths возвращает длины кодов Хаффмана для символов с частотами counts.
+func huffmanLengths(counts *[256]int) [256]uint8 {
+	type node struct {
+		count  int
+		parent int
+	}
+	nodes := []node{}
+	symbols := []int{}
+	for symbol, count := range counts {
+		if count > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.SliceStable(symbols, func(i, j int) bool { return counts[symbols[i]] < counts[symbols[j]] })
+	for _, symbol := range symbols {
+		nodes = append(nodes, node{count: counts[symbol], parent: -1})
+	}
+
+	// две очереди: листья по возрастанию частоты и внутренние узлы в порядке создания
+	leaf, inner := 0, len(nodes)
+	smallest := func() int {
+		if leaf < len(symbols) && (inner >= len(nodes) || nodes[leaf].count <= nodes[inner].count) {
+			leaf++
+			return leaf - 1
+		}
+		inner++
+		return inner - 1
+	}
+	for len(nodes)-inner+len(symbols)-leaf > 1 {
+		a, b := smallest(), smallest()
+		nodes = append(nodes, node{count: nodes[a].count + nodes[b].count, parent: -1})
+		nodes[a].parent = len(nodes) - 1
+		nodes[b].parent = len(nodes) - 1
+	}
+
+	depths := make([]uint8, len(nodes))
+	for i := len(nodes) - 2; i >= 0; i-- {
+		depths[i] = depths[nodes[i].parent] + 1
+	}
+	var lengths [256]uint8
+	for i, symbol := range symbols {
+		lengths[symbol] = depths[i]
+	}
+	return lengths
+}
+
+// encode сжимает literals одним потоком. Литералы записываются с конца, чтобы распаковщик прочитал их по порядку.
+func (c *huffmanCode) encode(dst []byte, literals []byte) []byte {
+	w := bitWriter{buf: dst}
+	for i := len(literals) - 1; i >= 0; i-- {
+		symbol := literals[i]
+		w.add(uint64(c.codes[symbol]), uint(c.lengths[symbol]))
+	}
+	return w.close()
+}
+
+// description записывает описание дерева: веса всех символов, кроме последнего, по 4 бита.
+func (c *huffmanCode) description(dst []byte) []byte {
+	weights := c.weights[:len(c.weights)-1]
+	dst = append(dst, byte(127+len(weights)))
+	for i := 0; i < len(weights); i += 2 {
+		b := weights[i] << 4
+		if i+1 < len(weights) {
+			b |= weights[i+1]
+		}
+		dst = append(dst, b)
+	}
+	return dst
+}
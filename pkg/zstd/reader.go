@@ -0,0 +1,550 @@
+package zstd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// Предопределённые таблицы распаковки последовательностей.
+var (
+	literalsLengthTable = mustFSETable(literalsLengthDefault, literalsLengthDefaultLog)
+	matchLengthTable    = mustFSETable(matchLengthDefault, matchLengthDefaultLog)
+	offsetTable         = mustFSETable(offsetDefault, offsetDefaultLog)
+)
+
+// Reader распаковывает поток из одного или нескольких кадров Zstandard.
+type Reader struct {
+	r   *bufio.Reader
+	err error
+
+	// history - распакованные данные кадра, на которые могут ссылаться совпадения, out - начало непрочитанных
+	history []byte
+	out     int
+	window  int
+
+	last        bool
+	checksum    bool
+	hash        *xxhash
+	hasSize     bool
+	contentSize uint64
+	produced    uint64
+
+	huffman     *huffmanTable
+	literalsLen *fseTable
+	offsets     *fseTable
+	matchLen    *fseTable
+	repeats     [3]int
+	literals    []byte
+	compressed  []byte
+}
+
+// NewReader создаёт Reader и сразу читает заголовок первого кадра, чтобы не принимать за Zstandard посторонние данные.
+func NewReader(r io.Reader) (*Reader, error) {
+	z := &Reader{r: bufio.NewReader(r)}
+	err := z.nextFrame()
+	if err == io.EOF {
+		err = ErrCorrupted
+	}
+	if err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+func (z *Reader) Read(p []byte) (int, error) {
+	for z.out == len(z.history) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.step()
+	}
+	n := copy(p, z.history[z.out:])
+	z.out += n
+	return n, nil
+}
+
+// Close освобождает буферы. Исходный поток Reader не закрывает.
+func (z *Reader) Close() error {
+	z.history = nil
+	z.out = 0
+	if z.err == nil {
+		z.err = io.ErrClosedPipe
+	}
+	return nil
+}
+
+// step распаковывает следующий блок, а после последнего блока кадра проверяет кадр и переходит к следующему.
+func (z *Reader) step() error {
+	if !z.last {
+		return z.block()
+	}
+	if err := z.finishFrame(); err != nil {
+		return err
+	}
+	return z.nextFrame()
+}
+
+// nextFrame читает заголовок следующего кадра, пропуская пользовательские кадры. В конце потока возвращает io.EOF.
+func (z *Reader) nextFrame() error {
+	var header [4]byte
+	for {
+		if _, err := io.ReadFull(z.r, header[:]); err != nil {
+			return eofOrCorrupted(err, true)
+		}
+		magic := binary.LittleEndian.Uint32(header[:])
+		if magic == frameMagic {
+			break
+		}
+		if magic&skippableMagicMask != skippableMagic {
+			return ErrCorrupted
+		}
+		if _, err := io.ReadFull(z.r, header[:]); err != nil {
+			return eofOrCorrupted(err, false)
+		}
+		size := int64(binary.LittleEndian.Uint32(header[:]))
+		if n, err := io.CopyN(io.Discard, z.r, size); n != size {
+			return eofOrCorrupted(err, false)
+		}
+	}
+
+	descriptor, err := z.r.ReadByte()
+	if err != nil {
+		return eofOrCorrupted(err, false)
+	}
+	sizeFlag := descriptor >> 6
+	singleSegment := descriptor>>5&1 == 1
+	if descriptor>>3&1 != 0 {
+		return ErrCorrupted
+	}
+
+	window := 0
+	if !singleSegment {
+		b, err := z.r.ReadByte()
+		if err != nil {
+			return eofOrCorrupted(err, false)
+		}
+		exponent := int(b >> 3)
+		if exponent > 31-10 {
+			return ErrWindowTooLarge
+		}
+		base := 1 << uint(10+exponent)
+		window = base + base/8*int(b&7)
+	}
+
+	dictionary, err := z.readLittleEndian([]int{0, 1, 2, 4}[descriptor&3])
+	if err != nil {
+		return err
+	}
+	if dictionary != 0 {
+		return ErrDictionary
+	}
+
+	sizeBytes := []int{0, 2, 4, 8}[sizeFlag]
+	if sizeFlag == 0 && singleSegment {
+		sizeBytes = 1
+	}
+	z.hasSize = sizeBytes > 0
+	z.contentSize, err = z.readLittleEndian(sizeBytes)
+	if err != nil {
+		return err
+	}
+	if sizeBytes == 2 {
+		z.contentSize += 256
+	}
+	if singleSegment {
+		if z.contentSize > maxWindowSize {
+			return ErrWindowTooLarge
+		}
+		window = int(z.contentSize)
+	}
+	if window > maxWindowSize {
+		return ErrWindowTooLarge
+	}
+
+	z.window = window
+	z.history = z.history[:0]
+	z.out = 0
+	z.last = false
+	z.checksum = descriptor>>2&1 == 1
+	z.hash = newXXHash()
+	z.produced = 0
+	z.huffman = nil
+	z.literalsLen, z.offsets, z.matchLen = nil, nil, nil
+	z.repeats = [3]int{1, 4, 8}
+	return nil
+}
+
+func (z *Reader) readLittleEndian(n int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(z.r, buf[:n]); err != nil {
+		return 0, eofOrCorrupted(err, false)
+	}
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
+
+// finishFrame проверяет размер содержимого и контрольную сумму кадра.
+func (z *Reader) finishFrame() error {
+	if z.hasSize && z.produced != z.contentSize {
+		return ErrCorrupted
+	}
+	if !z.checksum {
+		return nil
+	}
+	var sum [4]byte
+	if _, err := io.ReadFull(z.r, sum[:]); err != nil {
+		return eofOrCorrupted(err, false)
+	}
+	if binary.LittleEndian.Uint32(sum[:]) != uint32(z.hash.sum64()) {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// eofOrCorrupted переводит ошибку чтения: конец потока допустим только между кадрами.
+func eofOrCorrupted(err error, betweenFrames bool) error {
+	if err == io.EOF && betweenFrames {
+		return io.EOF
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF || err == nil {
+		return ErrCorrupted
+	}
+	return err
+}
+
+// block распаковывает следующий блок кадра в history.
+func (z *Reader) block() error {
+	var header [3]byte
+	if _, err := io.ReadFull(z.r, header[:]); err != nil {
+		return eofOrCorrupted(err, false)
+	}
+	value := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	z.last = value&1 == 1
+	size := value >> 3
+	if size > maxBlockSize {
+		return ErrCorrupted
+	}
+
+	// данные старше окна больше не нужны
+	if keep := z.window; len(z.history) > 2*keep+maxBlockSize {
+		z.history = append(z.history[:0], z.history[len(z.history)-keep:]...)
+	}
+	start := len(z.history)
+
+	switch value >> 1 & 3 {
+	case blockRaw:
+		z.history = append(z.history, make([]byte, size)...)
+		if _, err := io.ReadFull(z.r, z.history[start:]); err != nil {
+			return eofOrCorrupted(err, false)
+		}
+	case blockRLE:
+		b, err := z.r.ReadByte()
+		if err != nil {
+			return eofOrCorrupted(err, false)
+		}
+		for i := 0; i < size; i++ {
+			z.history = append(z.history, b)
+		}
+	case blockCompressed:
+		if cap(z.compressed) < size {
+			z.compressed = make([]byte, size)
+		}
+		z.compressed = z.compressed[:size]
+		if _, err := io.ReadFull(z.r, z.compressed); err != nil {
+			return eofOrCorrupted(err, false)
+		}
+		if err := z.decompressBlock(z.compressed); err != nil {
+			return err
+		}
+		if len(z.history)-start > maxBlockSize {
+			return ErrCorrupted
+		}
+	default:
+		return ErrCorrupted
+	}
+
+	z.out = start
+	z.produced += uint64(len(z.history) - start)
+	if z.checksum {
+		z.hash.write(z.history[start:])
+	}
+	return nil
+}
+
+// decompressBlock распаковывает сжатый блок: секцию литералов и секцию последовательностей.
+func (z *Reader) decompressBlock(data []byte) error {
+	n, err := z.readLiterals(data)
+	if err != nil {
+		return err
+	}
+	return z.readSequences(data[n:])
+}
+
+// readLiterals распаковывает секцию литералов в z.literals и возвращает её размер.
+func (z *Reader) readLiterals(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, ErrCorrupted
+	}
+	kind := data[0] & 3
+	format := data[0] >> 2 & 3
+
+	if kind == literalsRaw || kind == literalsRLE {
+		var size, header int
+		switch format {
+		case 0, 2:
+			size, header = int(data[0]>>3), 1
+		case 1:
+			if len(data) < 2 {
+				return 0, ErrCorrupted
+			}
+			size, header = int(data[0]>>4)|int(data[1])<<4, 2
+		case 3:
+			if len(data) < 3 {
+				return 0, ErrCorrupted
+			}
+			size, header = int(data[0]>>4)|int(data[1])<<4|int(data[2])<<12, 3
+		}
+		if size > maxBlockSize {
+			return 0, ErrCorrupted
+		}
+		if kind == literalsRaw {
+			if header+size > len(data) {
+				return 0, ErrCorrupted
+			}
+			z.literals = append(z.literals[:0], data[header:header+size]...)
+			return header + size, nil
+		}
+		if header >= len(data) {
+			return 0, ErrCorrupted
+		}
+		z.literals = z.literals[:0]
+		for i := 0; i < size; i++ {
+			z.literals = append(z.literals, data[header])
+		}
+		return header + 1, nil
+	}
+
+	header, sizeBits, streams := []int{3, 3, 4, 5}[format], []uint{10, 10, 14, 18}[format], 4
+	if format == 0 {
+		streams = 1
+	}
+	if len(data) < header {
+		return 0, ErrCorrupted
+	}
+	var value uint64
+	for i := header - 1; i >= 0; i-- {
+		value = value<<8 | uint64(data[i])
+	}
+	mask := uint64(1)<<sizeBits - 1
+	regenerated := int(value >> 4 & mask)
+	compressed := int(value >> (4 + sizeBits) & mask)
+	if regenerated > maxBlockSize || header+compressed > len(data) {
+		return 0, ErrCorrupted
+	}
+	section := data[header : header+compressed]
+
+	if kind == literalsCompressed {
+		table, n, err := readHuffmanTable(section)
+		if err != nil {
+			return 0, err
+		}
+		z.huffman = table
+		section = section[n:]
+	} else if z.huffman == nil {
+		return 0, ErrCorrupted
+	}
+
+	z.literals = z.literals[:0]
+	var err error
+	if streams == 1 {
+		z.literals, err = z.huffman.decode(z.literals, section, regenerated)
+		if err != nil {
+			return 0, err
+		}
+		return header + compressed, nil
+	}
+
+	if len(section) < 6 {
+		return 0, ErrCorrupted
+	}
+	sizes := [4]int{
+		int(binary.LittleEndian.Uint16(section[0:])),
+		int(binary.LittleEndian.Uint16(section[2:])),
+		int(binary.LittleEndian.Uint16(section[4:])),
+	}
+	section = section[6:]
+	sizes[3] = len(section) - sizes[0] - sizes[1] - sizes[2]
+	segment := (regenerated + 3) / 4
+	if sizes[3] < 0 || regenerated < 3*segment {
+		return 0, ErrCorrupted
+	}
+	for i, size := range sizes {
+		count := segment
+		if i == 3 {
+			count = regenerated - 3*segment
+		}
+		z.literals, err = z.huffman.decode(z.literals, section[:size], count)
+		if err != nil {
+			return 0, err
+		}
+		section = section[size:]
+	}
+	return header + compressed, nil
+}
+
+// readSequences распаковывает секцию последовательностей и выполняет их, дописывая данные в history.
+func (z *Reader) readSequences(data []byte) error {
+	if len(data) == 0 {
+		return ErrCorrupted
+	}
+	count := int(data[0])
+	switch {
+	case count == 0:
+		if len(data) != 1 {
+			return ErrCorrupted
+		}
+		z.history = append(z.history, z.literals...)
+		return nil
+	case count < 128:
+		data = data[1:]
+	case count < 255:
+		if len(data) < 2 {
+			return ErrCorrupted
+		}
+		count = (count-128)<<8 + int(data[1])
+		data = data[2:]
+	default:
+		if len(data) < 3 {
+			return ErrCorrupted
+		}
+		count = int(data[1]) + int(data[2])<<8 + 0x7f00
+		data = data[3:]
+	}
+
+	if len(data) == 0 {
+		return ErrCorrupted
+	}
+	modes := data[0]
+	data = data[1:]
+	if modes&3 != 0 {
+		return ErrCorrupted
+	}
+	var err error
+	var n int
+	if z.literalsLen, n, err = readSequenceTable(data, modes>>6, z.literalsLen, literalsLengthTable, 35, literalsLengthMaxLog); err != nil {
+		return err
+	}
+	data = data[n:]
+	if z.offsets, n, err = readSequenceTable(data, modes>>4&3, z.offsets, offsetTable, maxOffsetCode, offsetMaxLog); err != nil {
+		return err
+	}
+	data = data[n:]
+	if z.matchLen, n, err = readSequenceTable(data, modes>>2&3, z.matchLen, matchLengthTable, 52, matchLengthMaxLog); err != nil {
+		return err
+	}
+	data = data[n:]
+
+	r, err := newBackwardReader(data)
+	if err != nil {
+		return err
+	}
+	literalsState := r.read(z.literalsLen.log)
+	offsetState := r.read(z.offsets.log)
+	matchState := r.read(z.matchLen.log)
+
+	literals := z.literals
+	for i := 0; i < count; i++ {
+		offsetCode := z.offsets.entries[offsetState].symbol
+		matchCode := z.matchLen.entries[matchState].symbol
+		literalsCode := z.literalsLen.entries[literalsState].symbol
+		if offsetCode > maxOffsetCode || int(matchCode) >= len(matchLengthBase) || int(literalsCode) >= len(literalsLengthBase) {
+			return ErrCorrupted
+		}
+
+		offsetValue := 1<<offsetCode + int(r.read(int(offsetCode)))
+		matchLength := int(matchLengthBase[matchCode]) + int(r.read(int(matchLengthBits[matchCode])))
+		literalsLength := int(literalsLengthBase[literalsCode]) + int(r.read(int(literalsLengthBits[literalsCode])))
+
+		if i+1 < count {
+			literalsState = z.literalsLen.next(literalsState, r)
+			matchState = z.matchLen.next(matchState, r)
+			offsetState = z.offsets.next(offsetState, r)
+		}
+
+		offset, err := z.offset(offsetValue, literalsLength)
+		if err != nil {
+			return err
+		}
+		if literalsLength > len(literals) {
+			return ErrCorrupted
+		}
+		z.history = append(z.history, literals[:literalsLength]...)
+		literals = literals[literalsLength:]
+
+		if offset > len(z.history) {
+			return ErrCorrupted
+		}
+		from := len(z.history) - offset
+		if offset >= matchLength {
+			z.history = append(z.history, z.history[from:from+matchLength]...)
+		} else {
+			// совпадение перекрывается с самим собой
+			for j := 0; j < matchLength; j++ {
+				z.history = append(z.history, z.history[from+j])
+			}
+		}
+	}
+	if r.pos != 0 {
+		return ErrCorrupted
+	}
+	z.history = append(z.history, literals...)
+	return nil
+}
+
+// offset переводит значение смещения последовательности в расстояние, обновляя историю повторных смещений.
+func (z *Reader) offset(value int, literalsLength int) (int, error) {
+	if value > 3 {
+		offset := value - 3
+		z.repeats = [3]int{offset, z.repeats[0], z.repeats[1]}
+		return offset, nil
+	}
+	index := value - 1
+	if literalsLength == 0 {
+		index++
+	}
+	switch index {
+	case 0:
+		return z.repeats[0], nil
+	case 1:
+		z.repeats = [3]int{z.repeats[1], z.repeats[0], z.repeats[2]}
+	case 2:
+		z.repeats = [3]int{z.repeats[2], z.repeats[0], z.repeats[1]}
+	default:
+		offset := z.repeats[0] - 1
+		if offset == 0 {
+			return 0, ErrCorrupted
+		}
+		z.repeats = [3]int{offset, z.repeats[0], z.repeats[1]}
+	}
+	return z.repeats[0], nil
+}
+
+// readSequenceTable выбирает таблицу кодов последовательностей по способу mode и возвращает число прочитанных байтов.
+func readSequenceTable(data []byte, mode byte, previous *fseTable, predefined *fseTable, maxSymbol int, maxLog int) (*fseTable, int, error) {
+	switch mode {
+	case modePredefined:
+		return predefined, 0, nil
+	case modeRLE:
+		if len(data) == 0 || int(data[0]) > maxSymbol {
+			return nil, 0, ErrCorrupted
+		}
+		return rleFSETable(data[0]), 1, nil
+	case modeFSE:
+		return readFSETable(data, maxSymbol, maxLog)
+	default:
+		if previous == nil {
+			return nil, 0, ErrCorrupted
+		}
+		return previous, 0, nil
+	}
+}
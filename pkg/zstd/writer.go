@@ -0,0 +1,339 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+var ErrClosed = errors.New("zstd writer is closed")
+
+// Предопределённые таблицы сжатия последовательностей.
+var (
+	literalsLengthEncoder = mustFSEEncoder(literalsLengthDefault, literalsLengthDefaultLog)
+	matchLengthEncoder    = mustFSEEncoder(matchLengthDefault, matchLengthDefaultLog)
+	offsetEncoder         = mustFSEEncoder(offsetDefault, offsetDefaultLog)
+)
+
+const (
+	// windowDescriptor - окно 128 КБ: совпадения ищутся только внутри блока.
+	windowDescriptor = 0x38
+	// checksumFlag - в заголовке кадра: после последнего блока записана контрольная сумма.
+	checksumFlag = 0x04
+
+	minMatch  = 4
+	hashLog   = 16
+	maxStream = 1<<8 - 1
+)
+
+// sequence - литералы длиной literals, за которыми следует совпадение длиной match на расстоянии offset.
+type sequence struct {
+	literals int
+	match    int
+	offset   int
+}
+
+// Writer сжимает данные в один кадр Zstandard. Кадр завершается при вызове Close.
+type Writer struct {
+	w       io.Writer
+	err     error
+	started bool
+	closed  bool
+	hash    *xxhash
+
+	buf       []byte
+	out       []byte
+	literals  []byte
+	sequences []sequence
+	table     []int32
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, hash: newXXHash(), table: make([]int32, 1<<hashLog)}
+}
+
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, ErrClosed
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	z.hash.write(p)
+	z.buf = append(z.buf, p...)
+	// последний блок записывается в Close, поэтому полный блок сжимается, только когда за ним есть данные
+	for len(z.buf) > maxBlockSize {
+		if z.err = z.block(z.buf[:maxBlockSize], false); z.err != nil {
+			return 0, z.err
+		}
+		z.buf = append(z.buf[:0], z.buf[maxBlockSize:]...)
+	}
+	return len(p), nil
+}
+
+// Close записывает последний блок и контрольную сумму. Исходный поток Writer не закрывает.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if z.err != nil {
+		return z.err
+	}
+	if z.err = z.block(z.buf, true); z.err != nil {
+		return z.err
+	}
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], uint32(z.hash.sum64()))
+	_, z.err = z.w.Write(sum[:])
+	return z.err
+}
+
+// block сжимает src и записывает его блоком, перед первым блоком - заголовок кадра.
+func (z *Writer) block(src []byte, last bool) error {
+	z.out = z.out[:0]
+	if !z.started {
+		z.started = true
+		z.out = append(z.out, Magic...)
+		z.out = append(z.out, checksumFlag, windowDescriptor)
+	}
+
+	kind := blockRaw
+	size := len(src)
+	headerAt := len(z.out)
+	z.out = append(z.out, 0, 0, 0)
+	switch {
+	case len(src) > 1 && allEqual(src):
+		kind = blockRLE
+		z.out = append(z.out, src[0])
+	default:
+		z.out = z.compressBlock(z.out, src)
+		if compressed := len(z.out) - headerAt - 3; compressed < len(src) {
+			kind = blockCompressed
+			size = compressed
+		} else {
+			z.out = append(z.out[:headerAt+3], src...)
+		}
+	}
+
+	header := size<<3 | kind<<1
+	if last {
+		header |= 1
+	}
+	z.out[headerAt] = byte(header)
+	z.out[headerAt+1] = byte(header >> 8)
+	z.out[headerAt+2] = byte(header >> 16)
+	_, err := z.w.Write(z.out)
+	return err
+}
+
+func allEqual(src []byte) bool {
+	for _, b := range src[1:] {
+		if b != src[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// compressBlock дописывает к dst сжатое представление src: секцию литералов и секцию последовательностей.
+func (z *Writer) compressBlock(dst []byte, src []byte) []byte {
+	z.findSequences(src)
+	dst = encodeLiterals(dst, z.literals)
+	return encodeSequences(dst, z.sequences)
+}
+
+// findSequences жадно ищет совпадения не короче minMatch по хэшу первых четырёх байтов.
+func (z *Writer) findSequences(src []byte) {
+	for i := range z.table {
+		z.table[i] = 0
+	}
+	z.literals = z.literals[:0]
+	z.sequences = z.sequences[:0]
+
+	anchor := 0
+	for i := 0; i+minMatch <= len(src); {
+		value := binary.LittleEndian.Uint32(src[i:])
+		h := value * 2654435761 >> (32 - hashLog)
+		// в таблице хранится позиция плюс один, ноль - пустая ячейка
+		candidate := int(z.table[h]) - 1
+		z.table[h] = int32(i + 1)
+		if candidate < 0 || binary.LittleEndian.Uint32(src[candidate:]) != value {
+			i++
+			continue
+		}
+		length := minMatch
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+		z.literals = append(z.literals, src[anchor:i]...)
+		z.sequences = append(z.sequences, sequence{literals: i - anchor, match: length, offset: i - candidate})
+		i += length
+		anchor = i
+	}
+	z.literals = append(z.literals, src[anchor:]...)
+}
+
+// encodeLiterals дописывает секцию литералов: сжатую по Хаффману, если это короче, иначе как есть.
+func encodeLiterals(dst []byte, literals []byte) []byte {
+	var freq [256]int
+	for _, b := range literals {
+		freq[b]++
+	}
+	if len(literals) > 1 && freq[literals[0]] == len(literals) {
+		dst = literalsHeader(dst, literalsRLE, len(literals))
+		return append(dst, literals[0])
+	}
+
+	if code := newHuffmanCode(&freq); code != nil {
+		start := len(dst)
+		if dst = encodeHuffmanLiterals(dst, code, literals); len(dst)-start < len(literals) {
+			return dst
+		}
+		dst = dst[:start]
+	}
+	dst = literalsHeader(dst, literalsRaw, len(literals))
+	return append(dst, literals...)
+}
+
+// literalsHeader записывает заголовок несжатых или повторяющихся литералов размером size.
+func literalsHeader(dst []byte, kind byte, size int) []byte {
+	switch {
+	case size < 1<<5:
+		return append(dst, kind|byte(size)<<3)
+	case size < 1<<12:
+		return append(dst, kind|1<<2|byte(size&0xf)<<4, byte(size>>4))
+	default:
+		return append(dst, kind|3<<2|byte(size&0xf)<<4, byte(size>>4), byte(size>>12))
+	}
+}
+
+// encodeHuffmanLiterals записывает литералы, сжатые кодом code: короткие одним потоком, длинные - четырьмя.
+func encodeHuffmanLiterals(dst []byte, code *huffmanCode, literals []byte) []byte {
+	format, headerSize, sizeBits := 0, 3, uint(10)
+	if len(literals) > maxStream {
+		format = 1
+	}
+
+	start := len(dst)
+	dst = append(dst, make([]byte, 5)...)
+	body := len(dst)
+	dst = code.description(dst)
+	if format == 0 {
+		dst = code.encode(dst, literals)
+	} else {
+		jump := len(dst)
+		dst = append(dst, make([]byte, 6)...)
+		segment := (len(literals) + 3) / 4
+		for i := 0; i < 4; i++ {
+			from := i * segment
+			to := from + segment
+			if i == 3 {
+				to = len(literals)
+			}
+			streamStart := len(dst)
+			dst = code.encode(dst, literals[from:to])
+			if i < 3 {
+				binary.LittleEndian.PutUint16(dst[jump+2*i:], uint16(len(dst)-streamStart))
+			}
+		}
+	}
+	compressed := len(dst) - body
+
+	size := len(literals)
+	if compressed > size {
+		size = compressed
+	}
+	switch {
+	case format == 0 || size < 1<<10:
+	case size < 1<<14:
+		format, headerSize, sizeBits = 2, 4, 14
+	default:
+		format, headerSize, sizeBits = 3, 5, 18
+	}
+	if compressed >= 1<<sizeBits {
+		// такие литералы не сжимаются, вызывающий запишет их как есть
+		return dst
+	}
+
+	header := uint64(literalsCompressed) | uint64(format)<<2 | uint64(len(literals))<<4 | uint64(compressed)<<(4+sizeBits)
+	for i := 0; i < headerSize; i++ {
+		dst[start+i] = byte(header >> (8 * uint(i)))
+	}
+	// заголовок короче зарезервированных пяти байтов: сдвигаем тело вплотную к нему
+	copy(dst[start+headerSize:], dst[body:])
+	return dst[:len(dst)-(body-start-headerSize)]
+}
+
+// encodeSequences дописывает секцию последовательностей, сжатых предопределёнными таблицами FSE.
+func encodeSequences(dst []byte, sequences []sequence) []byte {
+	count := len(sequences)
+	switch {
+	case count < 128:
+		dst = append(dst, byte(count))
+	case count < 0x7f00:
+		dst = append(dst, byte(count>>8+128), byte(count))
+	default:
+		dst = append(dst, 255, byte(count-0x7f00), byte((count-0x7f00)>>8))
+	}
+	if count == 0 {
+		return dst
+	}
+	dst = append(dst, modePredefined<<6|modePredefined<<4|modePredefined<<2)
+
+	codes := make([][3]uint8, count)
+	for i, s := range sequences {
+		codes[i] = [3]uint8{literalsLengthCode(s.literals), matchLengthCode(s.match), offsetCode(s.offset)}
+	}
+
+	// распаковщик читает поток с конца, поэтому последовательности записываются в обратном порядке
+	w := bitWriter{buf: dst}
+	var literalsState, matchState, offsetState fseState
+	last := count - 1
+	matchState.init(matchLengthEncoder, codes[last][1])
+	offsetState.init(offsetEncoder, codes[last][2])
+	literalsState.init(literalsLengthEncoder, codes[last][0])
+	writeExtraBits(&w, sequences[last], codes[last])
+	for i := count - 2; i >= 0; i-- {
+		offsetState.encode(&w, codes[i][2])
+		matchState.encode(&w, codes[i][1])
+		literalsState.encode(&w, codes[i][0])
+		writeExtraBits(&w, sequences[i], codes[i])
+	}
+	matchState.flush(&w)
+	offsetState.flush(&w)
+	literalsState.flush(&w)
+	return w.close()
+}
+
+// writeExtraBits записывает дополнительные биты длин и смещения в порядке, обратном чтению.
+func writeExtraBits(w *bitWriter, s sequence, codes [3]uint8) {
+	w.add(uint64(s.literals)-uint64(literalsLengthBase[codes[0]]), uint(literalsLengthBits[codes[0]]))
+	w.add(uint64(s.match)-uint64(matchLengthBase[codes[1]]), uint(matchLengthBits[codes[1]]))
+	// смещения записываются на три больше: значения 1-3 зарезервированы для повторных смещений
+	w.add(uint64(s.offset+3), uint(codes[2]))
+}
+
+func literalsLengthCode(length int) uint8 {
+	if length < 16 {
+		return uint8(length)
+	}
+	code := len(literalsLengthBase) - 1
+	for int(literalsLengthBase[code]) > length {
+		code--
+	}
+	return uint8(code)
+}
+
+func matchLengthCode(length int) uint8 {
+	code := len(matchLengthBase) - 1
+	for int(matchLengthBase[code]) > length {
+		code--
+	}
+	return uint8(code)
+}
+
+func offsetCode(offset int) uint8 {
+	return uint8(bits.Len(uint(offset+3)) - 1)
+}
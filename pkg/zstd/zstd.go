@@ -0,0 +1,116 @@
+// Package zstd читает и записывает данные, сжатые в формате Zstandard (RFC 8878), без внешних зависимостей.
+//
+// Reader распаковывает любые кадры Zstandard без словарей, в том числе записанные утилитой zstd.
+// Writer сжимает данные поиском повторов внутри блока и кодированием литералов по Хаффману,
+// последовательности кодируются предопределёнными таблицами FSE. Поэтому сжатие слабее, чем у утилиты zstd,
+// но результат читает любой распаковщик Zstandard.
+package zstd
+
+import (
+	"errors"
+)
+
+var ErrCorrupted = errors.New("corrupted zstd data")
+var ErrChecksum = errors.New("zstd checksum mismatch")
+var ErrDictionary = errors.New("zstd dictionaries are not supported")
+var ErrWindowTooLarge = errors.New("zstd window is too large")
+
+// Magic - первые байты кадра Zstandard.
+var Magic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+const (
+	frameMagic = 0xfd2fb528
+	// skippableMagic - кадры с пользовательскими данными: 0x184d2a50-0x184d2a5f, их содержимое пропускается.
+	skippableMagic     = 0x184d2a50
+	skippableMagicMask = 0xfffffff0
+
+	// maxBlockSize - наибольший размер блока до и после распаковки.
+	maxBlockSize = 1 << 17
+	// maxWindowSize - наибольшее окно, которое Reader готов держать в памяти.
+	maxWindowSize = 1 << 27
+)
+
+// Типы блоков.
+const (
+	blockRaw        = 0
+	blockRLE        = 1
+	blockCompressed = 2
+)
+
+// Типы секции литералов.
+const (
+	literalsRaw        = 0
+	literalsRLE        = 1
+	literalsCompressed = 2
+	literalsTreeless   = 3
+)
+
+// Способы задания таблиц последовательностей.
+const (
+	modePredefined = 0
+	modeRLE        = 1
+	modeFSE        = 2
+	modeRepeat     = 3
+)
+
+// Коды длин литералов: базовое значение и число дополнительных битов.
+var (
+	literalsLengthBase = [36]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	literalsLengthBits = [36]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+)
+
+// Коды длин совпадений: базовое значение и число дополнительных битов.
+var (
+	matchLengthBase = [53]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	matchLengthBits = [53]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// Предопределённые распределения кодов последовательностей (RFC 8878, 3.1.1.3.2.2).
+var (
+	literalsLengthDefault = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	matchLengthDefault = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	offsetDefault = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+)
+
+const (
+	literalsLengthDefaultLog = 6
+	matchLengthDefaultLog    = 6
+	offsetDefaultLog         = 5
+
+	literalsLengthMaxLog = 9
+	matchLengthMaxLog    = 9
+	offsetMaxLog         = 8
+
+	// maxOffsetCode - наибольший код смещения, который поддерживает Reader.
+	maxOffsetCode = 31
+)
@@ -0,0 +1,198 @@
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// testText возвращает n байтов данных, похожих на дамп счетов.
+func testText(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < n; i++ {
+		fmt.Fprintf(&buf, "%d;account-%d;+99290000%04d;%d\n", i, i*7%13, i%977, i*i%10007)
+	}
+	return buf.Bytes()[:n]
+}
+
+func decompress(data []byte) ([]byte, error) {
+	reader, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+func compress(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatalf("Write(): can't write, %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close(): can't close, %v", err)
+	}
+	return buf.Bytes()
+}
+
+// Файлы testdata записаны утилитой zstd из testText: text.zst - 50000 байт с уровнем 19,
+// small.zst - 100 байт с уровнем 1, frames.zst - пользовательский кадр и два кадра по 100 байт.
+func TestReader_zstdFiles(t *testing.T) {
+	tests := []struct {
+		file string
+		want []byte
+	}{
+		{"text.zst", testText(50000)},
+		{"small.zst", testText(100)},
+		{"frames.zst", append(testText(100), testText(100)...)},
+	}
+	for _, test := range tests {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", test.file))
+		if err != nil {
+			t.Fatalf("ReadFile(%s): can't read, %v", test.file, err)
+		}
+		got, err := decompress(data)
+		if err != nil {
+			t.Errorf("Read(%s): can't decompress, %v", test.file, err)
+			continue
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("Read(%s): decompressed data differs from the original", test.file)
+		}
+	}
+}
+
+func TestWriter_roundTrip(t *testing.T) {
+	random := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(random)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"byte", []byte{1}},
+		{"same", bytes.Repeat([]byte{'a'}, 3*maxBlockSize+5)},
+		{"short", testText(200)},
+		{"text", testText(3*maxBlockSize + 1000)},
+		{"random", random},
+	}
+	for _, test := range tests {
+		compressed := compress(t, test.data)
+		got, err := decompress(compressed)
+		if err != nil {
+			t.Errorf("%s: can't decompress, %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(got, test.data) {
+			t.Errorf("%s: decompressed data differs from the original", test.name)
+		}
+	}
+
+	text := testText(100000)
+	if compressed := compress(t, text); len(compressed) > len(text)/2 {
+		t.Errorf("Write(): text must compress at least twice, %d of %d bytes", len(compressed), len(text))
+	}
+}
+
+func TestWriter_smallWrites(t *testing.T) {
+	data := testText(maxBlockSize + 5000)
+	var buf bytes.Buffer
+	writer := NewWriter(&buf)
+	for i := 0; i < len(data); i += 1000 {
+		end := i + 1000
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err := writer.Write(data[i:end]); err != nil {
+			t.Fatalf("Write(): can't write, %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close(): can't close, %v", err)
+	}
+	if _, err := writer.Write(data); !errors.Is(err, ErrClosed) {
+		t.Errorf("Write(): must return ErrClosed after Close, returned %v", err)
+	}
+
+	got, err := decompress(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Read(): can't decompress, %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("Read(): decompressed data differs from the original")
+	}
+}
+
+func TestReader_invalid(t *testing.T) {
+	valid := compress(t, testText(5000))
+
+	checksum := append([]byte{}, valid...)
+	checksum[len(checksum)-1] ^= 0xff
+	corrupted := append([]byte{}, valid...)
+	corrupted[len(corrupted)/2] ^= 0xff
+	dictionary := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x01, 0x38, 0x07}
+	window := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0xf8}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrCorrupted},
+		{"not zstd", []byte("id;name"), ErrCorrupted},
+		{"truncated", valid[:len(valid)-10], ErrCorrupted},
+		{"checksum", checksum, ErrChecksum},
+		{"corrupted", corrupted, ErrCorrupted},
+		{"dictionary", dictionary, ErrDictionary},
+		{"window", window, ErrWindowTooLarge},
+	}
+	for _, test := range tests {
+		_, err := decompress(test.data)
+		if test.name == "corrupted" && errors.Is(err, ErrChecksum) {
+			// испорченный байт мог оказаться в литералах
+			continue
+		}
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: must return %v, returned %v", test.name, test.want, err)
+		}
+	}
+}
+
+func TestReader_EOF(t *testing.T) {
+	reader, err := NewReader(bytes.NewReader(compress(t, []byte("wallet"))))
+	if err != nil {
+		t.Fatalf("NewReader(): can't create, %v", err)
+	}
+	buf := make([]byte, 16)
+	n, err := reader.Read(buf)
+	if err != nil || string(buf[:n]) != "wallet" {
+		t.Fatalf("Read(): want wallet, result %q, %v", buf[:n], err)
+	}
+	if _, err := reader.Read(buf); err != io.EOF {
+		t.Errorf("Read(): must return io.EOF at the end, returned %v", err)
+	}
+}
+
+func TestXXHash(t *testing.T) {
+	tests := []struct {
+		data string
+		want uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+	}
+	for _, test := range tests {
+		hash := newXXHash()
+		hash.write([]byte(test.data))
+		if got := hash.sum64(); got != test.want {
+			t.Errorf("sum64(%q): want %x, result %x", test.data, test.want, got)
+		}
+	}
+}